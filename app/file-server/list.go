@@ -0,0 +1,153 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"time"
+
+	"k8s.io/klog/v2"
+)
+
+// entry is a file of a listing.
+type entry struct {
+	Path    string    `json:"path"`
+	Type    string    `json:"type"`
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"mtime"`
+	Mode    string    `json:"mode"`
+	// Target of a symlink.
+	Target string `json:"target,omitempty"`
+}
+
+func entryOf(file, name string, info os.FileInfo) entry {
+	e := entry{
+		Path:    name,
+		Type:    "other",
+		Size:    info.Size(),
+		ModTime: info.ModTime().UTC(),
+		Mode:    info.Mode().Perm().String(),
+	}
+	switch {
+	case info.Mode().IsRegular():
+		e.Type = "file"
+	case info.IsDir():
+		e.Type = "dir"
+		e.Size = 0
+	case info.Mode()&os.ModeSymlink != 0:
+		e.Type = "symlink"
+		e.Target, _ = os.Readlink(file)
+	}
+	return e
+}
+
+// serveList lists a directory as JSON array, with `recursive=true` the
+// whole tree. The array is streamed, so large trees aren't held in memory.
+func (s *server) serveList(w http.ResponseWriter, r *http.Request) {
+	name := path.Clean("/" + r.URL.Query().Get("path"))
+	recursive := r.URL.Query().Get("recursive") == "true"
+	file, info, ok := s.open(w, name)
+	if !ok {
+		return
+	}
+	defer file.Close()
+	real, _ := s.resolve(name)
+
+	w.Header().Set("Content-Type", "application/json")
+	encoder := json.NewEncoder(w)
+	first := true
+	write := func(e entry) error {
+		sep := ","
+		if first {
+			sep, first = "[", false
+		}
+		if _, err := io.WriteString(w, sep); err != nil {
+			return err
+		}
+		return encoder.Encode(e)
+	}
+
+	var err error
+	switch {
+	case !info.IsDir():
+		err = write(entryOf(real, info.Name(), info))
+	case recursive:
+		err = filepath.Walk(real, func(file string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			rel, err := filepath.Rel(real, file)
+			if err != nil || rel == "." {
+				return err
+			}
+			return write(entryOf(file, filepath.ToSlash(rel), info))
+		})
+	default:
+		var entries []os.DirEntry
+		if entries, err = file.ReadDir(-1); err == nil {
+			for _, de := range entries {
+				var info os.FileInfo
+				if info, err = de.Info(); err != nil {
+					if !os.IsNotExist(err) {
+						break
+					}
+					// The entry was removed since the directory was read.
+					err = nil
+					continue
+				}
+				if err = write(entryOf(filepath.Join(real, de.Name()), de.Name(), info)); err != nil {
+					break
+				}
+			}
+		}
+	}
+	if err != nil {
+		// The stream ends with invalid JSON, so clients notice the failure.
+		klog.Errorf("Error listing `%s`: %s", name, err)
+		return
+	}
+	if first {
+		io.WriteString(w, "[")
+	}
+	io.WriteString(w, "]\n")
+}
+
+// checksum is the response of /sha256.
+type checksum struct {
+	Path   string `json:"path"`
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+}
+
+// serveSHA256 computes the SHA-256 of a file on demand.
+func (s *server) serveSHA256(w http.ResponseWriter, r *http.Request) {
+	name := path.Clean("/" + r.URL.Query().Get("path"))
+	file, info, ok := s.open(w, name)
+	if !ok {
+		return
+	}
+	defer file.Close()
+	if !info.Mode().IsRegular() {
+		http.Error(w, fmt.Sprintf("`%s` isn't a regular file", name), http.StatusBadRequest)
+		return
+	}
+	hash := sha256.New()
+	size, err := io.Copy(hash, file)
+	if err != nil {
+		klog.Errorf("Error hashing `%s`: %s", name, err)
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(checksum{
+		Path:   name,
+		Size:   size,
+		SHA256: hex.EncodeToString(hash.Sum(nil)),
+	})
+}
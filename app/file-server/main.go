@@ -0,0 +1,32 @@
+package main
+
+import (
+	"flag"
+	"net/http"
+	"os"
+
+	"k8s.io/klog/v2"
+)
+
+// file-server serves a mounted volume over HTTP for clients without rsync.
+// It is run by the rsync source controller for sources with the HTTP
+// backend.
+func main() {
+	klog.InitFlags(nil)
+	listen := flag.String("listen", ":8080", "Address the server listens on")
+	root := flag.String("root", "/srv", "Directory that is served")
+	flag.Parse()
+
+	username, password := os.Getenv("FILE_SERVER_USER"), os.Getenv("FILE_SERVER_PASSWORD")
+	if username == "" || password == "" {
+		klog.Fatal("FILE_SERVER_USER and FILE_SERVER_PASSWORD must be set")
+	}
+	s, err := newServer(*root, username, password)
+	if err != nil {
+		klog.Fatalf("Failed to create server: %v", err)
+	}
+	klog.Infof("Serving %s on %s", *root, *listen)
+	if err := http.ListenAndServe(*listen, s.routes()); err != nil {
+		klog.Fatalf("Failed to serve: %v", err)
+	}
+}
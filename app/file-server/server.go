@@ -0,0 +1,132 @@
+package main
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"k8s.io/klog/v2"
+)
+
+type server struct {
+	// root is the served directory with symlinks resolved.
+	root     string
+	username string
+	password string
+}
+
+func newServer(root, username, password string) (*server, error) {
+	// The served volume is never exposed without authentication.
+	if username == "" || password == "" {
+		return nil, fmt.Errorf("a username and a password are required")
+	}
+	root, err := filepath.EvalSymlinks(root)
+	if err != nil {
+		return nil, err
+	}
+	root, err = filepath.Abs(root)
+	if err != nil {
+		return nil, err
+	}
+	return &server{
+		root:     root,
+		username: username,
+		password: password,
+	}, nil
+}
+
+// routes returns the handler of the server:
+//
+//	GET /files/<path>                         the file, with Range support
+//	GET /tar?path=<path>&compression=gzip|zstd a tar stream of the file or directory
+//	GET /list?path=<path>&recursive=true      names, sizes and mtimes as JSON
+//	GET /sha256?path=<path>                   the SHA-256 of a file
+//	GET /healthz                              readiness, without authentication
+func (s *server) routes() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	})
+	mux.Handle("/files/", s.authenticated(http.HandlerFunc(s.serveFile)))
+	mux.Handle("/tar", s.authenticated(http.HandlerFunc(s.serveTar)))
+	mux.Handle("/list", s.authenticated(http.HandlerFunc(s.serveList)))
+	mux.Handle("/sha256", s.authenticated(http.HandlerFunc(s.serveSHA256)))
+	return mux
+}
+
+// authenticated checks the basic auth credentials of a request.
+func (s *server) authenticated(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		username, password, ok := r.BasicAuth()
+		if !ok ||
+			subtle.ConstantTimeCompare([]byte(username), []byte(s.username)) != 1 ||
+			subtle.ConstantTimeCompare([]byte(password), []byte(s.password)) != 1 {
+			w.Header().Set("WWW-Authenticate", `Basic realm="file-server"`)
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// resolve returns the file of a request path. Symlinks are followed as long
+// as they stay below the root.
+func (s *server) resolve(name string) (string, error) {
+	full := filepath.Join(s.root, filepath.FromSlash(path.Clean("/"+name)))
+	real, err := filepath.EvalSymlinks(full)
+	if err != nil {
+		return "", err
+	}
+	if real != s.root && !strings.HasPrefix(real, s.root+string(filepath.Separator)) {
+		return "", os.ErrNotExist
+	}
+	return real, nil
+}
+
+func (s *server) serveFile(w http.ResponseWriter, r *http.Request) {
+	name := strings.TrimPrefix(r.URL.Path, "/files")
+	file, info, ok := s.open(w, name)
+	if !ok {
+		return
+	}
+	defer file.Close()
+	if info.IsDir() {
+		http.Error(w, fmt.Sprintf("`%s` is a directory, use /list?path=%s or /tar?path=%s", name, name, name),
+			http.StatusBadRequest)
+		return
+	}
+	http.ServeContent(w, r, info.Name(), info.ModTime(), file)
+}
+
+// open opens a file and writes the error response if it can't.
+func (s *server) open(w http.ResponseWriter, name string) (*os.File, os.FileInfo, bool) {
+	real, err := s.resolve(name)
+	if err == nil {
+		var file *os.File
+		if file, err = os.Open(real); err == nil {
+			var info os.FileInfo
+			if info, err = file.Stat(); err == nil {
+				return file, info, true
+			}
+			file.Close()
+		}
+	}
+	switch {
+	case os.IsNotExist(err):
+		http.Error(w, "not found", http.StatusNotFound)
+	case os.IsPermission(err):
+		http.Error(w, "forbidden", http.StatusForbidden)
+	default:
+		klog.Errorf("Error opening `%s`: %s", name, err)
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+	}
+	return nil, nil, false
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestResolve(t *testing.T) {
+	dir := t.TempDir()
+	root := filepath.Join(dir, "root")
+	for _, d := range []string{root + "/data/sub", dir + "/outside"} {
+		if err := os.MkdirAll(d, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, f := range []string{root + "/data/file", dir + "/outside/secret"} {
+		if err := os.WriteFile(f, []byte("x"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for link, target := range map[string]string{
+		root + "/data/inside":  "sub",
+		root + "/data/escape":  "../../outside/secret",
+		root + "/data/absdir":  dir + "/outside",
+		root + "/data/rootdir": "..",
+	} {
+		if err := os.Symlink(target, link); err != nil {
+			t.Fatal(err)
+		}
+	}
+	s, err := newServer(root, "user", "pass")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name    string
+		path    string
+		want    string
+		wantErr bool
+	}{
+		{name: "root", path: "/", want: s.root},
+		{name: "file", path: "/data/file", want: s.root + "/data/file"},
+		{name: "without leading slash", path: "data/file", want: s.root + "/data/file"},
+		{name: "dot dot is cleaned below the root", path: "/../../data/file", want: s.root + "/data/file"},
+		{name: "symlink below the root", path: "/data/inside", want: s.root + "/data/sub"},
+		{name: "symlink to the root", path: "/data/rootdir", want: s.root},
+		{name: "relative symlink out of the root", path: "/data/escape", wantErr: true},
+		{name: "absolute symlink out of the root", path: "/data/absdir/secret", wantErr: true},
+		{name: "missing file", path: "/data/missing", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := s.resolve(tt.path)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("resolve() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("resolve() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewServerRequiresCredentials(t *testing.T) {
+	tests := []struct {
+		name     string
+		username string
+		password string
+		wantErr  bool
+	}{
+		{name: "credentials", username: "user", password: "pass"},
+		{name: "no username", password: "pass", wantErr: true},
+		{name: "no password", username: "user", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := newServer(t.TempDir(), tt.username, tt.password); (err != nil) != tt.wantErr {
+				t.Errorf("newServer() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+
+	"github.com/klauspost/compress/zstd"
+	"k8s.io/klog/v2"
+)
+
+// compressions of a tar stream, selected with the `compression` parameter.
+var compressions = map[string]struct {
+	contentType string
+	extension   string
+	writer      func(io.Writer) (io.WriteCloser, error)
+}{
+	"": {
+		contentType: "application/x-tar",
+		extension:   ".tar",
+		writer:      func(w io.Writer) (io.WriteCloser, error) { return nopCloser{w}, nil },
+	},
+	"gzip": {
+		contentType: "application/gzip",
+		extension:   ".tar.gz",
+		writer:      func(w io.Writer) (io.WriteCloser, error) { return gzip.NewWriter(w), nil },
+	},
+	"zstd": {
+		contentType: "application/zstd",
+		extension:   ".tar.zst",
+		writer: func(w io.Writer) (io.WriteCloser, error) {
+			return zstd.NewWriter(w, zstd.WithEncoderConcurrency(1))
+		},
+	},
+}
+
+type nopCloser struct {
+	io.Writer
+}
+
+func (nopCloser) Close() error { return nil }
+
+// serveTar streams a tar of a file or directory. Entries are named relative
+// to the requested path. Symlinks are archived as links, not followed.
+func (s *server) serveTar(w http.ResponseWriter, r *http.Request) {
+	name := path.Clean("/" + r.URL.Query().Get("path"))
+	compression, ok := compressions[r.URL.Query().Get("compression")]
+	if !ok {
+		http.Error(w, "compression must be gzip or zstd", http.StatusBadRequest)
+		return
+	}
+	file, info, ok := s.open(w, name)
+	if !ok {
+		return
+	}
+	file.Close()
+	real, _ := s.resolve(name)
+
+	base := path.Base(name)
+	if base == "/" {
+		base = "root"
+	}
+	w.Header().Set("Content-Type", compression.contentType)
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", base+compression.extension))
+	if r.Method == http.MethodHead {
+		return
+	}
+
+	cw, err := compression.writer(w)
+	if err != nil {
+		klog.Errorf("Error compressing tar of `%s`: %s", name, err)
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+	tw := tar.NewWriter(cw)
+	if info.IsDir() {
+		err = filepath.Walk(real, func(file string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			rel, err := filepath.Rel(real, file)
+			if err != nil || rel == "." {
+				return err
+			}
+			return writeTarEntry(tw, file, filepath.ToSlash(rel), info)
+		})
+	} else {
+		err = writeTarEntry(tw, real, info.Name(), info)
+	}
+	if err != nil {
+		// The status was sent already. The stream ends without the tar
+		// trailer, so clients notice the failure.
+		klog.Errorf("Error writing tar of `%s`: %s", name, err)
+		return
+	}
+	if err := tw.Close(); err != nil {
+		klog.Errorf("Error writing tar of `%s`: %s", name, err)
+		return
+	}
+	if err := cw.Close(); err != nil {
+		klog.Errorf("Error writing tar of `%s`: %s", name, err)
+	}
+}
+
+func writeTarEntry(tw *tar.Writer, file, name string, info os.FileInfo) error {
+	link := ""
+	switch {
+	case info.Mode().IsRegular(), info.IsDir():
+	case info.Mode()&os.ModeSymlink != 0:
+		var err error
+		if link, err = os.Readlink(file); err != nil {
+			return err
+		}
+	default:
+		// Sockets, devices and pipes aren't archived.
+		return nil
+	}
+	header, err := tar.FileInfoHeader(info, link)
+	if err != nil {
+		return err
+	}
+	header.Name = name
+	if info.IsDir() {
+		header.Name += "/"
+	}
+	if err := tw.WriteHeader(header); err != nil {
+		return err
+	}
+	if !info.Mode().IsRegular() {
+		return nil
+	}
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = io.Copy(tw, f)
+	return err
+}
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/klog/v2"
+
+	"github.com/k8s-volume-copy/types/constant"
+)
+
+const (
+	// expiredAnnotation is set by the rsync-source controller while the
+	// source is scaled to zero, removing it starts the daemon again.
+	expiredAnnotation = "demo.io/expired"
+
+	pollInterval = time.Second
+)
+
+var rsyncSourceGVR = schema.GroupVersionResource{
+	Group:    constant.GroupDemoIO,
+	Version:  constant.VersionV1,
+	Resource: constant.RsyncSourceResource,
+}
+
+type portPair struct {
+	listen  string
+	backend string
+}
+
+type activator struct {
+	namespace     string
+	name          string
+	backend       string
+	ports         []portPair
+	healthListen  string
+	readyTimeout  time.Duration
+	kubeClient    kubernetes.Interface
+	dynamicClient dynamic.Interface
+
+	// lock guards ready, which is closed once the daemon is ready. Waiting
+	// connections share one wake up.
+	lock  sync.Mutex
+	ready chan struct{}
+}
+
+func (a *activator) run() error {
+	errCh := make(chan error, len(a.ports)+1)
+	// The readiness probe only checks that the activator serves, a
+	// connection to a service port would wake the source.
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "ok")
+	})
+	go func() {
+		errCh <- http.ListenAndServe(a.healthListen, mux)
+	}()
+	for _, p := range a.ports {
+		ln, err := net.Listen("tcp", ":"+p.listen)
+		if err != nil {
+			return err
+		}
+		klog.Infof("Activating rsync source `%s` in `%s` namespace on %s for %s:%s",
+			a.name, a.namespace, p.listen, a.backend, p.backend)
+		go func(ln net.Listener, backend string) {
+			for {
+				conn, err := ln.Accept()
+				if err != nil {
+					errCh <- err
+					return
+				}
+				go a.handle(conn, net.JoinHostPort(a.backend, backend))
+			}
+		}(ln, p.backend)
+	}
+	return <-errCh
+}
+
+func (a *activator) handle(client net.Conn, backendAddr string) {
+	defer client.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), a.readyTimeout)
+	defer cancel()
+	if err := a.waitReady(ctx); err != nil {
+		klog.Errorf("Rsync source `%s` in `%s` namespace didn't become ready for %s: %v",
+			a.name, a.namespace, client.RemoteAddr(), err)
+		return
+	}
+	// The Service of the daemon may need a moment to pick up its endpoints.
+	var backend net.Conn
+	err := retry(ctx, func() (err error) {
+		backend, err = net.DialTimeout("tcp", backendAddr, 5*time.Second)
+		return err
+	})
+	if err != nil {
+		klog.Errorf("Failed to connect %s to %s: %v", client.RemoteAddr(), backendAddr, err)
+		return
+	}
+	defer backend.Close()
+	klog.V(2).Infof("Forwarding %s to %s", client.RemoteAddr(), backendAddr)
+	pipe(client, backend)
+}
+
+// waitReady wakes the source and waits until its daemon is ready.
+func (a *activator) waitReady(ctx context.Context) error {
+	a.lock.Lock()
+	ready := a.ready
+	if ready == nil {
+		ready = make(chan struct{})
+		a.ready = ready
+		go a.wake(ready)
+	}
+	a.lock.Unlock()
+	select {
+	case <-ready:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// wake removes the expired annotation of the source and closes ready once
+// its deployment has a ready replica. Later connections wake the source
+// again, as it may have been scaled to zero meanwhile.
+func (a *activator) wake(ready chan struct{}) {
+	ctx, cancel := context.WithTimeout(context.Background(), a.readyTimeout)
+	defer cancel()
+	err := retry(ctx, func() error {
+		if err := a.removeExpired(ctx); err != nil {
+			return err
+		}
+		deployment, err := a.kubeClient.AppsV1().Deployments(a.namespace).
+			Get(ctx, a.name, metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+		if deployment.Status.ReadyReplicas == 0 {
+			return fmt.Errorf("deployment `%s` has no ready replica", a.name)
+		}
+		return nil
+	})
+	a.lock.Lock()
+	a.ready = nil
+	a.lock.Unlock()
+	if err != nil {
+		klog.Errorf("Failed to wake rsync source `%s` in `%s` namespace: %v", a.name, a.namespace, err)
+		return
+	}
+	close(ready)
+}
+
+func (a *activator) removeExpired(ctx context.Context) error {
+	obj, err := a.dynamicClient.Resource(rsyncSourceGVR).Namespace(a.namespace).
+		Get(ctx, a.name, metav1.GetOptions{})
+	if err != nil {
+		return err
+	}
+	if _, ok := obj.GetAnnotations()[expiredAnnotation]; !ok {
+		return nil
+	}
+	klog.Infof("Waking rsync source `%s` in `%s` namespace", a.name, a.namespace)
+	patch := []byte(fmt.Sprintf(`{"metadata":{"annotations":{%q:null}}}`, expiredAnnotation))
+	_, err = a.dynamicClient.Resource(rsyncSourceGVR).Namespace(a.namespace).
+		Patch(ctx, a.name, types.MergePatchType, patch, metav1.PatchOptions{})
+	if errors.IsNotFound(err) {
+		return nil
+	}
+	return err
+}
+
+// retry calls f until it succeeds or ctx is done.
+func retry(ctx context.Context, f func() error) error {
+	for {
+		err := f()
+		if err == nil {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(pollInterval):
+		}
+	}
+}
+
+func pipe(client, backend net.Conn) {
+	wg := sync.WaitGroup{}
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		io.Copy(backend, client)
+		closeWrite(backend)
+	}()
+	go func() {
+		defer wg.Done()
+		io.Copy(client, backend)
+		closeWrite(client)
+	}()
+	wg.Wait()
+}
+
+func closeWrite(conn net.Conn) {
+	if c, ok := conn.(interface{ CloseWrite() error }); ok {
+		c.CloseWrite()
+		return
+	}
+	conn.Close()
+}
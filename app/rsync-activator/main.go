@@ -0,0 +1,65 @@
+package main
+
+import (
+	"flag"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+	"k8s.io/client-go/util/homedir"
+	"k8s.io/klog/v2"
+)
+
+// rsync-activator answers the clients of a rsync source that was scaled to
+// zero. It owns the Service of the source while the daemon is down, wakes
+// the source on the first connection and forwards the connections to the
+// daemon once it is ready.
+func main() {
+	klog.InitFlags(nil)
+	var kubeconfig *string
+	if home := homedir.HomeDir(); home != "" {
+		kubeconfig = flag.String("kubeconfig", filepath.Join(home, ".kube", "config"),
+			"(optional) absolute path to the kubeconfig file")
+	} else {
+		kubeconfig = flag.String("kubeconfig", "", "absolute path to the kubeconfig file")
+	}
+	a := &activator{}
+	flag.StringVar(&a.namespace, "namespace", "", "Namespace of the rsync source")
+	flag.StringVar(&a.name, "name", "", "Name of the rsync source")
+	flag.StringVar(&a.backend, "backend", "", "Host of the Service of the daemon pods")
+	ports := flag.String("ports", "", "Comma separated listen:backend port pairs, e.g. 10000:873")
+	flag.DurationVar(&a.readyTimeout, "ready-timeout", 5*time.Minute, "Time a connection waits for the daemon")
+	flag.StringVar(&a.healthListen, "health-listen", ":9091", "Address of the health endpoint, which doesn't wake the source")
+	flag.Parse()
+	if a.namespace == "" || a.name == "" || a.backend == "" || *ports == "" {
+		klog.Fatalf("--namespace, --name, --backend and --ports are required")
+	}
+	for _, pair := range strings.Split(*ports, ",") {
+		parts := strings.Split(pair, ":")
+		if len(parts) != 2 {
+			klog.Fatalf("invalid port pair `%s`, expected listen:backend", pair)
+		}
+		a.ports = append(a.ports, portPair{listen: parts[0], backend: parts[1]})
+	}
+
+	cfg, err := clientcmd.BuildConfigFromFlags("", *kubeconfig)
+	if err != nil {
+		cfg, err = rest.InClusterConfig()
+		if err != nil {
+			klog.Fatalf("error getting k8s config error: %s", err)
+		}
+	}
+	if a.kubeClient, err = kubernetes.NewForConfig(cfg); err != nil {
+		klog.Fatalf("Failed to create kube client: %v", err)
+	}
+	if a.dynamicClient, err = dynamic.NewForConfig(cfg); err != nil {
+		klog.Fatalf("Failed to create dynamic client: %v", err)
+	}
+	if err := a.run(); err != nil {
+		klog.Fatalf("Failed to run activator: %v", err)
+	}
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"bufio"
+	"io"
+	"os"
+	"time"
+)
+
+// follow calls handle for every line of file, like `tail -F`. The file is
+// read from the start, it may not exist yet and is reopened when it is
+// truncated or replaced.
+func follow(file string, interval time.Duration, handle func(string)) error {
+	var f *os.File
+	var reader *bufio.Reader
+	var offset int64
+	partial := ""
+	for {
+		if f == nil {
+			var err error
+			if f, err = os.Open(file); err != nil {
+				if !os.IsNotExist(err) {
+					return err
+				}
+				time.Sleep(interval)
+				continue
+			}
+			reader, offset, partial = bufio.NewReader(f), 0, ""
+		}
+		line, err := reader.ReadString('\n')
+		offset += int64(len(line))
+		if err == nil {
+			handle(partial + line[:len(line)-1])
+			partial = ""
+			continue
+		}
+		if err != io.EOF {
+			return err
+		}
+		// A line is only handled once it is complete.
+		partial += line
+		time.Sleep(interval)
+		if rotated(f, file, offset) {
+			f.Close()
+			f = nil
+		}
+	}
+}
+
+// rotated returns whether file was replaced or truncated since it was
+// opened as f.
+func rotated(f *os.File, file string, offset int64) bool {
+	current, err := os.Stat(file)
+	if err != nil {
+		return false
+	}
+	opened, err := f.Stat()
+	if err != nil {
+		return true
+	}
+	return !os.SameFile(current, opened) || current.Size() < offset
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"flag"
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"k8s.io/klog/v2"
+)
+
+// rsync-log-shipper runs next to the rsync daemon. It tails the daemon log,
+// writes every transfer and session as JSON line to stdout, exports
+// Prometheus metrics and serves the active and recent sessions.
+func main() {
+	klog.InitFlags(nil)
+	logFile := flag.String("log-file", "/var/log/rsyncd/rsyncd.log", "Log file of the rsync daemon")
+	listen := flag.String("listen", ":9090", "Address /metrics and /sessions are served on")
+	recent := flag.Int("recent-sessions", 20, "Number of finished sessions kept for /sessions")
+	flag.Parse()
+
+	t := newTracker(*recent)
+	go t.pruneLoop(10 * time.Second)
+	go func() {
+		if err := follow(*logFile, time.Second, t.handleLine); err != nil {
+			klog.Fatalf("Failed to follow `%s`: %v", *logFile, err)
+		}
+	}()
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.Handler())
+	mux.HandleFunc("/sessions", t.serveSessions)
+	if err := http.ListenAndServe(*listen, mux); err != nil {
+		klog.Fatalf("Failed to serve: %v", err)
+	}
+}
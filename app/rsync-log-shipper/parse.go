@@ -0,0 +1,122 @@
+package main
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The daemon is configured with
+//
+//	log format = transfer %o %a %m %l %b %f
+//
+// so every transferred file is logged as
+//
+//	2006/01/02 15:04:05 [pid] transfer send 10.0.0.1 data 1024 1024 dir/file
+//
+// A session starts with `rsync on|to <module>/<path> from <user>@<host> (<ip>)`
+// and ends with `sent <n> bytes  received <n> bytes  total size <n>`.
+const timeLayout = "2006/01/02 15:04:05"
+
+var (
+	lineRegexp     = regexp.MustCompile(`^(\d{4}/\d{2}/\d{2} \d{2}:\d{2}:\d{2}) \[(\d+)\] (.*)$`)
+	transferRegexp = regexp.MustCompile(`^transfer (\S+) (\S+) (\S+) (\d+) (\d+) (.*)$`)
+	startRegexp    = regexp.MustCompile(`^rsync (on|to) ([^/\s]+)\S* from (\S+) \(([^)]*)\)`)
+	endRegexp      = regexp.MustCompile(`^sent (\d+) bytes\s+received (\d+) bytes\s+total size (\d+)`)
+)
+
+// errorPrefixes end a session that doesn't log its totals.
+var errorPrefixes = []string{"rsync error:", "auth failed on module", "rsync denied on module", "unknown module"}
+
+type event int
+
+const (
+	eventNone event = iota
+	eventStart
+	eventTransfer
+	eventEnd
+	eventError
+)
+
+// logLine is a parsed line of the daemon log.
+type logLine struct {
+	event   event
+	time    time.Time
+	pid     int
+	message string
+	// set for eventStart, the direction is `send` or `recv` like the
+	// operation of a transfer.
+	direction string
+	module    string
+	user      string
+	client    string
+	// set for eventTransfer
+	transfer transfer
+	// set for eventEnd
+	sent     int64
+	received int64
+}
+
+// transfer is a transferred file.
+type transfer struct {
+	Time      time.Time `json:"time"`
+	PID       int       `json:"pid"`
+	Operation string    `json:"operation"`
+	Client    string    `json:"client"`
+	User      string    `json:"user,omitempty"`
+	Module    string    `json:"module"`
+	File      string    `json:"file"`
+	Size      int64     `json:"size"`
+	Bytes     int64     `json:"bytes"`
+}
+
+func parseLine(raw string) (logLine, bool) {
+	m := lineRegexp.FindStringSubmatch(raw)
+	if m == nil {
+		return logLine{}, false
+	}
+	l := logLine{message: m[3]}
+	l.time, _ = time.ParseInLocation(timeLayout, m[1], time.Local)
+	l.pid, _ = strconv.Atoi(m[2])
+	if t := transferRegexp.FindStringSubmatch(l.message); t != nil {
+		l.event = eventTransfer
+		size, _ := strconv.ParseInt(t[4], 10, 64)
+		bytes, _ := strconv.ParseInt(t[5], 10, 64)
+		l.transfer = transfer{
+			Time:      l.time,
+			PID:       l.pid,
+			Operation: t[1],
+			Client:    t[2],
+			Module:    t[3],
+			Size:      size,
+			Bytes:     bytes,
+			File:      t[6],
+		}
+		return l, true
+	}
+	if s := startRegexp.FindStringSubmatch(l.message); s != nil {
+		l.event = eventStart
+		l.direction, l.module, l.client = "send", s[2], s[4]
+		if s[1] == "to" {
+			l.direction = "recv"
+		}
+		if i := strings.Index(s[3], "@"); i >= 0 {
+			l.user = s[3][:i]
+		}
+		return l, true
+	}
+	if e := endRegexp.FindStringSubmatch(l.message); e != nil {
+		l.event = eventEnd
+		l.sent, _ = strconv.ParseInt(e[1], 10, 64)
+		l.received, _ = strconv.ParseInt(e[2], 10, 64)
+		return l, true
+	}
+	for _, prefix := range errorPrefixes {
+		if strings.HasPrefix(l.message, prefix) {
+			l.event = eventError
+			return l, true
+		}
+	}
+	return l, true
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParseLine(t *testing.T) {
+	at := time.Date(2024, 5, 6, 7, 8, 9, 0, time.Local)
+	tests := []struct {
+		name string
+		raw  string
+		want logLine
+		ok   bool
+	}{
+		{
+			name: "not a daemon log line",
+			raw:  "garbage",
+		},
+		{
+			name: "transfer",
+			raw:  "2024/05/06 07:08:09 [42] transfer send 10.0.0.1 data 1024 512 dir/file name",
+			want: logLine{
+				event:   eventTransfer,
+				time:    at,
+				pid:     42,
+				message: "transfer send 10.0.0.1 data 1024 512 dir/file name",
+				transfer: transfer{
+					Time:      at,
+					PID:       42,
+					Operation: "send",
+					Client:    "10.0.0.1",
+					Module:    "data",
+					File:      "dir/file name",
+					Size:      1024,
+					Bytes:     512,
+				},
+			},
+			ok: true,
+		},
+		{
+			name: "download starts",
+			raw:  "2024/05/06 07:08:09 [42] rsync on data/dir from user@client.example (10.0.0.1)",
+			want: logLine{
+				event:     eventStart,
+				time:      at,
+				pid:       42,
+				message:   "rsync on data/dir from user@client.example (10.0.0.1)",
+				direction: "send",
+				module:    "data",
+				user:      "user",
+				client:    "10.0.0.1",
+			},
+			ok: true,
+		},
+		{
+			name: "anonymous upload starts",
+			raw:  "2024/05/06 07:08:09 [42] rsync to logs/ from client.example (10.0.0.2)",
+			want: logLine{
+				event:     eventStart,
+				time:      at,
+				pid:       42,
+				message:   "rsync to logs/ from client.example (10.0.0.2)",
+				direction: "recv",
+				module:    "logs",
+				client:    "10.0.0.2",
+			},
+			ok: true,
+		},
+		{
+			name: "session ends",
+			raw:  "2024/05/06 07:08:09 [42] sent 100 bytes  received 2048 bytes  total size 4096",
+			want: logLine{
+				event:    eventEnd,
+				time:     at,
+				pid:      42,
+				message:  "sent 100 bytes  received 2048 bytes  total size 4096",
+				sent:     100,
+				received: 2048,
+			},
+			ok: true,
+		},
+		{
+			name: "session fails",
+			raw:  "2024/05/06 07:08:09 [42] auth failed on module data from client.example (10.0.0.1)",
+			want: logLine{
+				event:   eventError,
+				time:    at,
+				pid:     42,
+				message: "auth failed on module data from client.example (10.0.0.1)",
+			},
+			ok: true,
+		},
+		{
+			name: "other message",
+			raw:  "2024/05/06 07:08:09 [42] connect from client.example (10.0.0.1)",
+			want: logLine{
+				event:   eventNone,
+				time:    at,
+				pid:     42,
+				message: "connect from client.example (10.0.0.1)",
+			},
+			ok: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := parseLine(tt.raw)
+			if ok != tt.ok {
+				t.Fatalf("parseLine() ok = %t, want %t", ok, tt.ok)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseLine() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
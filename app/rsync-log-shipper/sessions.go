@@ -0,0 +1,180 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"k8s.io/klog/v2"
+)
+
+var (
+	transferredBytes = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "rsync_transferred_bytes_total",
+		Help: "Bytes of files transferred by the rsync daemon.",
+	}, []string{"module", "operation"})
+
+	transferredFiles = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "rsync_transferred_files_total",
+		Help: "Files transferred by the rsync daemon.",
+	}, []string{"module", "operation"})
+
+	sessionsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "rsync_sessions_total",
+		Help: "Finished sessions of the rsync daemon.",
+	}, []string{"module", "result"})
+
+	activeSessions = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "rsync_active_sessions",
+		Help: "Sessions of the rsync daemon in progress.",
+	}, []string{"module"})
+)
+
+func init() {
+	prometheus.MustRegister(transferredBytes, transferredFiles, sessionsTotal, activeSessions)
+}
+
+// session is a client connection of the daemon.
+type session struct {
+	PID           int        `json:"pid"`
+	Client        string     `json:"client"`
+	User          string     `json:"user,omitempty"`
+	Module        string     `json:"module"`
+	Direction     string     `json:"direction"`
+	StartTime     time.Time  `json:"startTime"`
+	EndTime       *time.Time `json:"endTime,omitempty"`
+	Files         int64      `json:"files"`
+	Bytes         int64      `json:"bytes"`
+	BytesSent     int64      `json:"bytesSent"`
+	BytesReceived int64      `json:"bytesReceived"`
+	Result        string     `json:"result,omitempty"`
+	Error         string     `json:"error,omitempty"`
+}
+
+// sessions is the response of /sessions.
+type sessions struct {
+	Active []session `json:"active"`
+	Recent []session `json:"recent"`
+}
+
+// tracker follows the sessions of the daemon log.
+type tracker struct {
+	lock      sync.Mutex
+	active    map[int]*session
+	recent    []session
+	maxRecent int
+	encoder   *json.Encoder
+}
+
+func newTracker(maxRecent int) *tracker {
+	return &tracker{
+		active:    map[int]*session{},
+		maxRecent: maxRecent,
+		encoder:   json.NewEncoder(os.Stdout),
+	}
+}
+
+// transferRecord and sessionRecord are the JSON lines written to stdout.
+type transferRecord struct {
+	Kind string `json:"kind"`
+	transfer
+}
+
+type sessionRecord struct {
+	Kind string `json:"kind"`
+	session
+}
+
+func (t *tracker) handleLine(raw string) {
+	l, ok := parseLine(raw)
+	if !ok {
+		return
+	}
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	s := t.active[l.pid]
+	switch l.event {
+	case eventStart:
+		if s == nil {
+			s = &session{PID: l.pid}
+			t.active[l.pid] = s
+		} else {
+			activeSessions.WithLabelValues(s.Module).Dec()
+		}
+		s.StartTime, s.Client, s.User, s.Module, s.Direction = l.time, l.client, l.user, l.module, l.direction
+		activeSessions.WithLabelValues(s.Module).Inc()
+	case eventTransfer:
+		tr := l.transfer
+		if s != nil {
+			tr.User = s.User
+			s.Files++
+			s.Bytes += tr.Bytes
+		}
+		transferredBytes.WithLabelValues(tr.Module, tr.Operation).Add(float64(tr.Bytes))
+		transferredFiles.WithLabelValues(tr.Module, tr.Operation).Inc()
+		t.write(transferRecord{Kind: "transfer", transfer: tr})
+	case eventEnd:
+		if s != nil {
+			s.BytesSent, s.BytesReceived = l.sent, l.received
+			t.finish(s, l.time, "Succeeded", "")
+		}
+	case eventError:
+		if s != nil {
+			t.finish(s, l.time, "Failed", l.message)
+		}
+	}
+}
+
+// finish moves a session to the recent sessions. The lock is held.
+func (t *tracker) finish(s *session, end time.Time, result, message string) {
+	delete(t.active, s.PID)
+	activeSessions.WithLabelValues(s.Module).Dec()
+	sessionsTotal.WithLabelValues(s.Module, result).Inc()
+	s.EndTime, s.Result, s.Error = &end, result, message
+	t.recent = append(t.recent, *s)
+	if len(t.recent) > t.maxRecent {
+		t.recent = t.recent[len(t.recent)-t.maxRecent:]
+	}
+	t.write(sessionRecord{Kind: "session", session: *s})
+}
+
+func (t *tracker) write(r interface{}) {
+	if err := t.encoder.Encode(r); err != nil {
+		klog.Errorf("Error writing record: %s", err)
+	}
+}
+
+// pruneLoop ends sessions whose daemon process is gone without logging
+// the end of the session. The pod shares its process namespace, so the
+// processes of the daemon are visible.
+func (t *tracker) pruneLoop(interval time.Duration) {
+	for range time.Tick(interval) {
+		t.lock.Lock()
+		for pid, s := range t.active {
+			if _, err := os.Stat(fmt.Sprintf("/proc/%d", pid)); os.IsNotExist(err) {
+				t.finish(s, time.Now(), "Failed", "the daemon process exited")
+			}
+		}
+		t.lock.Unlock()
+	}
+}
+
+func (t *tracker) serveSessions(w http.ResponseWriter, r *http.Request) {
+	t.lock.Lock()
+	resp := sessions{
+		Active: []session{},
+		Recent: append([]session{}, t.recent...),
+	}
+	for _, s := range t.active {
+		resp.Active = append(resp.Active, *s)
+	}
+	t.lock.Unlock()
+	sort.Slice(resp.Active, func(i, j int) bool { return resp.Active[i].StartTime.Before(resp.Active[j].StartTime) })
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
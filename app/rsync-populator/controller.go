@@ -0,0 +1,452 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	storagev1 "k8s.io/api/storage/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/scheme"
+	typedcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/client-go/util/workqueue"
+	"k8s.io/klog/v2"
+
+	internalv1 "github.com/k8s-volume-copy/types/apis/demo.io/v1"
+	"github.com/k8s-volume-copy/types/constant"
+)
+
+const (
+	reasonSourceNotFound     = "RsyncSourceNotFound"
+	reasonUnsupportedClaim   = "UnsupportedClaim"
+	reasonPopulatorCreated   = "PopulatorCreated"
+	reasonPopulatorFailed    = "PopulatorFailed"
+	reasonPopulatorFinished  = "PopulatorFinished"
+	reasonPopulatorCompleted = "PopulatorCompleted"
+)
+
+var (
+	rsyncSourceGVR = schema.GroupVersionResource{
+		Group:    constant.GroupDemoIO,
+		Version:  constant.VersionV1,
+		Resource: constant.RsyncSourceResource,
+	}
+
+	rsyncSourceGK = schema.GroupKind{
+		Group: constant.GroupDemoIO,
+		Kind:  constant.RsyncSourceKind,
+	}
+)
+
+type controller struct {
+	kubeClient    kubernetes.Interface
+	dynamicClient dynamic.Interface
+	pvcLister     corelisters.PersistentVolumeClaimLister
+	pvcSynced     cache.InformerSynced
+	podLister     corelisters.PodLister
+	podSynced     cache.InformerSynced
+	workqueue     workqueue.RateLimitingInterface
+	recorder      record.EventRecorder
+}
+
+func runController(cfg *rest.Config) {
+	klog.Infof("Starting populator for %s", strings.ToLower(rsyncSourceGK.String()))
+	stopCh := make(chan struct{})
+	sigCh := make(chan os.Signal, 2)
+	signal.Notify(sigCh, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-sigCh
+		close(stopCh)
+		<-sigCh
+		os.Exit(1) // second signal. Exit directly.
+	}()
+
+	kubeClient, err := kubernetes.NewForConfig(cfg)
+	if nil != err {
+		klog.Fatalf("Failed to create kube client: %v", err)
+	}
+
+	dynamicClient, err := dynamic.NewForConfig(cfg)
+	if nil != err {
+		klog.Fatalf("Failed to create dynamic client: %v", err)
+	}
+
+	eventBroadcaster := record.NewBroadcaster()
+	eventBroadcaster.StartStructuredLogging(0)
+	eventBroadcaster.StartRecordingToSink(&typedcorev1.EventSinkImpl{Interface: kubeClient.CoreV1().Events("")})
+	recorder := eventBroadcaster.NewRecorder(scheme.Scheme, corev1.EventSource{Component: componentNameRsyncPopulator})
+
+	informerFactory := informers.NewSharedInformerFactory(kubeClient, 30*time.Second)
+	pvcInformer := informerFactory.Core().V1().PersistentVolumeClaims().Informer()
+
+	// Only populator pods are cached.
+	podInformerFactory := informers.NewSharedInformerFactoryWithOptions(kubeClient, 30*time.Second,
+		informers.WithNamespace(namespace),
+		informers.WithTweakListOptions(func(options *metav1.ListOptions) {
+			options.LabelSelector = constant.CreatedByLabel + "=" + componentNameRsyncPopulator
+		}))
+	podInformer := podInformerFactory.Core().V1().Pods().Informer()
+
+	c := &controller{
+		kubeClient:    kubeClient,
+		dynamicClient: dynamicClient,
+		pvcLister:     informerFactory.Core().V1().PersistentVolumeClaims().Lister(),
+		pvcSynced:     pvcInformer.HasSynced,
+		podLister:     podInformerFactory.Core().V1().Pods().Lister(),
+		podSynced:     podInformer.HasSynced,
+		workqueue:     workqueue.NewRateLimitingQueue(workqueue.DefaultControllerRateLimiter()),
+		recorder:      recorder,
+	}
+
+	pvcInformer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: c.handle,
+		UpdateFunc: func(oldObj, newObj interface{}) {
+			c.handle(newObj)
+		},
+		DeleteFunc: c.handle,
+	})
+
+	podInformer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: c.handle,
+		UpdateFunc: func(oldObj, newObj interface{}) {
+			c.handle(newObj)
+		},
+		DeleteFunc: c.handle,
+	})
+
+	informerFactory.Start(stopCh)
+	podInformerFactory.Start(stopCh)
+	if err := c.run(stopCh); nil != err {
+		klog.Fatalf("Failed to run controller: %v", err)
+	}
+}
+
+// handle queues a claim. Prime claims and populator pods queue the claim
+// they were created for.
+func (c *controller) handle(obj interface{}) {
+	if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+		obj = tombstone.Obj
+	}
+	object, ok := obj.(metav1.Object)
+	if !ok {
+		return
+	}
+	if object.GetLabels()[constant.CreatedByLabel] == componentNameRsyncPopulator {
+		if key, ok := object.GetAnnotations()[populatedClaimAnnotation]; ok {
+			c.workqueue.Add(key)
+		}
+		return
+	}
+	key, err := cache.MetaNamespaceKeyFunc(object)
+	if err != nil {
+		utilruntime.HandleError(err)
+		return
+	}
+	c.workqueue.Add(key)
+}
+
+func (c *controller) run(stopCh <-chan struct{}) error {
+	defer utilruntime.HandleCrash()
+	defer c.workqueue.ShutDown()
+
+	if ok := cache.WaitForCacheSync(stopCh, c.pvcSynced, c.podSynced); !ok {
+		return fmt.Errorf("failed to wait for caches to sync")
+	}
+
+	go wait.Until(c.runWorker, time.Second, stopCh)
+	<-stopCh
+	return nil
+}
+
+func (c *controller) runWorker() {
+	processNext := func(obj interface{}) error {
+		defer c.workqueue.Done(obj)
+		var key string
+		var ok bool
+		if key, ok = obj.(string); !ok {
+			c.workqueue.Forget(obj)
+			utilruntime.HandleError(fmt.Errorf("expected string in workqueue but got %#v", obj))
+			return nil
+		}
+		parts := strings.Split(key, "/")
+		if len(parts) != 2 {
+			utilruntime.HandleError(fmt.Errorf("invalid resource key: %s", key))
+			return nil
+		}
+		if err := c.syncClaim(context.TODO(), key, parts[0], parts[1]); err != nil {
+			c.workqueue.AddRateLimited(key)
+			return fmt.Errorf("error syncing '%s': %s, requeuing", key, err.Error())
+		}
+		c.workqueue.Forget(obj)
+		return nil
+	}
+
+	for {
+		obj, shutdown := c.workqueue.Get()
+		if shutdown {
+			return
+		}
+		if err := processNext(obj); err != nil {
+			utilruntime.HandleError(err)
+		}
+	}
+}
+
+// rsyncSourceRef returns the name of the rsync source a claim is populated
+// from, or an empty string if the claim has no rsync source data source.
+func rsyncSourceRef(pvc *corev1.PersistentVolumeClaim) string {
+	ref := pvc.Spec.DataSourceRef
+	if ref == nil {
+		ref = pvc.Spec.DataSource
+	}
+	if ref == nil || ref.APIGroup == nil || *ref.APIGroup != constant.GroupDemoIO ||
+		ref.Kind != constant.RsyncSourceKind {
+		return ""
+	}
+	return ref.Name
+}
+
+/*
+The populator works in the following steps
+1. wait for the selected node if the storage class is WaitForFirstConsumer
+2. create a prime claim and a populator pod that pulls the rsync source into it
+3. once the pod succeeded, point the claim ref of the prime volume to the claim
+4. once the claim is bound, delete the prime claim, the pod and its secret
+*/
+func (c *controller) syncClaim(ctx context.Context, key, claimNamespace, name string) error {
+	pvc, err := c.pvcLister.PersistentVolumeClaims(claimNamespace).Get(name)
+	if err != nil {
+		if errors.IsNotFound(err) {
+			return c.cleanupOrphans(ctx, key)
+		}
+		return fmt.Errorf("error getting claim, error: %s", err)
+	}
+	sourceName := rsyncSourceRef(pvc)
+	if sourceName == "" {
+		return nil
+	}
+	if pvc.Spec.VolumeName != "" {
+		return c.cleanup(ctx, primeName(pvc), pvc)
+	}
+	if pvc.Spec.VolumeMode != nil && *pvc.Spec.VolumeMode == corev1.PersistentVolumeBlock {
+		c.recorder.Event(pvc, corev1.EventTypeWarning, reasonUnsupportedClaim,
+			"block volumes can't be populated from a rsync source")
+		return nil
+	}
+	waitForFirstConsumer, err := c.isWaitForFirstConsumer(ctx, pvc)
+	if err != nil {
+		return err
+	}
+	if waitForFirstConsumer && pvc.GetAnnotations()[selectedNodeAnnotation] == "" {
+		return nil
+	}
+	if _, err := moduleOfClaim(pvc); err != nil {
+		c.recorder.Event(pvc, corev1.EventTypeWarning, reasonUnsupportedClaim, err.Error())
+		return nil
+	}
+
+	unstruct, err := c.dynamicClient.Resource(rsyncSourceGVR).Namespace(claimNamespace).
+		Get(ctx, sourceName, metav1.GetOptions{})
+	if err != nil {
+		if errors.IsNotFound(err) {
+			c.recorder.Eventf(pvc, corev1.EventTypeWarning, reasonSourceNotFound,
+				"rsync source `%s` not found", sourceName)
+			c.workqueue.AddAfter(key, 30*time.Second)
+			return nil
+		}
+		return err
+	}
+	rsyncSource := internalv1.RsyncSource{}
+	if err := runtime.DefaultUnstructuredConverter.FromUnstructured(unstruct.UnstructuredContent(),
+		&rsyncSource); err != nil {
+		return fmt.Errorf("error converting rsync source `%s` in `%s` namespace error: %s",
+			unstruct.GetName(), unstruct.GetNamespace(), err)
+	}
+	tc, err := templateConfigFromClaim(*pvc, rsyncSource)
+	if err != nil {
+		return err
+	}
+
+	if err := c.ensureSecret(ctx, tc.getSecretTemplate()); err != nil {
+		return fmt.Errorf("error ensuring secret for claim `%s`, error: %s", key, err)
+	}
+	prime, err := c.ensurePrimeClaim(ctx, tc.getPrimeClaimTemplate())
+	if err != nil {
+		return fmt.Errorf("error ensuring prime claim for claim `%s`, error: %s", key, err)
+	}
+	pod, err := c.podLister.Pods(namespace).Get(tc.name)
+	if err != nil {
+		if !errors.IsNotFound(err) {
+			return err
+		}
+		if _, err := c.kubeClient.CoreV1().Pods(namespace).
+			Create(ctx, tc.getPodTemplate(), metav1.CreateOptions{}); err != nil {
+			return err
+		}
+		c.recorder.Eventf(pvc, corev1.EventTypeNormal, reasonPopulatorCreated,
+			"populating claim from rsync source `%s`", sourceName)
+		return nil
+	}
+	switch pod.Status.Phase {
+	case corev1.PodFailed:
+		c.recorder.Eventf(pvc, corev1.EventTypeWarning, reasonPopulatorFailed,
+			"populator pod `%s` failed, retrying", pod.GetName())
+		return c.kubeClient.CoreV1().Pods(namespace).Delete(ctx, pod.GetName(), metav1.DeleteOptions{})
+	case corev1.PodSucceeded:
+		return c.rebind(ctx, pvc, prime)
+	}
+	return nil
+}
+
+func (c *controller) isWaitForFirstConsumer(ctx context.Context, pvc *corev1.PersistentVolumeClaim) (bool, error) {
+	if pvc.Spec.StorageClassName == nil || *pvc.Spec.StorageClassName == "" {
+		return false, nil
+	}
+	sc, err := c.kubeClient.StorageV1().StorageClasses().Get(ctx, *pvc.Spec.StorageClassName, metav1.GetOptions{})
+	if err != nil {
+		return false, err
+	}
+	return sc.VolumeBindingMode != nil && *sc.VolumeBindingMode == storagev1.VolumeBindingWaitForFirstConsumer, nil
+}
+
+// rebind points the claim ref of the populated prime volume to the claim.
+// The PV controller then binds the volume to the claim.
+func (c *controller) rebind(ctx context.Context, pvc, prime *corev1.PersistentVolumeClaim) error {
+	if prime.Spec.VolumeName == "" {
+		return nil
+	}
+	pv, err := c.kubeClient.CoreV1().PersistentVolumes().Get(ctx, prime.Spec.VolumeName, metav1.GetOptions{})
+	if err != nil {
+		return err
+	}
+	if pv.Spec.ClaimRef != nil && pv.Spec.ClaimRef.UID == pvc.GetUID() {
+		return nil
+	}
+	clone := pv.DeepCopy()
+	clone.Spec.ClaimRef = &corev1.ObjectReference{
+		Kind:            "PersistentVolumeClaim",
+		APIVersion:      "v1",
+		Namespace:       pvc.GetNamespace(),
+		Name:            pvc.GetName(),
+		UID:             pvc.GetUID(),
+		ResourceVersion: pvc.GetResourceVersion(),
+	}
+	if _, err := c.kubeClient.CoreV1().PersistentVolumes().Update(ctx, clone, metav1.UpdateOptions{}); err != nil {
+		return err
+	}
+	c.recorder.Eventf(pvc, corev1.EventTypeNormal, reasonPopulatorFinished,
+		"populated volume `%s` handed over to claim", pv.GetName())
+	return nil
+}
+
+/*
+if found and not created by the populator then return error
+if found return it
+if !found -> create return it
+*/
+func (c *controller) ensurePrimeClaim(ctx context.Context, pvc *corev1.PersistentVolumeClaim) (*corev1.PersistentVolumeClaim, error) {
+	obj, err := c.pvcLister.PersistentVolumeClaims(namespace).Get(pvc.GetName())
+	if err != nil {
+		if !errors.IsNotFound(err) {
+			return nil, err
+		}
+		return c.kubeClient.CoreV1().PersistentVolumeClaims(namespace).
+			Create(ctx, pvc.DeepCopy(), metav1.CreateOptions{})
+	}
+	if obj.GetLabels()[constant.CreatedByLabel] != componentNameRsyncPopulator {
+		return nil, fmt.Errorf("resource found but not created by this operator")
+	}
+	return obj, nil
+}
+
+/*
+if found and not created by the populator then return error
+if found return nil
+if !found -> create return error/nil
+*/
+func (c *controller) ensureSecret(ctx context.Context, secret *corev1.Secret) error {
+	obj, err := c.kubeClient.CoreV1().Secrets(namespace).Get(ctx, secret.GetName(), metav1.GetOptions{})
+	if err != nil {
+		if !errors.IsNotFound(err) {
+			return err
+		}
+		_, err := c.kubeClient.CoreV1().Secrets(namespace).Create(ctx, secret.DeepCopy(), metav1.CreateOptions{})
+		return err
+	}
+	if obj.GetLabels()[constant.CreatedByLabel] != componentNameRsyncPopulator {
+		return fmt.Errorf("resource found but not created by this operator")
+	}
+	return nil
+}
+
+// cleanup deletes the populator pod, its secret and the prime claim with
+// the given name of a bound claim.
+func (c *controller) cleanup(ctx context.Context, name string, pvc *corev1.PersistentVolumeClaim) error {
+	found := false
+	if _, err := c.podLister.Pods(namespace).Get(name); err == nil {
+		found = true
+		if err := c.kubeClient.CoreV1().Pods(namespace).
+			Delete(ctx, name, metav1.DeleteOptions{}); err != nil && !errors.IsNotFound(err) {
+			return err
+		}
+	}
+	if _, err := c.pvcLister.PersistentVolumeClaims(namespace).Get(name); err == nil {
+		found = true
+		if err := c.kubeClient.CoreV1().PersistentVolumeClaims(namespace).
+			Delete(ctx, name, metav1.DeleteOptions{}); err != nil && !errors.IsNotFound(err) {
+			return err
+		}
+	}
+	if err := c.kubeClient.CoreV1().Secrets(namespace).
+		Delete(ctx, name, metav1.DeleteOptions{}); err != nil && !errors.IsNotFound(err) {
+		return err
+	}
+	if found {
+		c.recorder.Event(pvc, corev1.EventTypeNormal, reasonPopulatorCompleted,
+			"claim populated from rsync source")
+	}
+	return nil
+}
+
+// cleanupOrphans deletes the populator resources of a deleted claim.
+func (c *controller) cleanupOrphans(ctx context.Context, key string) error {
+	pvcs, err := c.pvcLister.PersistentVolumeClaims(namespace).List(labels.SelectorFromSet(labels.Set{
+		constant.CreatedByLabel: componentNameRsyncPopulator,
+	}))
+	if err != nil {
+		return err
+	}
+	for _, pvc := range pvcs {
+		if pvc.GetAnnotations()[populatedClaimAnnotation] != key {
+			continue
+		}
+		for _, err := range []error{
+			c.kubeClient.CoreV1().Pods(namespace).Delete(ctx, pvc.GetName(), metav1.DeleteOptions{}),
+			c.kubeClient.CoreV1().Secrets(namespace).Delete(ctx, pvc.GetName(), metav1.DeleteOptions{}),
+			c.kubeClient.CoreV1().PersistentVolumeClaims(namespace).Delete(ctx, pvc.GetName(), metav1.DeleteOptions{}),
+		} {
+			if err != nil && !errors.IsNotFound(err) {
+				return err
+			}
+		}
+	}
+	return nil
+}
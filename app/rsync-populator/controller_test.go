@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/record"
+)
+
+func TestRebind(t *testing.T) {
+	claim := testClaim(nil)
+	claim.ResourceVersion = "42"
+	primeRef := &corev1.ObjectReference{
+		Kind: "PersistentVolumeClaim", Namespace: "k8svol", Name: "populate-claim-uid", UID: "prime-uid",
+	}
+	claimRef := &corev1.ObjectReference{
+		Kind:            "PersistentVolumeClaim",
+		APIVersion:      "v1",
+		Namespace:       "app",
+		Name:            "populated",
+		UID:             "claim-uid",
+		ResourceVersion: "42",
+	}
+	volume := func(ref *corev1.ObjectReference) *corev1.PersistentVolume {
+		return &corev1.PersistentVolume{
+			ObjectMeta: metav1.ObjectMeta{Name: "pv-1"},
+			Spec:       corev1.PersistentVolumeSpec{ClaimRef: ref},
+		}
+	}
+	tests := []struct {
+		name         string
+		volumeName   string
+		existing     []runtime.Object
+		wantClaimRef *corev1.ObjectReference
+		wantEvent    bool
+		wantErr      bool
+	}{
+		{
+			name: "prime claim not bound yet",
+		},
+		{
+			name:         "prime volume handed over",
+			volumeName:   "pv-1",
+			existing:     []runtime.Object{volume(primeRef)},
+			wantClaimRef: claimRef,
+			wantEvent:    true,
+		},
+		{
+			name:         "already handed over",
+			volumeName:   "pv-1",
+			existing:     []runtime.Object{volume(claimRef)},
+			wantClaimRef: claimRef,
+		},
+		{
+			name:       "prime volume missing",
+			volumeName: "pv-1",
+			wantErr:    true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			recorder := record.NewFakeRecorder(10)
+			c := &controller{
+				kubeClient: fake.NewSimpleClientset(tt.existing...),
+				recorder:   recorder,
+			}
+			prime := &corev1.PersistentVolumeClaim{Spec: corev1.PersistentVolumeClaimSpec{VolumeName: tt.volumeName}}
+			err := c.rebind(context.TODO(), &claim, prime)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("rebind() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantClaimRef != nil {
+				pv, err := c.kubeClient.CoreV1().PersistentVolumes().Get(context.TODO(), "pv-1", metav1.GetOptions{})
+				if err != nil {
+					t.Fatalf("getting volume error = %v", err)
+				}
+				if *pv.Spec.ClaimRef != *tt.wantClaimRef {
+					t.Errorf("rebind() claim ref = %+v, want %+v", pv.Spec.ClaimRef, tt.wantClaimRef)
+				}
+			}
+			if got := len(recorder.Events) != 0; got != tt.wantEvent {
+				t.Errorf("rebind() recorded event = %t, want %t", got, tt.wantEvent)
+			}
+		})
+	}
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+
+	internalv1 "github.com/k8s-volume-copy/types/apis/demo.io/v1"
+	"github.com/k8s-volume-copy/types/constant"
+)
+
+const (
+	// componentNameRsyncPopulator is added as created-by label to the
+	// resources created by the rsync populator.
+	componentNameRsyncPopulator = "rsync-populator"
+
+	// populatedClaimAnnotation holds the namespace/name of the claim a prime
+	// claim or a populator pod was created for.
+	populatedClaimAnnotation = "demo.io/populated-claim"
+	// selectedNodeAnnotation is set by the scheduler on claims of a
+	// WaitForFirstConsumer storage class.
+	selectedNodeAnnotation = "volume.kubernetes.io/selected-node"
+	// moduleAnnotation selects the rsync module of the source a claim is
+	// populated from, the data source reference has no field for it.
+	moduleAnnotation = "demo.io/module"
+
+	defaultModule = "data"
+)
+
+// populatorScript pulls the module of the source into the prime claim.
+var populatorScript = `
+exec rsync --archive "rsync://${RSYNC_USER}@${RSYNC_HOST}/${RSYNC_MODULE}/" /dest/
+`
+
+type templateConfig struct {
+	// name of the prime claim, the populator pod and its secret.
+	name   string
+	module string
+	claim  corev1.PersistentVolumeClaim
+	source internalv1.RsyncSource
+}
+
+func templateConfigFromClaim(claim corev1.PersistentVolumeClaim, source internalv1.RsyncSource) (*templateConfig, error) {
+	module, err := moduleOfClaim(&claim)
+	if err != nil {
+		return nil, err
+	}
+	return &templateConfig{
+		name:   primeName(&claim),
+		module: module,
+		claim:  claim,
+		source: source,
+	}, nil
+}
+
+// primeName returns the name of the prime claim, the populator pod and its
+// secret of a claim.
+func primeName(pvc *corev1.PersistentVolumeClaim) string {
+	return "populate-" + string(pvc.GetUID())
+}
+
+// moduleOfClaim returns the rsync module a claim is populated from.
+func moduleOfClaim(pvc *corev1.PersistentVolumeClaim) (string, error) {
+	module, ok := pvc.GetAnnotations()[moduleAnnotation]
+	if !ok {
+		return defaultModule, nil
+	}
+	if errs := validation.IsDNS1123Label(module); len(errs) != 0 {
+		return "", fmt.Errorf("invalid module `%s` in `%s` annotation: %s",
+			module, moduleAnnotation, strings.Join(errs, ", "))
+	}
+	return module, nil
+}
+
+func (tc *templateConfig) objectMeta() metav1.ObjectMeta {
+	return metav1.ObjectMeta{
+		Name:      tc.name,
+		Namespace: namespace,
+		Labels: map[string]string{
+			constant.CreatedByLabel: componentNameRsyncPopulator,
+			constant.NameLabel:      tc.name,
+		},
+		Annotations: map[string]string{
+			populatedClaimAnnotation: tc.claim.GetNamespace() + "/" + tc.claim.GetName(),
+		},
+	}
+}
+
+// getPrimeClaimTemplate returns the claim the data is written to before its
+// volume is handed over to the user's claim.
+func (tc *templateConfig) getPrimeClaimTemplate() *corev1.PersistentVolumeClaim {
+	objectMeta := tc.objectMeta()
+	if node, ok := tc.claim.GetAnnotations()[selectedNodeAnnotation]; ok {
+		objectMeta.Annotations[selectedNodeAnnotation] = node
+	}
+	pvc := corev1.PersistentVolumeClaim{
+		TypeMeta: metav1.TypeMeta{
+			Kind:       "PersistentVolumeClaim",
+			APIVersion: "v1",
+		},
+		ObjectMeta: objectMeta,
+		Spec: corev1.PersistentVolumeClaimSpec{
+			AccessModes:      tc.claim.Spec.AccessModes,
+			Resources:        tc.claim.Spec.Resources,
+			StorageClassName: tc.claim.Spec.StorageClassName,
+			VolumeMode:       tc.claim.Spec.VolumeMode,
+		},
+	}
+	return &pvc
+}
+
+func (tc *templateConfig) getSecretTemplate() *corev1.Secret {
+	secret := corev1.Secret{
+		TypeMeta: metav1.TypeMeta{
+			Kind:       "Secret",
+			APIVersion: "v1",
+		},
+		ObjectMeta: tc.objectMeta(),
+		StringData: map[string]string{
+			"RSYNC_PASSWORD": tc.source.Spec.Password,
+		},
+	}
+	return &secret
+}
+
+func (tc *templateConfig) getPodTemplate() *corev1.Pod {
+	pod := corev1.Pod{
+		TypeMeta: metav1.TypeMeta{
+			Kind:       "Pod",
+			APIVersion: "v1",
+		},
+		ObjectMeta: tc.objectMeta(),
+		Spec: corev1.PodSpec{
+			RestartPolicy: corev1.RestartPolicyNever,
+			NodeName:      tc.claim.GetAnnotations()[selectedNodeAnnotation],
+			Containers: []corev1.Container{
+				{
+					Name:    "populate",
+					Image:   rsyncClientImage,
+					Command: []string{"sh", "-c", populatorScript},
+					Env: []corev1.EnvVar{
+						{
+							Name:  "RSYNC_USER",
+							Value: tc.source.Spec.Username,
+						},
+						{
+							Name: "RSYNC_HOST",
+							Value: fmt.Sprintf("%s.%s.svc",
+								tc.source.GetName(), tc.source.GetNamespace()),
+						},
+						{
+							Name:  "RSYNC_MODULE",
+							Value: tc.module,
+						},
+					},
+					EnvFrom: []corev1.EnvFromSource{
+						{
+							SecretRef: &corev1.SecretEnvSource{
+								LocalObjectReference: corev1.LocalObjectReference{
+									Name: tc.name,
+								},
+							},
+						},
+					},
+					VolumeMounts: []corev1.VolumeMount{
+						{
+							Name:      "dest",
+							MountPath: "/dest",
+						},
+					},
+				},
+			},
+			Volumes: []corev1.Volume{
+				{
+					Name: "dest",
+					VolumeSource: corev1.VolumeSource{
+						PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{
+							ClaimName: tc.name,
+						},
+					},
+				},
+			},
+		},
+	}
+	return &pod
+}
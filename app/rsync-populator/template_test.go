@@ -0,0 +1,224 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	internalv1 "github.com/k8s-volume-copy/types/apis/demo.io/v1"
+	"github.com/k8s-volume-copy/types/constant"
+)
+
+func testClaim(annotations map[string]string) corev1.PersistentVolumeClaim {
+	storageClass := "local"
+	mode := corev1.PersistentVolumeFilesystem
+	return corev1.PersistentVolumeClaim{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "populated",
+			Namespace:   "app",
+			UID:         "claim-uid",
+			Annotations: annotations,
+		},
+		Spec: corev1.PersistentVolumeClaimSpec{
+			AccessModes: []corev1.PersistentVolumeAccessMode{corev1.ReadWriteOnce},
+			Resources: corev1.ResourceRequirements{
+				Requests: corev1.ResourceList{corev1.ResourceStorage: resource.MustParse("1Gi")},
+			},
+			StorageClassName: &storageClass,
+			VolumeMode:       &mode,
+		},
+	}
+}
+
+func testSource() internalv1.RsyncSource {
+	return internalv1.RsyncSource{
+		ObjectMeta: metav1.ObjectMeta{Name: "rsync-source", Namespace: "app"},
+		Spec:       internalv1.RsyncSourceSpec{Username: "user", Password: "pass"},
+	}
+}
+
+func TestModuleOfClaim(t *testing.T) {
+	tests := []struct {
+		name        string
+		annotations map[string]string
+		want        string
+		wantErr     bool
+	}{
+		{
+			name: "default",
+			want: "data",
+		},
+		{
+			name:        "annotated",
+			annotations: map[string]string{moduleAnnotation: "logs"},
+			want:        "logs",
+		},
+		{
+			name:        "path instead of module",
+			annotations: map[string]string{moduleAnnotation: "data/../etc"},
+			wantErr:     true,
+		},
+		{
+			name:        "empty",
+			annotations: map[string]string{moduleAnnotation: ""},
+			wantErr:     true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pvc := testClaim(tt.annotations)
+			got, err := moduleOfClaim(&pvc)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("moduleOfClaim() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("moduleOfClaim() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRsyncSourceRef(t *testing.T) {
+	group := constant.GroupDemoIO
+	otherGroup := "snapshot.storage.k8s.io"
+	tests := []struct {
+		name          string
+		dataSource    *corev1.TypedLocalObjectReference
+		dataSourceRef *corev1.TypedLocalObjectReference
+		want          string
+	}{
+		{
+			name: "no data source",
+		},
+		{
+			name:          "data source ref",
+			dataSourceRef: &corev1.TypedLocalObjectReference{APIGroup: &group, Kind: constant.RsyncSourceKind, Name: "src"},
+			want:          "src",
+		},
+		{
+			name:       "data source",
+			dataSource: &corev1.TypedLocalObjectReference{APIGroup: &group, Kind: constant.RsyncSourceKind, Name: "src"},
+			want:       "src",
+		},
+		{
+			name:          "snapshot",
+			dataSourceRef: &corev1.TypedLocalObjectReference{APIGroup: &otherGroup, Kind: "VolumeSnapshot", Name: "snap"},
+		},
+		{
+			name:          "core group",
+			dataSourceRef: &corev1.TypedLocalObjectReference{Kind: "PersistentVolumeClaim", Name: "pvc"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pvc := testClaim(nil)
+			pvc.Spec.DataSource = tt.dataSource
+			pvc.Spec.DataSourceRef = tt.dataSourceRef
+			if got := rsyncSourceRef(&pvc); got != tt.want {
+				t.Errorf("rsyncSourceRef() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGetPrimeClaimTemplate(t *testing.T) {
+	namespace = "k8svol"
+	tests := []struct {
+		name        string
+		annotations map[string]string
+		wantNode    string
+	}{
+		{
+			name: "immediate binding",
+		},
+		{
+			name:        "selected node",
+			annotations: map[string]string{selectedNodeAnnotation: "worker-1"},
+			wantNode:    "worker-1",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			claim := testClaim(tt.annotations)
+			tc, err := templateConfigFromClaim(claim, testSource())
+			if err != nil {
+				t.Fatalf("templateConfigFromClaim() error = %v", err)
+			}
+			prime := tc.getPrimeClaimTemplate()
+			if prime.GetName() != "populate-claim-uid" || prime.GetNamespace() != "k8svol" {
+				t.Errorf("getPrimeClaimTemplate() = %s/%s, want k8svol/populate-claim-uid",
+					prime.GetNamespace(), prime.GetName())
+			}
+			if got := prime.GetAnnotations()[populatedClaimAnnotation]; got != "app/populated" {
+				t.Errorf("getPrimeClaimTemplate() populated claim = %s, want app/populated", got)
+			}
+			if got := prime.GetAnnotations()[selectedNodeAnnotation]; got != tt.wantNode {
+				t.Errorf("getPrimeClaimTemplate() selected node = %s, want %s", got, tt.wantNode)
+			}
+			if !reflect.DeepEqual(prime.Spec.AccessModes, claim.Spec.AccessModes) ||
+				!reflect.DeepEqual(prime.Spec.Resources, claim.Spec.Resources) ||
+				!reflect.DeepEqual(prime.Spec.StorageClassName, claim.Spec.StorageClassName) ||
+				!reflect.DeepEqual(prime.Spec.VolumeMode, claim.Spec.VolumeMode) {
+				t.Errorf("getPrimeClaimTemplate() spec = %+v, want the spec of the claim", prime.Spec)
+			}
+			if prime.Spec.DataSourceRef != nil || prime.Spec.VolumeName != "" {
+				t.Errorf("getPrimeClaimTemplate() spec = %+v, want a plain claim", prime.Spec)
+			}
+		})
+	}
+}
+
+func TestGetPodTemplate(t *testing.T) {
+	namespace = "k8svol"
+	rsyncClientImage = "rsync-client"
+	tests := []struct {
+		name        string
+		annotations map[string]string
+		wantNode    string
+		wantModule  string
+	}{
+		{
+			name:       "default module",
+			wantModule: "data",
+		},
+		{
+			name: "module and selected node",
+			annotations: map[string]string{
+				moduleAnnotation:       "logs",
+				selectedNodeAnnotation: "worker-1",
+			},
+			wantNode:   "worker-1",
+			wantModule: "logs",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tc, err := templateConfigFromClaim(testClaim(tt.annotations), testSource())
+			if err != nil {
+				t.Fatalf("templateConfigFromClaim() error = %v", err)
+			}
+			pod := tc.getPodTemplate()
+			if pod.Spec.NodeName != tt.wantNode {
+				t.Errorf("getPodTemplate() node = %s, want %s", pod.Spec.NodeName, tt.wantNode)
+			}
+			env := map[string]string{}
+			for _, e := range pod.Spec.Containers[0].Env {
+				env[e.Name] = e.Value
+			}
+			want := map[string]string{
+				"RSYNC_USER":   "user",
+				"RSYNC_HOST":   "rsync-source.app.svc",
+				"RSYNC_MODULE": tt.wantModule,
+			}
+			if !reflect.DeepEqual(env, want) {
+				t.Errorf("getPodTemplate() env = %v, want %v", env, want)
+			}
+			if got := pod.Spec.Volumes[0].PersistentVolumeClaim.ClaimName; got != "populate-claim-uid" {
+				t.Errorf("getPodTemplate() claim = %s, want the prime claim", got)
+			}
+		})
+	}
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	authenticationv1 "k8s.io/api/authentication/v1"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/k8s-volume-copy/types/constant"
+)
+
+const (
+	// connectSubresource is the virtual subresource clients need access to,
+	// e.g. with the rule
+	//
+	//	apiGroups: [demo.io], resources: [rsyncsources/connect], verbs: [create]
+	connectSubresource = "connect"
+	connectVerb        = "create"
+)
+
+// authorize validates a token with a TokenReview and checks with a
+// SubjectAccessReview whether its user may connect to the rsync source. It
+// returns the name of the user.
+func (p *proxy) authorize(ctx context.Context, token string) (string, error) {
+	review := &authenticationv1.TokenReview{
+		Spec: authenticationv1.TokenReviewSpec{
+			Token:     token,
+			Audiences: []string{p.audience},
+		},
+	}
+	review, err := p.kubeClient.AuthenticationV1().TokenReviews().Create(ctx, review, metav1.CreateOptions{})
+	if err != nil {
+		return "", fmt.Errorf("error reviewing token: %s", err)
+	}
+	if !review.Status.Authenticated {
+		return "", fmt.Errorf("unauthenticated")
+	}
+	user := review.Status.User
+
+	extra := map[string]authorizationv1.ExtraValue{}
+	for k, v := range user.Extra {
+		extra[k] = authorizationv1.ExtraValue(v)
+	}
+	access := &authorizationv1.SubjectAccessReview{
+		Spec: authorizationv1.SubjectAccessReviewSpec{
+			User:   user.Username,
+			UID:    user.UID,
+			Groups: user.Groups,
+			Extra:  extra,
+			ResourceAttributes: &authorizationv1.ResourceAttributes{
+				Namespace:   p.namespace,
+				Verb:        connectVerb,
+				Group:       constant.GroupDemoIO,
+				Resource:    constant.RsyncSourceResource,
+				Subresource: connectSubresource,
+				Name:        p.name,
+			},
+		},
+	}
+	access, err = p.kubeClient.AuthorizationV1().SubjectAccessReviews().Create(ctx, access, metav1.CreateOptions{})
+	if err != nil {
+		return "", fmt.Errorf("error reviewing access of `%s`: %s", user.Username, err)
+	}
+	if !access.Status.Allowed {
+		return "", fmt.Errorf("`%s` may not connect to rsync source `%s`", user.Username, p.name)
+	}
+	return user.Username, nil
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"bufio"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+)
+
+// dialProxy connects to a proxy, sends the token preamble unless tokenFile
+// is empty and then copies stdin to the connection and the connection to
+// stdout. It is meant to be used by rsync clients with
+//
+//	RSYNC_CONNECT_PROG='rsync-proxy connect %H:873'
+func dialProxy(address, tokenFile string, tlsConfig *tls.Config, stdin io.Reader, stdout io.Writer) error {
+	var conn net.Conn
+	var err error
+	if tlsConfig != nil {
+		conn, err = tls.Dial("tcp", address, tlsConfig)
+	} else {
+		conn, err = net.Dial("tcp", address)
+	}
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+	if tokenFile != "" {
+		token, err := os.ReadFile(tokenFile)
+		if err != nil {
+			return err
+		}
+		if _, err := fmt.Fprintf(conn, "%s%s\n", preamblePrefix, strings.TrimSpace(string(token))); err != nil {
+			return err
+		}
+		reply, err := reader.ReadString('\n')
+		if err != nil {
+			return fmt.Errorf("error reading reply of proxy: %s", err)
+		}
+		if reply = strings.TrimSpace(reply); reply != replyOK {
+			return fmt.Errorf("connection refused by proxy: %s", strings.TrimPrefix(reply, replyError+" "))
+		}
+	}
+	go func() {
+		io.Copy(conn, stdin)
+		closeWrite(conn)
+	}()
+	_, err = io.Copy(stdout, reader)
+	return err
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"crypto/tls"
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"time"
+
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+	"k8s.io/client-go/util/homedir"
+	"k8s.io/klog/v2"
+)
+
+// rsync-proxy authenticates connections to a rsync daemon with Kubernetes
+// ServiceAccount tokens. `rsync-proxy serve` runs next to the daemon,
+// `rsync-proxy connect` is used by clients as RSYNC_CONNECT_PROG.
+func main() {
+	if len(os.Args) < 2 {
+		fmt.Fprintf(os.Stderr, "usage: %s serve|connect [flags]\n", os.Args[0])
+		os.Exit(2)
+	}
+	switch os.Args[1] {
+	case "serve":
+		serve(os.Args[2:])
+	case "connect":
+		connect(os.Args[2:])
+	default:
+		fmt.Fprintf(os.Stderr, "unknown command `%s`, expected serve or connect\n", os.Args[1])
+		os.Exit(2)
+	}
+}
+
+func serve(args []string) {
+	flags := flag.NewFlagSet("serve", flag.ExitOnError)
+	klog.InitFlags(flags)
+	var kubeconfig *string
+	if home := homedir.HomeDir(); home != "" {
+		kubeconfig = flags.String("kubeconfig", filepath.Join(home, ".kube", "config"),
+			"(optional) absolute path to the kubeconfig file")
+	} else {
+		kubeconfig = flags.String("kubeconfig", "", "absolute path to the kubeconfig file")
+	}
+	p := &proxy{}
+	flags.StringVar(&p.listen, "listen", ":873", "Address the proxy listens on")
+	flags.StringVar(&p.backend, "backend", "127.0.0.1:8874", "Address of the rsync daemon")
+	flags.StringVar(&p.namespace, "namespace", "", "Namespace of the rsync source")
+	flags.StringVar(&p.name, "name", "", "Name of the rsync source")
+	flags.StringVar(&p.audience, "audience", defaultAudience, "Audience the tokens must be issued for")
+	flags.DurationVar(&p.preambleTimeout, "preamble-timeout", 10*time.Second, "Time a client has to send its token")
+	flags.StringVar(&p.auth, "auth", authServiceAccountToken, "Authentication of clients, ServiceAccountToken or None")
+	tlsCertFile := flags.String("tls-cert-file", "", "Certificate served to clients, enables TLS")
+	tlsKeyFile := flags.String("tls-key-file", "", "Key of the served certificate")
+	tlsClientCAFile := flags.String("tls-client-ca-file", "", "CA bundle client certificates are verified with, enables mTLS")
+	flags.Parse(args)
+	switch p.auth {
+	case authServiceAccountToken:
+		if p.namespace == "" || p.name == "" {
+			klog.Fatalf("--namespace and --name are required")
+		}
+		// Tokens for the API server would be replayable by every proxy.
+		if p.audience == "" {
+			klog.Fatalf("--audience is required")
+		}
+	case authNone:
+		if *tlsCertFile == "" {
+			klog.Fatalf("--auth=%s requires --tls-cert-file", authNone)
+		}
+	default:
+		klog.Fatalf("invalid --auth `%s`, expected %s or %s", p.auth, authServiceAccountToken, authNone)
+	}
+	if *tlsCertFile != "" {
+		var err error
+		if p.tlsConfig, err = serverTLSConfig(*tlsCertFile, *tlsKeyFile, *tlsClientCAFile); err != nil {
+			klog.Fatalf("Failed to load TLS config: %v", err)
+		}
+	}
+	if p.auth == authNone {
+		if err := p.run(); err != nil {
+			klog.Fatalf("Failed to run proxy: %v", err)
+		}
+		return
+	}
+
+	cfg, err := clientcmd.BuildConfigFromFlags("", *kubeconfig)
+	if err != nil {
+		cfg, err = rest.InClusterConfig()
+		if err != nil {
+			klog.Fatalf("error getting k8s config error: %s", err)
+		}
+	}
+	if p.kubeClient, err = kubernetes.NewForConfig(cfg); err != nil {
+		klog.Fatalf("Failed to create kube client: %v", err)
+	}
+	if err := p.run(); err != nil {
+		klog.Fatalf("Failed to run proxy: %v", err)
+	}
+}
+
+func connect(args []string) {
+	flags := flag.NewFlagSet("connect", flag.ExitOnError)
+	tokenFile := flags.String("token-file", "/var/run/secrets/rsync-proxy/token",
+		"File holding the ServiceAccount token sent to the proxy, no token is sent if empty. It must be a projected token for the audience of the proxy")
+	useTLS := flags.Bool("tls", false, "Connect with TLS")
+	tlsServerName := flags.String("tls-server-name", "", "Name the server certificate is verified for, defaults to the host")
+	tlsCAFile := flags.String("tls-ca-file", "", "CA bundle the server certificate is verified with, defaults to the system roots")
+	tlsCertFile := flags.String("tls-cert-file", "", "Client certificate for sources verifying clients")
+	tlsKeyFile := flags.String("tls-key-file", "", "Key of the client certificate")
+	flags.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: %s connect [flags] host:port\n", os.Args[0])
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+	if flags.NArg() != 1 {
+		flags.Usage()
+		os.Exit(2)
+	}
+	var tlsConfig *tls.Config
+	if *useTLS || *tlsCAFile != "" || *tlsCertFile != "" {
+		serverName := *tlsServerName
+		if serverName == "" {
+			serverName, _, _ = net.SplitHostPort(flags.Arg(0))
+		}
+		var err error
+		if tlsConfig, err = clientTLSConfig(serverName, *tlsCAFile, *tlsCertFile, *tlsKeyFile); err != nil {
+			fmt.Fprintf(os.Stderr, "rsync-proxy: %s\n", err)
+			os.Exit(1)
+		}
+	}
+	if err := dialProxy(flags.Arg(0), *tokenFile, tlsConfig, os.Stdin, os.Stdout); err != nil {
+		fmt.Fprintf(os.Stderr, "rsync-proxy: %s\n", err)
+		os.Exit(1)
+	}
+}
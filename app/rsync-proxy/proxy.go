@@ -0,0 +1,171 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/klog/v2"
+)
+
+// With ServiceAccountToken auth a client opens the connection with a
+// preamble line
+//
+//	AUTH <token>\n
+//
+// and the proxy answers with `OK\n` before it forwards the connection to
+// the daemon, or with `ERROR <reason>\n` before it closes it. Without auth
+// the connection is forwarded right after the TLS handshake.
+const (
+	preamblePrefix = "AUTH "
+	replyOK        = "OK"
+	replyError     = "ERROR"
+	// maxPreambleSize bounds the preamble, tokens are a few KiB at most.
+	maxPreambleSize = 16 * 1024
+
+	authServiceAccountToken = "ServiceAccountToken"
+	authNone                = "None"
+
+	// defaultAudience is the audience of the projected tokens clients
+	// send. Tokens for the API server aren't accepted.
+	defaultAudience = "rsync-proxy"
+)
+
+type proxy struct {
+	listen          string
+	backend         string
+	namespace       string
+	name            string
+	audience        string
+	preambleTimeout time.Duration
+	// auth is ServiceAccountToken or None, the latter only terminates TLS.
+	auth       string
+	tlsConfig  *tls.Config
+	kubeClient kubernetes.Interface
+}
+
+func (p *proxy) run() error {
+	ln, err := net.Listen("tcp", p.listen)
+	if err != nil {
+		return err
+	}
+	if p.tlsConfig != nil {
+		ln = tls.NewListener(ln, p.tlsConfig)
+	}
+	klog.Infof("Proxying %s to %s for rsync source `%s` in `%s` namespace",
+		p.listen, p.backend, p.name, p.namespace)
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			return err
+		}
+		go p.handle(conn)
+	}
+}
+
+func (p *proxy) handle(conn net.Conn) {
+	defer conn.Close()
+	remote := conn.RemoteAddr().String()
+
+	conn.SetReadDeadline(time.Now().Add(p.preambleTimeout))
+	user := "anonymous"
+	if tlsConn, ok := conn.(*tls.Conn); ok {
+		ctx, cancel := context.WithTimeout(context.Background(), p.preambleTimeout)
+		err := tlsConn.HandshakeContext(ctx)
+		cancel()
+		if err != nil {
+			klog.V(2).Infof("Rejected %s: TLS handshake failed: %s", remote, err)
+			return
+		}
+		if peers := tlsConn.ConnectionState().PeerCertificates; len(peers) != 0 {
+			user = peers[0].Subject.String()
+		}
+	}
+	reader := bufio.NewReaderSize(conn, maxPreambleSize)
+	if p.auth == authServiceAccountToken {
+		var ok bool
+		if user, ok = p.authenticate(conn, reader); !ok {
+			return
+		}
+	}
+	conn.SetReadDeadline(time.Time{})
+
+	backend, err := net.Dial("tcp", p.backend)
+	if err != nil {
+		klog.Errorf("Error connecting to daemon for %s: %s", remote, err)
+		if p.auth == authServiceAccountToken {
+			fmt.Fprintf(conn, "%s daemon unavailable\n", replyError)
+		}
+		return
+	}
+	defer backend.Close()
+	if p.auth == authServiceAccountToken {
+		if _, err := fmt.Fprintf(conn, "%s\n", replyOK); err != nil {
+			return
+		}
+	}
+	klog.V(2).Infof("Accepted %s as `%s`", remote, user)
+	// The reader may hold data the client sent after the preamble.
+	pipe(conn, reader, backend)
+}
+
+// authenticate reads the preamble of a client and authorizes its token. The
+// client is told why it was rejected.
+func (p *proxy) authenticate(conn net.Conn, reader *bufio.Reader) (string, bool) {
+	remote := conn.RemoteAddr().String()
+	line, err := reader.ReadSlice('\n')
+	if err != nil {
+		klog.V(2).Infof("Rejected %s: error reading preamble: %s", remote, err)
+		fmt.Fprintf(conn, "%s invalid preamble\n", replyError)
+		return "", false
+	}
+	token := strings.TrimSpace(strings.TrimPrefix(string(line), preamblePrefix))
+	if !strings.HasPrefix(string(line), preamblePrefix) || token == "" {
+		klog.V(2).Infof("Rejected %s: invalid preamble", remote)
+		fmt.Fprintf(conn, "%s invalid preamble\n", replyError)
+		return "", false
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), p.preambleTimeout)
+	user, err := p.authorize(ctx, token)
+	cancel()
+	if err != nil {
+		klog.Infof("Rejected %s: %s", remote, err)
+		fmt.Fprintf(conn, "%s %s\n", replyError, err)
+		return "", false
+	}
+	return user, true
+}
+
+// pipe copies in both directions until both sides are done. The write side
+// of a connection is closed when its source ends.
+func pipe(client net.Conn, clientReader io.Reader, backend net.Conn) {
+	wg := sync.WaitGroup{}
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		io.Copy(backend, clientReader)
+		closeWrite(backend)
+	}()
+	go func() {
+		defer wg.Done()
+		io.Copy(client, backend)
+		closeWrite(client)
+	}()
+	wg.Wait()
+}
+
+func closeWrite(conn net.Conn) {
+	if c, ok := conn.(interface{ CloseWrite() error }); ok {
+		c.CloseWrite()
+		return
+	}
+	conn.Close()
+}
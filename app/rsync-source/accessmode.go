@@ -0,0 +1,196 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	coordinationv1 "k8s.io/api/coordination/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	internalv1 "github.com/k8s-volume-copy/types/apis/demo.io/v1"
+	"github.com/k8s-volume-copy/types/constant"
+)
+
+const (
+	// accessModeAnnotation sets whether the data module is served ReadOnly,
+	// the default, or ReadWrite. It sets both the volume mount and the
+	// rsync module.
+	accessModeAnnotation = "demo.io/access-mode"
+	accessModeReadOnly   = "ReadOnly"
+	accessModeReadWrite  = "ReadWrite"
+	// confirmWriteAnnotation has to be `true` on the claim of a ReadWrite
+	// source, as clients can then overwrite the volume. It is set on the
+	// claim, so the owner of the data confirms, not the author of the
+	// source.
+	confirmWriteAnnotation = "demo.io/confirm-write"
+
+	// writeLockConditionType reports whether a ReadWrite source holds the
+	// write lease of its volume.
+	writeLockConditionType = "WriteLockAcquired"
+	// writeLockPollInterval is used while the lease is held by another
+	// source or the write isn't confirmed.
+	writeLockPollInterval = 30 * time.Second
+	// writeLockLeaseDuration is how long a lease that isn't renewed keeps
+	// other sources from writing. A holder renews it every
+	// writeLockRenewInterval.
+	writeLockLeaseDuration = 2 * time.Minute
+	writeLockRenewInterval = 30 * time.Second
+)
+
+// isReadWrite returns whether the data module of a rsync source is served
+// ReadWrite, after validating the access mode annotations.
+func isReadWrite(cr internalv1.RsyncSource) (bool, error) {
+	annotations := cr.GetAnnotations()
+	switch annotations[accessModeAnnotation] {
+	case "", accessModeReadOnly:
+		return false, nil
+	case accessModeReadWrite:
+	default:
+		return false, fmt.Errorf("invalid `%s` annotation `%s`, must be `%s` or `%s`", accessModeAnnotation,
+			annotations[accessModeAnnotation], accessModeReadOnly, accessModeReadWrite)
+	}
+	if cr.Spec.Volume.PersistentVolumeClaim == nil {
+		return false, fmt.Errorf("`%s: %s` requires a claim volume", accessModeAnnotation, accessModeReadWrite)
+	}
+	if isSnapshotConsistent(cr) {
+		return false, fmt.Errorf("`%s: %s` can't be combined with `%s: %s`",
+			accessModeAnnotation, accessModeReadWrite, consistencyAnnotation, consistencySnapshot)
+	}
+	return true, nil
+}
+
+// isWriteConfirmed returns whether the claim of a ReadWrite source has the
+// confirm write annotation.
+func (c *controller) isWriteConfirmed(ctx context.Context, cr internalv1.RsyncSource) (bool, error) {
+	pvc, err := c.kubeClient.CoreV1().PersistentVolumeClaims(cr.GetNamespace()).
+		Get(ctx, cr.Spec.Volume.PersistentVolumeClaim.ClaimName, metav1.GetOptions{})
+	if err != nil {
+		if errors.IsNotFound(err) {
+			return false, nil
+		}
+		return false, err
+	}
+	return pvc.GetAnnotations()[confirmWriteAnnotation] == "true", nil
+}
+
+// writeLockName returns the name of the lease that guards writes to the
+// volume of a rsync source. Sources serving the same claim, or the same
+// host path on the same node, share the lease.
+func writeLockName(cr internalv1.RsyncSource) (string, error) {
+	var key string
+	switch v := cr.Spec.Volume.VolumeSource; {
+	case v.PersistentVolumeClaim != nil:
+		key = "pvc/" + v.PersistentVolumeClaim.ClaimName
+	case v.HostPath != nil:
+		key = "hostPath/" + cr.Spec.HostName + "/" + v.HostPath.Path
+	default:
+		raw, err := json.Marshal(v)
+		if err != nil {
+			return "", err
+		}
+		key = string(raw)
+	}
+	sum := sha256.Sum256([]byte(key))
+	return "rsync-write-" + hex.EncodeToString(sum[:])[:16], nil
+}
+
+// ensureWriteLock acquires, renews or releases the write lease of the
+// volume of a rsync source. A lease that expired or whose holder no longer
+// exists is taken over. It returns whether the source holds the lease.
+func (c *controller) ensureWriteLock(ctx context.Context, want bool, cr internalv1.RsyncSource) (bool, error) {
+	name, err := writeLockName(cr)
+	if err != nil {
+		return false, err
+	}
+	leases := c.kubeClient.CoordinationV1().Leases(cr.GetNamespace())
+	lease, err := leases.Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		if !errors.IsNotFound(err) {
+			return false, err
+		}
+		if !want {
+			return false, nil
+		}
+		_, err = leases.Create(ctx, getWriteLockTemplate(name, cr), metav1.CreateOptions{})
+		if errors.IsAlreadyExists(err) {
+			return false, nil
+		}
+		return err == nil, err
+	}
+	holder := ""
+	if lease.Spec.HolderIdentity != nil {
+		holder = *lease.Spec.HolderIdentity
+	}
+	if !want {
+		if holder != cr.GetName() {
+			return false, nil
+		}
+		return false, leases.Delete(ctx, name, metav1.DeleteOptions{})
+	}
+	if holder == cr.GetName() {
+		if lease.Spec.RenewTime != nil && time.Since(lease.Spec.RenewTime.Time) < writeLockRenewInterval {
+			return true, nil
+		}
+		now := metav1.NewMicroTime(time.Now())
+		duration := int32(writeLockLeaseDuration.Seconds())
+		lease.Spec.RenewTime = &now
+		lease.Spec.LeaseDurationSeconds = &duration
+		_, err = leases.Update(ctx, lease, metav1.UpdateOptions{})
+		return err == nil, err
+	}
+	if !isLeaseExpired(lease, time.Now()) {
+		_, err = c.dynamicClient.Resource(rsyncSourceGVR).Namespace(cr.GetNamespace()).
+			Get(ctx, holder, metav1.GetOptions{})
+		if err == nil || !errors.IsNotFound(err) {
+			return false, err
+		}
+	}
+	template := getWriteLockTemplate(name, cr)
+	lease.Spec = template.Spec
+	_, err = leases.Update(ctx, lease, metav1.UpdateOptions{})
+	if errors.IsConflict(err) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// isLeaseExpired returns whether the holder of a lease didn't renew it in
+// time. Leases without duration never expire.
+func isLeaseExpired(lease *coordinationv1.Lease, now time.Time) bool {
+	if lease.Spec.LeaseDurationSeconds == nil {
+		return false
+	}
+	renewed := lease.Spec.RenewTime
+	if renewed == nil {
+		renewed = lease.Spec.AcquireTime
+	}
+	if renewed == nil {
+		return true
+	}
+	return now.After(renewed.Add(time.Duration(*lease.Spec.LeaseDurationSeconds) * time.Second))
+}
+
+func getWriteLockTemplate(name string, cr internalv1.RsyncSource) *coordinationv1.Lease {
+	holder := cr.GetName()
+	now := metav1.NewMicroTime(time.Now())
+	duration := int32(writeLockLeaseDuration.Seconds())
+	return &coordinationv1.Lease{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: name,
+			Labels: map[string]string{
+				constant.CreatedByLabel: constant.ComponentNameRsyncSourceController,
+			},
+		},
+		Spec: coordinationv1.LeaseSpec{
+			HolderIdentity:       &holder,
+			AcquireTime:          &now,
+			RenewTime:            &now,
+			LeaseDurationSeconds: &duration,
+		},
+	}
+}
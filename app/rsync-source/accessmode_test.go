@@ -0,0 +1,66 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	coordinationv1 "k8s.io/api/coordination/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestIsLeaseExpired(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	micro := func(d time.Duration) *metav1.MicroTime {
+		t := metav1.NewMicroTime(now.Add(d))
+		return &t
+	}
+	seconds := func(s int32) *int32 {
+		return &s
+	}
+	tests := []struct {
+		name string
+		spec coordinationv1.LeaseSpec
+		want bool
+	}{
+		{
+			name: "without duration",
+			spec: coordinationv1.LeaseSpec{AcquireTime: micro(-time.Hour)},
+		},
+		{
+			name: "renewed recently",
+			spec: coordinationv1.LeaseSpec{
+				AcquireTime:          micro(-time.Hour),
+				RenewTime:            micro(-time.Minute),
+				LeaseDurationSeconds: seconds(120),
+			},
+		},
+		{
+			name: "not renewed in time",
+			spec: coordinationv1.LeaseSpec{
+				AcquireTime:          micro(-time.Hour),
+				RenewTime:            micro(-3 * time.Minute),
+				LeaseDurationSeconds: seconds(120),
+			},
+			want: true,
+		},
+		{
+			name: "acquired recently without renewal",
+			spec: coordinationv1.LeaseSpec{
+				AcquireTime:          micro(-time.Minute),
+				LeaseDurationSeconds: seconds(120),
+			},
+		},
+		{
+			name: "neither acquired nor renewed",
+			spec: coordinationv1.LeaseSpec{LeaseDurationSeconds: seconds(120)},
+			want: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isLeaseExpired(&coordinationv1.Lease{Spec: tt.spec}, now); got != tt.want {
+				t.Errorf("isLeaseExpired() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
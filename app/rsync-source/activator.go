@@ -0,0 +1,202 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/apimachinery/pkg/util/validation"
+
+	"github.com/k8s-volume-copy/types/constant"
+)
+
+const (
+	// activatorAnnotation set to `Enabled` runs the rsync-activator for a
+	// source that scales to zero when idle. The activator owns the Service
+	// while the daemon is down and wakes the source on the first
+	// connection. It requires `demo.io/idle-timeout` and implies
+	// `demo.io/expiry-action: ScaleToZero`.
+	activatorAnnotation = "demo.io/activator"
+	activatorEnabled    = "Enabled"
+
+	// activatorServiceAccount is the ServiceAccount of the activator, it
+	// must be allowed to get and patch the rsync source and to get its
+	// deployment. It isn't configurable, so sources can't run as other
+	// ServiceAccounts of their namespace.
+	activatorServiceAccount = "rsync-activator"
+	// activatorBasePort is the first port the activator listens on, one
+	// per service port. It is unprivileged, so the activator runs as any
+	// user.
+	activatorBasePort = 10000
+	// activatorHealthPort serves the readiness probe of the activator, a
+	// probe of a service port would wake the source.
+	activatorHealthPort = 9091
+	// activatorPollInterval is used while the Service waits for a woken
+	// daemon to become ready.
+	activatorPollInterval = 5 * time.Second
+)
+
+type activatorConfig struct{}
+
+func activatorConfigFromAnnotations(annotations map[string]string, lc *lifecycleConfig) (*activatorConfig, error) {
+	switch v := annotations[activatorAnnotation]; v {
+	case "":
+		return nil, nil
+	case activatorEnabled:
+	default:
+		return nil, fmt.Errorf("invalid `%s` annotation `%s`, only `%s` is supported",
+			activatorAnnotation, v, activatorEnabled)
+	}
+	if lc.idleTimeout == 0 {
+		return nil, fmt.Errorf("`%s` requires the `%s` annotation", activatorAnnotation, idleTimeoutAnnotation)
+	}
+	// A woken source would expire again right away.
+	if lc.ttl != 0 {
+		return nil, fmt.Errorf("`%s` can't be used with `%s`", activatorAnnotation, ttlAnnotation)
+	}
+	if annotations[expiryActionAnnotation] == expiryActionDelete {
+		return nil, fmt.Errorf("`%s` can't be used with `%s: %s`",
+			activatorAnnotation, expiryActionAnnotation, expiryActionDelete)
+	}
+	lc.action = expiryActionScale
+	return &activatorConfig{}, nil
+}
+
+// activatorName is the name of the activator Deployment and the value of
+// its app label. Both are limited to 63 chars, longer names of sources are
+// shortened.
+func activatorName(rsyncSourceName string) string {
+	return shortenName(rsyncSourceName+"-activator", validation.DNS1035LabelMaxLength)
+}
+
+// daemonServiceName is the Service of the daemon pods the activator
+// forwards to. Service names are DNS-1035 labels.
+func daemonServiceName(rsyncSourceName string) string {
+	return shortenName(rsyncSourceName+"-daemon", validation.DNS1035LabelMaxLength)
+}
+
+// activatorSelector selects the activator pods instead of the daemon pods.
+func (tc *templateConfig) activatorSelector() map[string]string {
+	return map[string]string{
+		constant.CreatedByLabel: constant.ComponentNameRsyncSourceController,
+		constant.NameLabel:      tc.name,
+		constant.AppLabel:       activatorName(tc.name),
+	}
+}
+
+func (tc *templateConfig) getActivatorDeploymentTemplate() *appsv1.Deployment {
+	replicas := int32(1)
+	nonRoot := true
+	user := int64(65534)
+	allowPrivilegeEscalation := false
+	readOnlyRootFilesystem := true
+	ports := []corev1.ContainerPort{}
+	pairs := []string{}
+	for i, p := range tc.backend.servicePorts() {
+		listen := activatorBasePort + i
+		// The named target port of the Service resolves to the activator.
+		ports = append(ports, corev1.ContainerPort{
+			Name:          p.TargetPort.String(),
+			ContainerPort: int32(listen),
+		})
+		pairs = append(pairs, fmt.Sprintf("%d:%d", listen, p.Port))
+	}
+	deploy := appsv1.Deployment{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: "apps/v1",
+			Kind:       "Deployment",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:   activatorName(tc.name),
+			Labels: tc.activatorSelector(),
+		},
+		Spec: appsv1.DeploymentSpec{
+			Replicas: &replicas,
+			Selector: &metav1.LabelSelector{
+				MatchLabels: tc.activatorSelector(),
+			},
+			Template: corev1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{
+					Labels: tc.activatorSelector(),
+					// The activator is restarted with the ports of a new
+					// configuration.
+					Annotations: map[string]string{
+						configHashAnnotation: tc.configHash,
+					},
+				},
+				Spec: corev1.PodSpec{
+					ServiceAccountName: activatorServiceAccount,
+					SecurityContext: &corev1.PodSecurityContext{
+						RunAsNonRoot: &nonRoot,
+						RunAsUser:    &user,
+						RunAsGroup:   &user,
+						SeccompProfile: &corev1.SeccompProfile{
+							Type: corev1.SeccompProfileTypeRuntimeDefault,
+						},
+					},
+					Containers: []corev1.Container{
+						{
+							Name:            "rsync-activator",
+							Image:           rsyncActivatorImage,
+							ImagePullPolicy: corev1.PullAlways,
+							Command: []string{
+								"rsync-activator",
+								"--namespace=" + tc.namespace,
+								"--name=" + tc.name,
+								"--backend=" + daemonServiceName(tc.name) + "." + tc.namespace + ".svc",
+								"--ports=" + strings.Join(pairs, ","),
+								"--health-listen=:" + strconv.Itoa(activatorHealthPort),
+							},
+							SecurityContext: &corev1.SecurityContext{
+								AllowPrivilegeEscalation: &allowPrivilegeEscalation,
+								ReadOnlyRootFilesystem:   &readOnlyRootFilesystem,
+								Capabilities: &corev1.Capabilities{
+									Drop: []corev1.Capability{"ALL"},
+								},
+							},
+							Ports: ports,
+							ReadinessProbe: &corev1.Probe{
+								ProbeHandler: corev1.ProbeHandler{
+									HTTPGet: &corev1.HTTPGetAction{
+										Path: "/healthz",
+										Port: intstr.FromInt(activatorHealthPort),
+									},
+								},
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+	return &deploy
+}
+
+// getDaemonSvcTemplate returns the Service of the daemon pods, which keeps
+// selecting them while the Service of the source selects the activator.
+func (tc *templateConfig) getDaemonSvcTemplate() *corev1.Service {
+	svc := tc.getSvcTemplate()
+	svc.Name = daemonServiceName(tc.name)
+	return svc
+}
+
+// isDaemonReady returns whether the deployment of a rsync source has a
+// ready replica.
+func (c *controller) isDaemonReady(ctx context.Context, namespace, name string) (bool, error) {
+	deployment, err := c.kubeClient.AppsV1().Deployments(namespace).
+		Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		if errors.IsNotFound(err) {
+			return false, nil
+		}
+		return false, err
+	}
+	return deployment.Status.ReadyReplicas > 0, nil
+}
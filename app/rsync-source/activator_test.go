@@ -0,0 +1,52 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+func TestActivatorNames(t *testing.T) {
+	// Sources created for nodes by volume-source have names of 63 chars.
+	nodeSource := strings.Repeat("a", 52) + "-0123456789"
+	tests := []struct {
+		name          string
+		source        string
+		wantActivator string
+		wantDaemon    string
+	}{
+		{
+			name:          "short name",
+			source:        "src",
+			wantActivator: "src-activator",
+			wantDaemon:    "src-daemon",
+		},
+		{
+			name:   "name of a node source",
+			source: nodeSource,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			activator, daemon := activatorName(tt.source), daemonServiceName(tt.source)
+			for _, name := range []string{activator, daemon} {
+				if errs := validation.IsDNS1035Label(name); len(errs) != 0 {
+					t.Errorf("%s is no DNS-1035 label: %v", name, errs)
+				}
+				if errs := validation.IsValidLabelValue(name); len(errs) != 0 {
+					t.Errorf("%s is no label value: %v", name, errs)
+				}
+			}
+			if activator == daemon || activator == tt.source || daemon == tt.source {
+				t.Errorf("names of %s aren't distinct: %s, %s", tt.source, activator, daemon)
+			}
+			if tt.wantActivator != "" && activator != tt.wantActivator {
+				t.Errorf("activatorName() = %s, want %s", activator, tt.wantActivator)
+			}
+			if tt.wantDaemon != "" && daemon != tt.wantDaemon {
+				t.Errorf("daemonServiceName() = %s, want %s", daemon, tt.wantDaemon)
+			}
+		})
+	}
+}
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	internalv1 "github.com/k8s-volume-copy/types/apis/demo.io/v1"
+	"github.com/robfig/cron/v3"
+)
+
+const (
+	// availabilityAnnotation holds a JSON availability schedule. Outside of
+	// its windows the daemon is scaled to zero, so connections are refused.
+	// The activator doesn't wake a source outside of its windows, it closes
+	// held connections once its ready timeout passed.
+	availabilityAnnotation = "demo.io/availability"
+	// windowEndAnnotation reports when the open window closes.
+	windowEndAnnotation = "demo.io/window-end"
+	// nextWindowAnnotation reports when the next window opens.
+	nextWindowAnnotation = "demo.io/next-window"
+
+	// windowOpenConditionType reports whether a rsync source with an
+	// availability schedule is inside one of its windows.
+	windowOpenConditionType = "WindowOpen"
+
+	// maxWindowStarts bounds the window starts checked per window, e.g. a
+	// window every minute open for a year. The window open at now may close
+	// later than reported then, it is checked again once the reported end
+	// passed.
+	maxWindowStarts = 1000
+)
+
+// availability is the schedule of a rsync source, e.g.
+//
+//	{"timeZone": "Europe/Berlin", "windows": [{"schedule": "0 2 * * 6", "duration": "4h"}]}
+type availability struct {
+	// TimeZone of the schedules as IANA name. Defaults to UTC.
+	TimeZone string `json:"timeZone,omitempty"`
+	// Windows are open from every start of their schedule for their
+	// duration.
+	Windows []availabilityWindow `json:"windows"`
+}
+
+type availabilityWindow struct {
+	// Schedule of the window starts in standard cron format.
+	Schedule string `json:"schedule"`
+	// Duration of the window as Go duration, e.g. `2h`.
+	Duration string `json:"duration"`
+}
+
+// availabilitySchedule is the parsed availability of a source.
+type availabilitySchedule struct {
+	location *time.Location
+	windows  []scheduleWindow
+}
+
+type scheduleWindow struct {
+	schedule cron.Schedule
+	duration time.Duration
+}
+
+// availabilityFromAnnotations returns the validated availability schedule
+// of a source, nil if it is always available.
+func availabilityFromAnnotations(annotations map[string]string) (*availabilitySchedule, error) {
+	raw, ok := annotations[availabilityAnnotation]
+	if !ok {
+		return nil, nil
+	}
+	a := availability{}
+	if err := json.Unmarshal([]byte(raw), &a); err != nil {
+		return nil, fmt.Errorf("invalid `%s` annotation, error: %s", availabilityAnnotation, err)
+	}
+	if len(a.Windows) == 0 {
+		return nil, fmt.Errorf("invalid `%s` annotation, at least one window is required", availabilityAnnotation)
+	}
+	s := &availabilitySchedule{
+		location: time.UTC,
+	}
+	if a.TimeZone != "" {
+		var err error
+		if s.location, err = time.LoadLocation(a.TimeZone); err != nil {
+			return nil, fmt.Errorf("invalid time zone `%s` in `%s` annotation, error: %s",
+				a.TimeZone, availabilityAnnotation, err)
+		}
+	}
+	for _, w := range a.Windows {
+		schedule, err := cron.ParseStandard(w.Schedule)
+		if err != nil {
+			return nil, fmt.Errorf("invalid schedule `%s` in `%s` annotation, error: %s",
+				w.Schedule, availabilityAnnotation, err)
+		}
+		duration, err := time.ParseDuration(w.Duration)
+		if err != nil || duration <= 0 {
+			return nil, fmt.Errorf("invalid duration `%s` in `%s` annotation, expected a duration like `2h`",
+				w.Duration, availabilityAnnotation)
+		}
+		// The cron parser gives up on schedules that never match, e.g.
+		// `0 0 30 2 *`.
+		if schedule.Next(time.Now().In(s.location)).IsZero() {
+			return nil, fmt.Errorf("invalid schedule `%s` in `%s` annotation, it never starts a window",
+				w.Schedule, availabilityAnnotation)
+		}
+		s.windows = append(s.windows, scheduleWindow{schedule: schedule, duration: duration})
+	}
+	return s, nil
+}
+
+// window returns when the window open at now closes, zero if none is
+// open, and when the next window opens after that.
+func (s *availabilitySchedule) window(now time.Time) (time.Time, time.Time) {
+	now = now.In(s.location)
+	var end, next time.Time
+	for _, w := range s.windows {
+		// Every start within the duration before now opens a window.
+		start := w.schedule.Next(now.Add(-w.duration))
+		for i := 0; i < maxWindowStarts && !start.IsZero() && !start.After(now); i++ {
+			if windowEnd := start.Add(w.duration); windowEnd.After(end) {
+				end = windowEnd
+			}
+			start = w.schedule.Next(start)
+		}
+	}
+	from := now
+	if end.After(now) {
+		from = end
+	}
+	for _, w := range s.windows {
+		if start := w.schedule.Next(from); !start.IsZero() && (next.IsZero() || start.Before(next)) {
+			next = start
+		}
+	}
+	return end, next
+}
+
+// ensureAvailability returns whether a rsync source is inside a window of
+// its availability schedule and reports its windows. A source without
+// schedule is always available. requeue is set to the next change.
+func (c *controller) ensureAvailability(ctx context.Context, cr internalv1.RsyncSource) (bool, time.Duration, error) {
+	s, err := availabilityFromAnnotations(cr.GetAnnotations())
+	if err != nil {
+		// The config is reported invalid, it is never available until fixed.
+		return false, 0, nil
+	}
+	if s == nil {
+		_, hasEnd := cr.GetAnnotations()[windowEndAnnotation]
+		if _, hasNext := cr.GetAnnotations()[nextWindowAnnotation]; !hasEnd && !hasNext {
+			return true, 0, nil
+		}
+		return true, 0, c.setRsyncSourceStatus(ctx, cr.GetNamespace(), cr.GetName(), map[string]*string{
+			windowEndAnnotation:  nil,
+			nextWindowAnnotation: nil,
+		}, metav1.Condition{
+			Type:    windowOpenConditionType,
+			Status:  metav1.ConditionTrue,
+			Reason:  "AlwaysAvailable",
+			Message: "the source has no availability schedule",
+		})
+	}
+	now := time.Now()
+	end, next := s.window(now)
+	values := map[string]*string{
+		windowEndAnnotation:  nil,
+		nextWindowAnnotation: nil,
+	}
+	if !next.IsZero() {
+		rawNext := next.UTC().Format(time.RFC3339)
+		values[nextWindowAnnotation] = &rawNext
+	}
+	condition := metav1.Condition{
+		Type:   windowOpenConditionType,
+		Status: metav1.ConditionFalse,
+		Reason: "OutsideWindow",
+	}
+	open := !end.IsZero()
+	change := next
+	if open {
+		rawEnd := end.UTC().Format(time.RFC3339)
+		values[windowEndAnnotation] = &rawEnd
+		condition.Status = metav1.ConditionTrue
+		condition.Reason = "InsideWindow"
+		condition.Message = fmt.Sprintf("the window closes at %s", rawEnd)
+		change = end
+	} else if !next.IsZero() {
+		condition.Message = fmt.Sprintf("the next window opens at %s", *values[nextWindowAnnotation])
+	} else {
+		condition.Message = "no window opens anymore"
+	}
+	if err := c.setRsyncSourceStatus(ctx, cr.GetNamespace(), cr.GetName(), values, condition); err != nil {
+		return false, 0, err
+	}
+	if change.IsZero() {
+		return open, 0, nil
+	}
+	// The window is checked again right after it changed.
+	return open, change.Sub(now) + time.Second, nil
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestAvailabilityFromAnnotations(t *testing.T) {
+	tests := []struct {
+		name        string
+		annotations map[string]string
+		wantWindows int
+		wantErr     bool
+	}{
+		{
+			name: "unset",
+		},
+		{
+			name: "valid",
+			annotations: map[string]string{availabilityAnnotation: `{"timeZone": "UTC", "windows": [
+				{"schedule": "0 2 * * 6", "duration": "4h"},
+				{"schedule": "@daily", "duration": "30m"}]}`},
+			wantWindows: 2,
+		},
+		{
+			name:        "invalid JSON",
+			annotations: map[string]string{availabilityAnnotation: `{"windows": `},
+			wantErr:     true,
+		},
+		{
+			name:        "no window",
+			annotations: map[string]string{availabilityAnnotation: `{"windows": []}`},
+			wantErr:     true,
+		},
+		{
+			name: "unknown time zone",
+			annotations: map[string]string{availabilityAnnotation: `{"timeZone": "Mars/Olympus",
+				"windows": [{"schedule": "0 2 * * 6", "duration": "4h"}]}`},
+			wantErr: true,
+		},
+		{
+			name:        "invalid schedule",
+			annotations: map[string]string{availabilityAnnotation: `{"windows": [{"schedule": "0 2 * *", "duration": "4h"}]}`},
+			wantErr:     true,
+		},
+		{
+			name:        "schedule never starts",
+			annotations: map[string]string{availabilityAnnotation: `{"windows": [{"schedule": "0 0 30 2 *", "duration": "4h"}]}`},
+			wantErr:     true,
+		},
+		{
+			name:        "zero duration",
+			annotations: map[string]string{availabilityAnnotation: `{"windows": [{"schedule": "0 2 * * 6", "duration": "0s"}]}`},
+			wantErr:     true,
+		},
+		{
+			name:        "duration without unit",
+			annotations: map[string]string{availabilityAnnotation: `{"windows": [{"schedule": "0 2 * * 6", "duration": "4"}]}`},
+			wantErr:     true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := availabilityFromAnnotations(tt.annotations)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("availabilityFromAnnotations() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got == nil {
+				if tt.wantWindows != 0 {
+					t.Fatalf("availabilityFromAnnotations() = nil, want %d windows", tt.wantWindows)
+				}
+				return
+			}
+			if len(got.windows) != tt.wantWindows {
+				t.Errorf("availabilityFromAnnotations() = %d windows, want %d", len(got.windows), tt.wantWindows)
+			}
+		})
+	}
+}
+
+func TestAvailabilityWindow(t *testing.T) {
+	// 2026-10-17 is a Saturday.
+	saturday := time.Date(2026, 10, 17, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name     string
+		raw      string
+		now      time.Time
+		wantEnd  time.Time
+		wantNext time.Time
+	}{
+		{
+			name:     "inside window",
+			raw:      `{"windows": [{"schedule": "0 2 * * 6", "duration": "4h"}]}`,
+			now:      saturday.Add(3 * time.Hour),
+			wantEnd:  saturday.Add(6 * time.Hour),
+			wantNext: saturday.AddDate(0, 0, 7).Add(2 * time.Hour),
+		},
+		{
+			name:     "outside window",
+			raw:      `{"windows": [{"schedule": "0 2 * * 6", "duration": "4h"}]}`,
+			now:      saturday.Add(7 * time.Hour),
+			wantNext: saturday.AddDate(0, 0, 7).Add(2 * time.Hour),
+		},
+		{
+			name:     "window closed at its end",
+			raw:      `{"windows": [{"schedule": "0 2 * * 6", "duration": "4h"}]}`,
+			now:      saturday.Add(6 * time.Hour),
+			wantNext: saturday.AddDate(0, 0, 7).Add(2 * time.Hour),
+		},
+		{
+			name:     "overlapping windows",
+			raw:      `{"windows": [{"schedule": "0 * * * *", "duration": "90m"}]}`,
+			now:      saturday.Add(10*time.Hour + 30*time.Minute),
+			wantEnd:  saturday.Add(11*time.Hour + 30*time.Minute),
+			wantNext: saturday.Add(12 * time.Hour),
+		},
+		{
+			name: "latest end of several windows",
+			raw: `{"windows": [
+				{"schedule": "0 2 * * 6", "duration": "4h"},
+				{"schedule": "0 3 * * 6", "duration": "1h"}]}`,
+			now:      saturday.Add(3*time.Hour + 30*time.Minute),
+			wantEnd:  saturday.Add(6 * time.Hour),
+			wantNext: saturday.AddDate(0, 0, 7).Add(2 * time.Hour),
+		},
+		{
+			name:     "time zone",
+			raw:      `{"timeZone": "Europe/Berlin", "windows": [{"schedule": "0 2 * * 6", "duration": "4h"}]}`,
+			now:      saturday.Add(time.Hour),
+			wantEnd:  saturday.Add(4 * time.Hour),
+			wantNext: saturday.AddDate(0, 0, 7),
+		},
+		{
+			name:     "window starts capped",
+			raw:      `{"windows": [{"schedule": "* * * * *", "duration": "8760h"}]}`,
+			now:      saturday,
+			wantEnd:  saturday.Add(maxWindowStarts * time.Minute),
+			wantNext: saturday.Add((maxWindowStarts + 1) * time.Minute),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, err := availabilityFromAnnotations(map[string]string{availabilityAnnotation: tt.raw})
+			if err != nil {
+				t.Fatalf("availabilityFromAnnotations() error = %v", err)
+			}
+			end, next := s.window(tt.now)
+			if !end.Equal(tt.wantEnd) {
+				t.Errorf("window() end = %v, want %v", end, tt.wantEnd)
+			}
+			if !next.Equal(tt.wantNext) {
+				t.Errorf("window() next = %v, want %v", next, tt.wantNext)
+			}
+		})
+	}
+}
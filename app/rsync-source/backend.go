@@ -0,0 +1,152 @@
+package main
+
+import (
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+)
+
+const (
+	// backendAnnotation selects the protocol the volumes of a source are
+	// served with. Defaults to `Rsync`.
+	backendAnnotation = "demo.io/backend"
+	backendRsync      = "Rsync"
+	backendSFTP       = "SFTP"
+	backendHTTP       = "HTTP"
+
+	// serveRoot is the directory the SFTP and HTTP backends serve, with the
+	// data volume and every module in a directory of its name.
+	serveRoot = "/srv"
+)
+
+// backend serves the volumes of a source with one protocol. It produces the
+// server containers, their configuration files and the service ports.
+type backend interface {
+	// configFiles returns the files of the ConfigMap of the source, which
+	// is mounted as `config` volume.
+	configFiles() (map[string]string, error)
+	// containers returns the containers of the daemon pod.
+	containers() []corev1.Container
+	// volumes returns the volumes of the containers besides the served
+	// volumes and the config volume.
+	volumes() []corev1.Volume
+	servicePorts() []corev1.ServicePort
+}
+
+// backendFromAnnotations selects the backend of a source. It is called once
+// the other annotations were parsed into tc, which isn't changed. The SSH
+// config depends on the backend, it is returned with it.
+func backendFromAnnotations(tc *templateConfig, annotations map[string]string) (backend, *sshConfig, error) {
+	name := annotations[backendAnnotation]
+	switch name {
+	case "", backendRsync:
+		if tc.ssh != nil {
+			return &rsyncSSHBackend{tc: tc}, tc.ssh, nil
+		}
+		return &rsyncBackend{tc: tc}, nil, nil
+	case backendSFTP, backendHTTP:
+	default:
+		return nil, nil, fmt.Errorf("invalid `%s` annotation `%s`, expected `%s`, `%s` or `%s`",
+			backendAnnotation, name, backendRsync, backendSFTP, backendHTTP)
+	}
+	// Daemon features aren't available with other protocols.
+	for _, key := range []string{authAnnotation, tlsAnnotation, transportAnnotation, bwlimitAnnotation} {
+		if _, ok := annotations[key]; ok {
+			return nil, nil, fmt.Errorf("`%s` can't be used with the `%s` backend", key, name)
+		}
+	}
+	if tc.logShipper {
+		return nil, nil, fmt.Errorf("`%s: %s` can't be used with the `%s` backend",
+			transferLogAnnotation, transferLogEnabled, name)
+	}
+	if len(tc.options) != 0 {
+		return nil, nil, fmt.Errorf("module options can't be used with the `%s` backend", name)
+	}
+	if name == backendHTTP {
+		// The HTTP backend only serves reads, a writable claim would take
+		// the write lock for nothing.
+		if tc.readWrite {
+			return nil, nil, fmt.Errorf("`%s: %s` can't be used with the `%s` backend",
+				accessModeAnnotation, accessModeReadWrite, name)
+		}
+		return &httpBackend{tc: tc}, nil, nil
+	}
+	if tc.security.nonRoot {
+		return nil, nil, fmt.Errorf("`%s` can't be used with the `%s` backend", runAsUserAnnotation, name)
+	}
+	ssh, err := sshConfigFromAnnotations(annotations)
+	if err != nil {
+		return nil, nil, err
+	}
+	return &sftpBackend{tc: tc}, ssh, nil
+}
+
+// servedMounts mounts the data volume and the modules below root, each in
+// a directory of its name.
+func (tc *templateConfig) servedMounts(root string) []corev1.VolumeMount {
+	mounts := tc.volumeMounts()
+	mounts[0].MountPath = root + "/" + dataModuleName
+	for i, m := range tc.modules {
+		mounts[i+1].MountPath = root + "/" + m.Name
+	}
+	return mounts
+}
+
+// configMount mounts a file of the config volume.
+func configMount(file, path string) corev1.VolumeMount {
+	return corev1.VolumeMount{
+		Name:      "config",
+		MountPath: path,
+		SubPath:   file,
+	}
+}
+
+// rsyncBackend runs the rsync daemon, optionally behind the proxy sidecar.
+type rsyncBackend struct {
+	tc *templateConfig
+}
+
+func (b *rsyncBackend) configFiles() (map[string]string, error) {
+	return map[string]string{
+		"rsyncd.conf": b.tc.rsyncdConf,
+	}, nil
+}
+
+func (b *rsyncBackend) containers() []corev1.Container {
+	tc := b.tc
+	_, runMounts := tc.runVolumes()
+	_, secretsMounts := tc.secretsVolumes()
+	return append([]corev1.Container{
+		{
+			Name:            "rsync-daemon",
+			Image:           tc.rsync.Image,
+			ImagePullPolicy: corev1.PullAlways,
+			Command:         tc.command(),
+			SecurityContext: tc.security.containerSecurityContext(),
+			Env: []corev1.EnvVar{
+				tc.credentialEnv("RSYNC_PASSWORD", credentialsPasswordKey),
+			},
+			Ports: tc.daemonPorts(),
+			VolumeMounts: append(append(append(append(tc.volumeMounts(), runMounts...), secretsMounts...),
+				tc.daemonLogMounts()...), configMount("rsyncd.conf", "/etc/rsyncd.conf")),
+		},
+	}, append(tc.proxyContainers(), tc.logShipperContainers()...)...)
+}
+
+func (b *rsyncBackend) volumes() []corev1.Volume {
+	runVolumes, _ := b.tc.runVolumes()
+	secretsVolumes, _ := b.tc.secretsVolumes()
+	return append(append(append(runVolumes, secretsVolumes...), b.tc.proxyVolumes()...), b.tc.logShipperVolumes()...)
+}
+
+func (b *rsyncBackend) servicePorts() []corev1.ServicePort {
+	return []corev1.ServicePort{
+		{
+			Name:       "rsync-daemon",
+			Port:       rootPort,
+			TargetPort: intstr.FromString("rsync-daemon"),
+			Protocol:   corev1.ProtocolTCP,
+		},
+	}
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestTransferLogWithBackends(t *testing.T) {
+	tests := []struct {
+		name        string
+		annotations map[string]string
+		wantShipper bool
+		wantErr     bool
+	}{
+		{
+			name: "rsync without transfer log",
+		},
+		{
+			name:        "rsync with transfer log",
+			annotations: map[string]string{transferLogAnnotation: transferLogEnabled},
+			wantShipper: true,
+		},
+		{
+			name:        "invalid transfer log",
+			annotations: map[string]string{transferLogAnnotation: "true"},
+			wantErr:     true,
+		},
+		{
+			name:        "HTTP with disabled transfer log",
+			annotations: map[string]string{backendAnnotation: backendHTTP, transferLogAnnotation: transferLogDisabled},
+		},
+		{
+			name: "SFTP with disabled transfer log",
+			annotations: map[string]string{
+				backendAnnotation:           backendSFTP,
+				transferLogAnnotation:       transferLogDisabled,
+				sshAuthorizedKeysAnnotation: "keys",
+			},
+		},
+		{
+			name:        "HTTP with transfer log",
+			annotations: map[string]string{backendAnnotation: backendHTTP, transferLogAnnotation: transferLogEnabled},
+			wantErr:     true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tc := &templateConfig{}
+			var err error
+			if tc.logShipper, err = logShipperFromAnnotations(tt.annotations); err == nil {
+				_, _, err = backendFromAnnotations(tc, tt.annotations)
+			}
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && tc.logShipper != tt.wantShipper {
+				t.Errorf("logShipper = %t, want %t", tc.logShipper, tt.wantShipper)
+			}
+		})
+	}
+}
+
+func TestAccessModeWithBackends(t *testing.T) {
+	tests := []struct {
+		name        string
+		backend     string
+		annotations map[string]string
+		readWrite   bool
+		wantErr     bool
+	}{
+		{name: "rsync read write", backend: backendRsync, readWrite: true},
+		{
+			name:        "SFTP read write",
+			backend:     backendSFTP,
+			annotations: map[string]string{sshAuthorizedKeysAnnotation: "keys"},
+			readWrite:   true,
+		},
+		{name: "HTTP read only", backend: backendHTTP},
+		{name: "HTTP read write", backend: backendHTTP, readWrite: true, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			annotations := map[string]string{backendAnnotation: tt.backend}
+			for k, v := range tt.annotations {
+				annotations[k] = v
+			}
+			tc := &templateConfig{readWrite: tt.readWrite}
+			if _, _, err := backendFromAnnotations(tc, annotations); (err != nil) != tt.wantErr {
+				t.Errorf("backendFromAnnotations() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
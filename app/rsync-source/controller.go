@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"os"
 	"os/signal"
+	"reflect"
 	"strings"
 	"syscall"
 	"time"
@@ -21,7 +22,11 @@ import (
 	"k8s.io/client-go/dynamic"
 	"k8s.io/client-go/dynamic/dynamicinformer"
 	"k8s.io/client-go/dynamic/dynamiclister"
+	"k8s.io/client-go/informers"
 	"k8s.io/client-go/kubernetes"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/metadata"
+	"k8s.io/client-go/metadata/metadatainformer"
 	"k8s.io/client-go/rest"
 	"k8s.io/client-go/tools/cache"
 	"k8s.io/client-go/util/workqueue"
@@ -31,6 +36,12 @@ import (
 	"github.com/k8s-volume-copy/types/constant"
 )
 
+const (
+	// snapshotPollInterval is used while waiting for a volume snapshot to
+	// become ready.
+	snapshotPollInterval = 5 * time.Second
+)
+
 var (
 	rsyncSourceGVR = schema.GroupVersionResource{
 		Group:    constant.GroupDemoIO,
@@ -45,10 +56,23 @@ var (
 )
 
 type controller struct {
+	config        *rest.Config
 	kubeClient    *kubernetes.Clientset
 	dynamicClient dynamic.Interface
 	vrLister      dynamiclister.Lister
 	vrSynced      cache.InformerSynced
+	rsIndexer     cache.Indexer
+	podIndexer    cache.Indexer
+	podSynced     cache.InformerSynced
+	vaIndexer     cache.Indexer
+	vaSynced      cache.InformerSynced
+	pvLister      corelisters.PersistentVolumeLister
+	pvSynced      cache.InformerSynced
+	pvcLister     corelisters.PersistentVolumeClaimLister
+	pvcSynced     cache.InformerSynced
+	nodeLister    corelisters.NodeLister
+	nodeSynced    cache.InformerSynced
+	secretSynced  cache.InformerSynced
 	workqueue     workqueue.RateLimitingInterface
 }
 
@@ -76,11 +100,66 @@ func runController(cfg *rest.Config) {
 
 	dynamicInformerFactory := dynamicinformer.NewDynamicSharedInformerFactory(dynamicClient, time.Second*30)
 	informer := dynamicInformerFactory.ForResource(rsyncSourceGVR).Informer()
+	if err := informer.AddIndexers(cache.Indexers{
+		claimIndex:     rsyncSourceClaimIndexFunc,
+		tlsSecretIndex: rsyncSourceTLSSecretIndexFunc,
+	}); err != nil {
+		klog.Fatalf("Failed to add rsync source indexers: %v", err)
+	}
+
+	// Pods, volume attachments and the claims of attached volumes are used
+	// to place rsync sources on the node where their claim is in use.
+	informerFactory := informers.NewSharedInformerFactory(kubeClient, time.Second*30)
+	podInformer := informerFactory.Core().V1().Pods().Informer()
+	if err := podInformer.AddIndexers(cache.Indexers{claimIndex: podClaimIndexFunc}); err != nil {
+		klog.Fatalf("Failed to add pod indexer: %v", err)
+	}
+	if err := podInformer.SetTransform(transformPod); err != nil {
+		klog.Fatalf("Failed to set pod transform: %v", err)
+	}
+	vaInformer := informerFactory.Storage().V1().VolumeAttachments().Informer()
+	if err := vaInformer.AddIndexers(cache.Indexers{persistentVolumeIndex: persistentVolumeIndexFunc}); err != nil {
+		klog.Fatalf("Failed to add volume attachment indexer: %v", err)
+	}
+	pvInformer := informerFactory.Core().V1().PersistentVolumes().Informer()
+	if err := pvInformer.SetTransform(transformPersistentVolume); err != nil {
+		klog.Fatalf("Failed to set persistent volume transform: %v", err)
+	}
+	pvcInformer := informerFactory.Core().V1().PersistentVolumeClaims().Informer()
+	if err := pvcInformer.SetTransform(transformPersistentVolumeClaim); err != nil {
+		klog.Fatalf("Failed to set persistent volume claim transform: %v", err)
+	}
+	nodeInformer := informerFactory.Core().V1().Nodes().Informer()
+	if err := nodeInformer.SetTransform(transformNode); err != nil {
+		klog.Fatalf("Failed to set node transform: %v", err)
+	}
+
+	// The metadata of Secrets is watched to sync the sources serving their
+	// certificates. Their data isn't needed to notice changes.
+	metadataClient, err := metadata.NewForConfig(cfg)
+	if nil != err {
+		klog.Fatalf("Failed to create metadata client: %v", err)
+	}
+	metadataInformerFactory := metadatainformer.NewSharedInformerFactory(metadataClient, time.Second*30)
+	secretInformer := metadataInformerFactory.ForResource(corev1.SchemeGroupVersion.WithResource("secrets")).Informer()
 	c := &controller{
+		config:        cfg,
 		kubeClient:    kubeClient,
 		dynamicClient: dynamicClient,
 		vrLister:      dynamiclister.New(informer.GetIndexer(), rsyncSourceGVR),
 		vrSynced:      informer.HasSynced,
+		rsIndexer:     informer.GetIndexer(),
+		podIndexer:    podInformer.GetIndexer(),
+		podSynced:     podInformer.HasSynced,
+		vaIndexer:     vaInformer.GetIndexer(),
+		vaSynced:      vaInformer.HasSynced,
+		pvLister:      informerFactory.Core().V1().PersistentVolumes().Lister(),
+		pvSynced:      pvInformer.HasSynced,
+		pvcLister:     informerFactory.Core().V1().PersistentVolumeClaims().Lister(),
+		pvcSynced:     pvcInformer.HasSynced,
+		nodeLister:    informerFactory.Core().V1().Nodes().Lister(),
+		nodeSynced:    nodeInformer.HasSynced,
+		secretSynced:  secretInformer.HasSynced,
 		workqueue:     workqueue.NewRateLimitingQueue(workqueue.DefaultControllerRateLimiter()),
 	}
 
@@ -92,7 +171,48 @@ func runController(cfg *rest.Config) {
 		DeleteFunc: c.handle,
 	})
 
+	podInformer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: c.handlePod,
+		UpdateFunc: func(oldObj, newObj interface{}) {
+			c.handlePod(newObj)
+		},
+		DeleteFunc: c.handlePod,
+	})
+
+	vaInformer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: c.handleVolumeAttachment,
+		UpdateFunc: func(oldObj, newObj interface{}) {
+			c.handleVolumeAttachment(newObj)
+		},
+		DeleteFunc: c.handleVolumeAttachment,
+	})
+
+	// Binding or deleting a claim changes the volume attachments its
+	// sources are placed by.
+	pvcInformer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: c.handlePersistentVolumeClaim,
+		UpdateFunc: func(oldObj, newObj interface{}) {
+			if oldObj.(*corev1.PersistentVolumeClaim).Spec.VolumeName != newObj.(*corev1.PersistentVolumeClaim).Spec.VolumeName {
+				c.handlePersistentVolumeClaim(newObj)
+			}
+		},
+		DeleteFunc: c.handlePersistentVolumeClaim,
+	})
+
+	secretInformer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: c.handleSecret,
+		UpdateFunc: func(oldObj, newObj interface{}) {
+			// Resyncs don't change the certificate.
+			if oldObj.(metav1.Object).GetResourceVersion() != newObj.(metav1.Object).GetResourceVersion() {
+				c.handleSecret(newObj)
+			}
+		},
+		DeleteFunc: c.handleSecret,
+	})
+
 	dynamicInformerFactory.Start(stopCh)
+	informerFactory.Start(stopCh)
+	metadataInformerFactory.Start(stopCh)
 	if err := c.run(stopCh); nil != err {
 		klog.Fatalf("Failed to run controller: %v", err)
 	}
@@ -110,7 +230,8 @@ func (c *controller) run(stopCh <-chan struct{}) error {
 	defer utilruntime.HandleCrash()
 	defer c.workqueue.ShutDown()
 
-	if ok := cache.WaitForCacheSync(stopCh, c.vrSynced); !ok {
+	if ok := cache.WaitForCacheSync(stopCh, c.vrSynced, c.podSynced, c.vaSynced, c.pvSynced,
+		c.pvcSynced, c.nodeSynced, c.secretSynced); !ok {
 		return fmt.Errorf("failed to wait for caches to sync")
 	}
 
@@ -168,15 +289,109 @@ func (c *controller) syncPopulator(ctx context.Context, key, namespace, name str
 		return fmt.Errorf("error converting rsync source `%s` in `%s` namespace error: %s",
 			unstruct.GetName(), unstruct.GetNamespace(), err)
 	}
+	delete := rsyncSource.DeletionTimestamp != nil
+	if !delete {
+		if err := c.ensureRsyncSourceFinalizer(ctx, true, rsyncSource.DeepCopy()); err != nil {
+			klog.Error(err)
+			return err
+		}
+		// The claim is resolved before a snapshot replaces the volume.
+		claim, err := pvcRefFromRsyncSource(rsyncSource)
+		if err != nil {
+			return c.setRsyncSourceCondition(ctx, rsyncSource, metav1.Condition{
+				Type:    configValidConditionType,
+				Status:  metav1.ConditionFalse,
+				Reason:  "InvalidConfig",
+				Message: err.Error(),
+			})
+		}
+		// Outside of its availability windows the daemon is stopped.
+		available, requeue, err := c.ensureAvailability(ctx, rsyncSource)
+		if err != nil {
+			return fmt.Errorf("error ensuring availability of rsync source `%s` in `%s` namespace error: %s",
+				unstruct.GetName(), unstruct.GetNamespace(), err)
+		}
+		if requeue > 0 {
+			c.workqueue.AddAfter(key, requeue)
+		}
+		// An expired source keeps its resources, only the daemon is stopped.
+		if isExpired(rsyncSource) || !available {
+			replicas := int32(0)
+			rsyncSource.Spec.Replicas = &replicas
+		}
+		if isSnapshotConsistent(rsyncSource) {
+			volume, requeue, err := c.ensureSnapshotVolume(ctx, rsyncSource)
+			if err != nil {
+				return fmt.Errorf("error ensuring snapshot for rsync source `%s` in `%s` namespace error: %s",
+					unstruct.GetName(), unstruct.GetNamespace(), err)
+			}
+			if volume == nil {
+				c.workqueue.AddAfter(key, requeue)
+				return nil
+			}
+			rsyncSource.Spec.Volume = *volume
+		} else if _, ok := rsyncSource.GetAnnotations()[snapshotTimeAnnotation]; ok {
+			// The source was switched back to the live claim.
+			if err := c.deleteSnapshotVolume(ctx, rsyncSource); err != nil {
+				return fmt.Errorf("error deleting snapshot of rsync source `%s` in `%s` namespace error: %s",
+					unstruct.GetName(), unstruct.GetNamespace(), err)
+			}
+			if err := c.setSnapshotStatus(ctx, rsyncSource.GetNamespace(), rsyncSource.GetName(), nil, metav1.Condition{
+				Type:    snapshotReadyConditionType,
+				Status:  metav1.ConditionFalse,
+				Reason:  "LiveClaim",
+				Message: "the live claim is served",
+			}); err != nil {
+				return err
+			}
+		}
+		if err := c.resolveHostName(&rsyncSource, claim); err != nil {
+			return fmt.Errorf("error resolving node of rsync source `%s` in `%s` namespace error: %s",
+				unstruct.GetName(), unstruct.GetNamespace(), err)
+		}
+	}
 	tc, err := templateConfigFromRsyncSource(rsyncSource)
-	if err != nil {
-		return fmt.Errorf("error creating template config, error : %s", err)
+	switch {
+	case err != nil && delete:
+		// An invalid configuration doesn't block the deletion, only the
+		// names of the resources are needed to remove them.
+		tc = releaseTemplateConfig(rsyncSource)
+	case err != nil:
+		// An invalid configuration isn't rolled out. The source is synced
+		// again when its annotations are fixed.
+		return c.setRsyncSourceCondition(ctx, rsyncSource, metav1.Condition{
+			Type:    configValidConditionType,
+			Status:  metav1.ConditionFalse,
+			Reason:  "InvalidConfig",
+			Message: err.Error(),
+		})
+	case !delete:
+		if err := c.setRsyncSourceCondition(ctx, rsyncSource, metav1.Condition{
+			Type:    configValidConditionType,
+			Status:  metav1.ConditionTrue,
+			Reason:  "ConfigValid",
+			Message: "the rsyncd.conf was rendered",
+		}); err != nil {
+			return err
+		}
 	}
 	cmTemplate := tc.getCmTemplate()
+	secretTemplate := tc.getSecretTemplate()
 	deploymentTemplate := tc.getDeploymentTemplate()
 	serviceTemplate := tc.getSvcTemplate()
-	delete := rsyncSource.DeletionTimestamp != nil
+	activatorTemplate := tc.getActivatorDeploymentTemplate()
+	daemonServiceTemplate := tc.getDaemonSvcTemplate()
 	if delete {
+		// Active sessions are cut off once the deployment is deleted.
+		drained, requeue, err := c.drainSessions(ctx, rsyncSource, tc)
+		if err != nil {
+			return fmt.Errorf("error draining sessions of rsync source `%s` in `%s` namespace error: %s",
+				unstruct.GetName(), unstruct.GetNamespace(), err)
+		}
+		if !drained {
+			c.workqueue.AddAfter(key, requeue)
+			return nil
+		}
 		if err := c.ensureConfigMap(ctx, false, rsyncSource.GetNamespace(), cmTemplate.DeepCopy()); err != nil {
 			klog.Info(*cmTemplate)
 			return fmt.Errorf("error ensuring configmap(false) for rsync source `%s` in `%s` namespace error: %s",
@@ -186,33 +401,230 @@ func (c *controller) syncPopulator(ctx context.Context, key, namespace, name str
 			return fmt.Errorf("error ensuring deploymet(false) for rsync source `%s` in `%s` namespace error: %s",
 				unstruct.GetName(), unstruct.GetNamespace(), err)
 		}
+		if err := c.ensureDeployment(ctx, false, rsyncSource.GetNamespace(), activatorTemplate.DeepCopy()); err != nil {
+			return fmt.Errorf("error ensuring activator(false) for rsync source `%s` in `%s` namespace error: %s",
+				unstruct.GetName(), unstruct.GetNamespace(), err)
+		}
+		if _, err := c.ensureSecret(ctx, false, rsyncSource.GetNamespace(), secretTemplate.DeepCopy()); err != nil {
+			return fmt.Errorf("error ensuring secret(false) for rsync source `%s` in `%s` namespace error: %s",
+				unstruct.GetName(), unstruct.GetNamespace(), err)
+		}
+		if err := c.ensureHooks(ctx, rsyncSource, true); err != nil {
+			return fmt.Errorf("error running post hooks of rsync source `%s` in `%s` namespace error: %s",
+				unstruct.GetName(), unstruct.GetNamespace(), err)
+		}
+		if _, err := c.ensureWriteLock(ctx, false, rsyncSource); err != nil {
+			return fmt.Errorf("error releasing write lock of rsync source `%s` in `%s` namespace error: %s",
+				unstruct.GetName(), unstruct.GetNamespace(), err)
+		}
 		if err := c.ensureService(ctx, false, rsyncSource.GetNamespace(), serviceTemplate.DeepCopy()); err != nil {
 			return fmt.Errorf("error ensuring service(false) for rsync source `%s` in `%s` namespace error: %s",
 				unstruct.GetName(), unstruct.GetNamespace(), err)
 		}
+		if err := c.ensureService(ctx, false, rsyncSource.GetNamespace(), daemonServiceTemplate.DeepCopy()); err != nil {
+			return fmt.Errorf("error ensuring daemon service(false) for rsync source `%s` in `%s` namespace error: %s",
+				unstruct.GetName(), unstruct.GetNamespace(), err)
+		}
+		if err := c.deleteSnapshotVolume(ctx, rsyncSource); err != nil {
+			return fmt.Errorf("error deleting snapshot of rsync source `%s` in `%s` namespace error: %s",
+				unstruct.GetName(), unstruct.GetNamespace(), err)
+		}
+		if err := c.deleteTLS(ctx, rsyncSource); err != nil {
+			return fmt.Errorf("error deleting certificate of rsync source `%s` in `%s` namespace error: %s",
+				unstruct.GetName(), unstruct.GetNamespace(), err)
+		}
+		if err := c.deleteSSHHostKey(ctx, rsyncSource); err != nil {
+			return fmt.Errorf("error deleting ssh host key of rsync source `%s` in `%s` namespace error: %s",
+				unstruct.GetName(), unstruct.GetNamespace(), err)
+		}
 		if err := c.ensureRsyncSourceFinalizer(ctx, false, rsyncSource.DeepCopy()); err != nil {
 			klog.Error(err)
 			return err
 		}
 		return nil
 	}
-	if err := c.ensureRsyncSourceFinalizer(ctx, true, rsyncSource.DeepCopy()); err != nil {
-		klog.Error(err)
-		return err
+	// Concurrent writers of the volume are locked out with a lease. The
+	// owner of the claim has to confirm writes.
+	confirmed := false
+	if tc.readWrite {
+		if confirmed, err = c.isWriteConfirmed(ctx, rsyncSource); err != nil {
+			return fmt.Errorf("error getting claim of rsync source `%s` in `%s` namespace error: %s",
+				unstruct.GetName(), unstruct.GetNamespace(), err)
+		}
+	}
+	locked, err := c.ensureWriteLock(ctx, confirmed, rsyncSource)
+	if err != nil {
+		return fmt.Errorf("error ensuring write lock of rsync source `%s` in `%s` namespace error: %s",
+			unstruct.GetName(), unstruct.GetNamespace(), err)
+	}
+	if tc.readWrite {
+		condition := metav1.Condition{
+			Type:    writeLockConditionType,
+			Status:  metav1.ConditionTrue,
+			Reason:  "LeaseAcquired",
+			Message: "the volume is served ReadWrite",
+		}
+		switch {
+		case !confirmed:
+			condition.Status = metav1.ConditionFalse
+			condition.Reason = "WriteNotConfirmed"
+			condition.Message = fmt.Sprintf("the claim has no `%s: \"true\"` annotation", confirmWriteAnnotation)
+		case !locked:
+			condition.Status = metav1.ConditionFalse
+			condition.Reason = "LeaseHeld"
+			condition.Message = "another rsync source writes to the volume"
+		}
+		if err := c.setRsyncSourceStatus(ctx, rsyncSource.GetNamespace(), rsyncSource.GetName(), nil, condition); err != nil {
+			return err
+		}
+		if !locked {
+			// A daemon that lost the lease stops writing.
+			if err := c.ensureDeployment(ctx, false, rsyncSource.GetNamespace(), deploymentTemplate.DeepCopy()); err != nil {
+				return fmt.Errorf("error ensuring deploymet(false) for rsync source `%s` in `%s` namespace error: %s",
+					unstruct.GetName(), unstruct.GetNamespace(), err)
+			}
+			c.workqueue.AddAfter(key, writeLockPollInterval)
+			return nil
+		}
+		c.workqueue.AddAfter(key, writeLockRenewInterval)
+	}
+	// The proxy can't start before its certificate is available.
+	if tc.tls != nil {
+		ready, requeue, err := c.ensureTLS(ctx, rsyncSource, tc.tls)
+		if err != nil {
+			return fmt.Errorf("error ensuring certificate of rsync source `%s` in `%s` namespace error: %s",
+				unstruct.GetName(), unstruct.GetNamespace(), err)
+		}
+		if requeue > 0 {
+			c.workqueue.AddAfter(key, requeue)
+		}
+		if !ready {
+			return nil
+		}
+	} else if isTLSEnabled(rsyncSource) {
+		if err := c.deleteTLS(ctx, rsyncSource); err != nil {
+			return fmt.Errorf("error deleting certificate of rsync source `%s` in `%s` namespace error: %s",
+				unstruct.GetName(), unstruct.GetNamespace(), err)
+		}
+		if err := c.setTLSStatus(ctx, rsyncSource, nil, metav1.ConditionFalse, "Disabled", "TLS is disabled"); err != nil {
+			return err
+		}
+	}
+	if tc.ssh != nil {
+		if err := c.ensureSSHHostKey(ctx, rsyncSource); err != nil {
+			return fmt.Errorf("error ensuring ssh host key of rsync source `%s` in `%s` namespace error: %s",
+				unstruct.GetName(), unstruct.GetNamespace(), err)
+		}
+	} else if _, ok := rsyncSource.GetAnnotations()[sshKnownHostsAnnotation]; ok {
+		// The source was switched back to the rsync transport.
+		if err := c.deleteSSHHostKey(ctx, rsyncSource); err != nil {
+			return fmt.Errorf("error deleting ssh host key of rsync source `%s` in `%s` namespace error: %s",
+				unstruct.GetName(), unstruct.GetNamespace(), err)
+		}
+		if err := c.setRsyncSourceStatus(ctx, rsyncSource.GetNamespace(), rsyncSource.GetName(), map[string]*string{
+			sshKnownHostsAnnotation: nil,
+		}); err != nil {
+			return err
+		}
+	}
+	// The servers read the credentials when they start, they are restarted
+	// when the Secret changes.
+	credentialsVersion, err := c.ensureSecret(ctx, true, rsyncSource.GetNamespace(), secretTemplate.DeepCopy())
+	if err != nil {
+		return fmt.Errorf("error ensuring secret(true) for rsync source `%s` in `%s` namespace error: %s",
+			unstruct.GetName(), unstruct.GetNamespace(), err)
+	}
+	deploymentTemplate.Spec.Template.Annotations[credentialsVersionAnnotation] = credentialsVersion
+	// A deployment that is recreated with the new configuration waits for
+	// the active sessions of the old one.
+	outdated, err := c.isDeploymentOutdated(ctx, rsyncSource.GetNamespace(), deploymentTemplate)
+	if err != nil {
+		return fmt.Errorf("error getting deployment of rsync source `%s` in `%s` namespace error: %s",
+			unstruct.GetName(), unstruct.GetNamespace(), err)
+	}
+	if outdated {
+		drained, requeue, err := c.drainSessions(ctx, rsyncSource, tc)
+		if err != nil {
+			return fmt.Errorf("error draining sessions of rsync source `%s` in `%s` namespace error: %s",
+				unstruct.GetName(), unstruct.GetNamespace(), err)
+		}
+		if !drained {
+			c.workqueue.AddAfter(key, requeue)
+			return nil
+		}
 	}
 	if err := c.ensureConfigMap(ctx, true, rsyncSource.GetNamespace(), cmTemplate.DeepCopy()); err != nil {
 		klog.Info(*cmTemplate)
 		return fmt.Errorf("error ensuring configmap(true) for rsync source `%s` in `%s` namespace error: %s",
 			unstruct.GetName(), unstruct.GetNamespace(), err)
 	}
+	// A source scaled to zero releases the application like a deleted one.
+	released := rsyncSource.Spec.Replicas != nil && *rsyncSource.Spec.Replicas == 0
+	if !released {
+		if err := c.ensureHooks(ctx, rsyncSource, false); err != nil {
+			return fmt.Errorf("error running pre hooks of rsync source `%s` in `%s` namespace error: %s",
+				unstruct.GetName(), unstruct.GetNamespace(), err)
+		}
+	}
 	if err := c.ensureDeployment(ctx, true, rsyncSource.GetNamespace(), deploymentTemplate.DeepCopy()); err != nil {
 		return fmt.Errorf("error ensuring pod(true) for rsync source `%s` in `%s` namespace error: %s",
 			unstruct.GetName(), unstruct.GetNamespace(), err)
 	}
+	if released {
+		if err := c.ensureHooks(ctx, rsyncSource, true); err != nil {
+			return fmt.Errorf("error running post hooks of rsync source `%s` in `%s` namespace error: %s",
+				unstruct.GetName(), unstruct.GetNamespace(), err)
+		}
+	}
+	// The activator owns the Service while the daemon isn't ready and
+	// forwards to the daemon Service once it is.
+	want := tc.activator != nil
+	if err := c.ensureDeployment(ctx, want, rsyncSource.GetNamespace(), activatorTemplate.DeepCopy()); err != nil {
+		return fmt.Errorf("error ensuring activator(%t) for rsync source `%s` in `%s` namespace error: %s",
+			want, unstruct.GetName(), unstruct.GetNamespace(), err)
+	}
+	if err := c.ensureService(ctx, want, rsyncSource.GetNamespace(), daemonServiceTemplate.DeepCopy()); err != nil {
+		return fmt.Errorf("error ensuring daemon service(%t) for rsync source `%s` in `%s` namespace error: %s",
+			want, unstruct.GetName(), unstruct.GetNamespace(), err)
+	}
+	if want {
+		ready, err := c.isDaemonReady(ctx, rsyncSource.GetNamespace(), rsyncSource.GetName())
+		if err != nil {
+			return fmt.Errorf("error getting deployment of rsync source `%s` in `%s` namespace error: %s",
+				unstruct.GetName(), unstruct.GetNamespace(), err)
+		}
+		if !ready {
+			serviceTemplate.Spec.Selector = tc.activatorSelector()
+			if !released {
+				c.workqueue.AddAfter(key, activatorPollInterval)
+			}
+		}
+	}
 	if err := c.ensureService(ctx, true, rsyncSource.GetNamespace(), serviceTemplate.DeepCopy()); err != nil {
 		return fmt.Errorf("error ensuring service(true) for rsync source `%s` in `%s` namespace error: %s",
 			unstruct.GetName(), unstruct.GetNamespace(), err)
 	}
+	// The sessions are polled from the log shippers of the daemon pods.
+	lastActivity := time.Time{}
+	if tc.logShipper {
+		if lastActivity, err = c.ensureSessionStatus(ctx, rsyncSource); err != nil {
+			return fmt.Errorf("error ensuring sessions of rsync source `%s` in `%s` namespace error: %s",
+				unstruct.GetName(), unstruct.GetNamespace(), err)
+		}
+		c.workqueue.AddAfter(key, sessionPollInterval)
+	} else if _, ok := rsyncSource.GetAnnotations()[recentSessionsAnnotation]; ok {
+		if err := c.deleteSessionStatus(ctx, rsyncSource); err != nil {
+			return err
+		}
+	}
+	requeue, err := c.ensureLifecycle(ctx, rsyncSource, tc, lastActivity)
+	if err != nil {
+		return fmt.Errorf("error ensuring lifecycle of rsync source `%s` in `%s` namespace error: %s",
+			unstruct.GetName(), unstruct.GetNamespace(), err)
+	}
+	if requeue > 0 {
+		c.workqueue.AddAfter(key, requeue)
+	}
 	return nil
 }
 
@@ -262,7 +674,7 @@ func (c *controller) ensureDeployment(ctx context.Context, want bool, namespace
 
 /*
 if found and not created by the populator then return error
-if want and found return nil
+if want and found -> update ports and selector if changed return error/nil
 if !want and !found return nil
 if want and !found -> create return error/nil
 if !want and found -> delete return error/nil
@@ -282,6 +694,18 @@ func (c *controller) ensureService(ctx context.Context, want bool, namespace str
 	if found && (obj.GetLabels() == nil || obj.GetLabels()[constant.CreatedByLabel] != constant.ComponentNameRsyncSourceController) {
 		return fmt.Errorf("resource found but not created by this operator")
 	}
+	if want && found {
+		if reflect.DeepEqual(obj.Spec.Ports, svcClone.Spec.Ports) &&
+			reflect.DeepEqual(obj.Spec.Selector, svcClone.Spec.Selector) {
+			return nil
+		}
+		objClone := obj.DeepCopy()
+		objClone.Spec.Ports = svcClone.Spec.Ports
+		objClone.Spec.Selector = svcClone.Spec.Selector
+		_, err := c.kubeClient.CoreV1().Services(namespace).
+			Update(ctx, objClone, metav1.UpdateOptions{})
+		return err
+	}
 	if want == found {
 		return nil
 	}
@@ -300,7 +724,7 @@ func (c *controller) ensureService(ctx context.Context, want bool, namespace str
 
 /*
 if found and not created by the populator then return error
-if want and found return nil
+if want and found -> update data if changed return resourceVersion/error
 if !want and !found return nil
 if want and !found -> create return error/nil
 if !want and found -> delete return error/nil
@@ -320,6 +744,16 @@ func (c *controller) ensureConfigMap(ctx context.Context, want bool, namespace s
 	if found && (obj.GetLabels() == nil || obj.GetLabels()[constant.CreatedByLabel] != constant.ComponentNameRsyncSourceController) {
 		return fmt.Errorf("resource found but not created by this operator")
 	}
+	if want && found {
+		if reflect.DeepEqual(obj.Data, cmClone.Data) {
+			return nil
+		}
+		objClone := obj.DeepCopy()
+		objClone.Data = cmClone.Data
+		_, err := c.kubeClient.CoreV1().ConfigMaps(namespace).
+			Update(ctx, objClone, metav1.UpdateOptions{})
+		return err
+	}
 	if want == found {
 		return nil
 	}
@@ -336,6 +770,60 @@ func (c *controller) ensureConfigMap(ctx context.Context, want bool, namespace s
 	return nil
 }
 
+/*
+if found and not created by the populator then return error
+if want and found -> update data if changed return error/nil
+if !want and !found return nil
+if want and !found -> create return resourceVersion/error
+if !want and found -> delete return error/nil
+*/
+func (c *controller) ensureSecret(ctx context.Context, want bool, namespace string, secret *corev1.Secret) (string, error) {
+	secretClone := secret.DeepCopy()
+	found := true
+	obj, err := c.kubeClient.CoreV1().Secrets(namespace).
+		Get(ctx, secretClone.Name, metav1.GetOptions{})
+	if err != nil {
+		if errors.IsNotFound(err) {
+			found = false
+		} else {
+			return "", err
+		}
+	}
+	if found && (obj.GetLabels() == nil || obj.GetLabels()[constant.CreatedByLabel] != constant.ComponentNameRsyncSourceController) {
+		return "", fmt.Errorf("resource found but not created by this operator")
+	}
+	if want && found {
+		if reflect.DeepEqual(obj.Data, secretClone.Data) {
+			return obj.GetResourceVersion(), nil
+		}
+		objClone := obj.DeepCopy()
+		objClone.Data = secretClone.Data
+		updated, err := c.kubeClient.CoreV1().Secrets(namespace).
+			Update(ctx, objClone, metav1.UpdateOptions{})
+		if err != nil {
+			return "", err
+		}
+		return updated.GetResourceVersion(), nil
+	}
+	if want == found {
+		return "", nil
+	}
+	if want && !found {
+		created, err := c.kubeClient.CoreV1().Secrets(namespace).
+			Create(ctx, secretClone, metav1.CreateOptions{})
+		if err != nil {
+			return "", err
+		}
+		return created.GetResourceVersion(), nil
+	}
+	if !want && found {
+		err := c.kubeClient.CoreV1().Secrets(namespace).
+			Delete(ctx, secretClone.Name, metav1.DeleteOptions{})
+		return "", err
+	}
+	return "", nil
+}
+
 // updateVolumeCopy updates a volume copy object
 func (c *controller) updateRsyncSource(ctx context.Context, cr *internalv1.RsyncSource) error {
 	clone := cr.DeepCopy()
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	internalv1 "github.com/k8s-volume-copy/types/apis/demo.io/v1"
+)
+
+const (
+	// drainGracePeriodAnnotation sets how long the deletion of a rsync
+	// source or the recreation of its deployment waits for active sessions
+	// to finish, as Go duration. Defaults to 10 minutes, `0s` disables
+	// draining. Sessions are tracked by the log shipper. The sessions of
+	// pods without a reachable log shipper are unknown, they are drained
+	// until the grace period ends.
+	drainGracePeriodAnnotation = "demo.io/drain-grace-period"
+	// skipDrainAnnotation set to `true` cuts off active sessions right away.
+	skipDrainAnnotation = "demo.io/skip-drain"
+	// drainStartAnnotation reports since when the source is draining.
+	drainStartAnnotation = "demo.io/drain-start"
+
+	// drainingConditionType reports whether the deletion or update of a rsync
+	// source waits for active sessions.
+	drainingConditionType = "Draining"
+
+	defaultDrainGracePeriod = 10 * time.Minute
+	drainPollInterval       = 10 * time.Second
+)
+
+func drainGracePeriodFromAnnotations(annotations map[string]string) (time.Duration, error) {
+	raw, ok := annotations[drainGracePeriodAnnotation]
+	if !ok {
+		return defaultDrainGracePeriod, nil
+	}
+	grace, err := time.ParseDuration(raw)
+	if err != nil || grace < 0 {
+		return 0, fmt.Errorf("invalid `%s` annotation `%s`, expected a duration like `15m`",
+			drainGracePeriodAnnotation, raw)
+	}
+	return grace, nil
+}
+
+// isDeploymentOutdated returns whether the deployment of a rsync source has
+// to be recreated by ensureDeployment.
+func (c *controller) isDeploymentOutdated(ctx context.Context, namespace string, deployment *appsv1.Deployment) (bool, error) {
+	obj, err := c.kubeClient.AppsV1().Deployments(namespace).
+		Get(ctx, deployment.Name, metav1.GetOptions{})
+	if err != nil {
+		if errors.IsNotFound(err) {
+			return false, nil
+		}
+		return false, err
+	}
+	return isDeleteRequired(*obj, *deployment), nil
+}
+
+// drainSessions returns whether a rsync source has no active sessions left,
+// or may cut them off as its grace period expired or draining is skipped.
+// Otherwise the source is marked as draining and requeue is set.
+func (c *controller) drainSessions(ctx context.Context, cr internalv1.RsyncSource, tc *templateConfig) (bool, time.Duration, error) {
+	done := func(reason, message string) (bool, time.Duration, error) {
+		return true, 0, c.setRsyncSourceStatus(ctx, cr.GetNamespace(), cr.GetName(), map[string]*string{
+			drainStartAnnotation: nil,
+		}, metav1.Condition{
+			Type:    drainingConditionType,
+			Status:  metav1.ConditionFalse,
+			Reason:  reason,
+			Message: message,
+		})
+	}
+	if cr.GetAnnotations()[skipDrainAnnotation] == "true" {
+		return done("Skipped", fmt.Sprintf("draining is skipped with `%s`", skipDrainAnnotation))
+	}
+	if tc.drainGrace == 0 {
+		return true, 0, nil
+	}
+	sessions, err := c.getSessions(ctx, cr, tc.logShipper)
+	if err != nil {
+		return false, 0, err
+	}
+	if len(sessions.Active) == 0 && sessions.unknown == 0 {
+		return done("Drained", "no active sessions")
+	}
+	start := time.Now()
+	if raw, ok := cr.GetAnnotations()[drainStartAnnotation]; ok {
+		if parsed, err := time.Parse(time.RFC3339, raw); err == nil {
+			start = parsed
+		}
+	}
+	deadline := start.Add(tc.drainGrace)
+	if !time.Now().Before(deadline) {
+		return done("GracePeriodExpired",
+			fmt.Sprintf("%d active sessions and the sessions of %d pods were cut off after %s",
+				len(sessions.Active), sessions.unknown, tc.drainGrace))
+	}
+	rawStart := start.UTC().Format(time.RFC3339)
+	if err := c.setRsyncSourceStatus(ctx, cr.GetNamespace(), cr.GetName(), map[string]*string{
+		drainStartAnnotation: &rawStart,
+	}, metav1.Condition{
+		Type:   drainingConditionType,
+		Status: metav1.ConditionTrue,
+		Reason: "ActiveSessions",
+		Message: fmt.Sprintf("waiting for %d active sessions and %d pods with unknown sessions until %s",
+			len(sessions.Active), sessions.unknown, deadline.UTC().Format(time.RFC3339)),
+	}); err != nil {
+		return false, 0, err
+	}
+	requeue := drainPollInterval
+	if remaining := time.Until(deadline); remaining < requeue {
+		requeue = remaining
+	}
+	return false, requeue, nil
+}
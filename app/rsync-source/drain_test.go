@@ -0,0 +1,41 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestDrainGracePeriodFromAnnotations(t *testing.T) {
+	tests := []struct {
+		name    string
+		value   *string
+		want    time.Duration
+		wantErr bool
+	}{
+		{name: "unset", want: defaultDrainGracePeriod},
+		{name: "minutes", value: stringPtr("30m"), want: 30 * time.Minute},
+		{name: "disabled", value: stringPtr("0s"), want: 0},
+		{name: "empty", value: stringPtr(""), wantErr: true},
+		{name: "negative", value: stringPtr("-1m"), wantErr: true},
+		{name: "without unit", value: stringPtr("30"), wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			annotations := map[string]string{}
+			if tt.value != nil {
+				annotations[drainGracePeriodAnnotation] = *tt.value
+			}
+			got, err := drainGracePeriodFromAnnotations(annotations)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("drainGracePeriodFromAnnotations() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("drainGracePeriodFromAnnotations() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func stringPtr(s string) *string {
+	return &s
+}
@@ -1,6 +1,8 @@
 package main
 
 import (
+	"reflect"
+
 	appsv1 "k8s.io/api/apps/v1"
 )
 
@@ -10,12 +12,25 @@ func isDeleteRequired(old, new appsv1.Deployment) bool {
 			return true
 		}
 	}
-	if len(old.Spec.Template.Spec.Containers) == 1 && len(new.Spec.Template.Spec.Containers) == 1 {
-		if old.Spec.Template.Spec.Containers[0].Image != new.Spec.Template.Spec.Containers[0].Image {
+	for _, key := range []string{configHashAnnotation, credentialsVersionAnnotation} {
+		if old.Spec.Template.GetAnnotations()[key] != new.Spec.Template.GetAnnotations()[key] {
 			return true
 		}
-	} else {
+	}
+	// The node selector changes when an automatically placed source follows
+	// its claim to another node.
+	if !reflect.DeepEqual(old.Spec.Template.Spec.NodeSelector, new.Spec.Template.Spec.NodeSelector) &&
+		(len(old.Spec.Template.Spec.NodeSelector) != 0 || len(new.Spec.Template.Spec.NodeSelector) != 0) {
+		return true
+	}
+	// The daemon may be followed by the proxy sidecar.
+	if len(old.Spec.Template.Spec.Containers) != len(new.Spec.Template.Spec.Containers) {
 		return true
 	}
+	for i := range old.Spec.Template.Spec.Containers {
+		if old.Spec.Template.Spec.Containers[i].Image != new.Spec.Template.Spec.Containers[i].Image {
+			return true
+		}
+	}
 	return false
 }
@@ -0,0 +1,327 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/httpstream"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/remotecommand"
+	"k8s.io/client-go/transport/spdy"
+	utilexec "k8s.io/client-go/util/exec"
+	"k8s.io/klog/v2"
+
+	internalv1 "github.com/k8s-volume-copy/types/apis/demo.io/v1"
+	"github.com/k8s-volume-copy/volume-source/pkg/conditions"
+)
+
+const (
+	// hooksAnnotation holds the hooks of a rsync source as JSON. Pre hooks
+	// run before the daemon is started, post hooks after it was stopped
+	// because the source was deleted or scaled to zero.
+	hooksAnnotation = "demo.io/hooks"
+	// hookResultsAnnotation holds the results of the last hook runs as a
+	// JSON list of hookResult.
+	hookResultsAnnotation = "demo.io/hook-results"
+	// hooksNamespaceLabel set to `Enabled` on a namespace allows the hooks
+	// of its rsync sources. Hooks exec into the pods of the namespace with
+	// the identity of the controller, so only who may label namespaces can
+	// allow them. The controller also needs the `rsync-source-hooks` role
+	// in the namespace.
+	hooksNamespaceLabel = "demo.io/hooks"
+	hooksEnabled        = "Enabled"
+	// hooksConditionType is True while the pre hooks ran and the post hooks
+	// didn't run yet.
+	hooksConditionType = "PreHooksCompleted"
+
+	hookStagePre  = "pre"
+	hookStagePost = "post"
+
+	hookOnErrorFail     = "Fail"
+	hookOnErrorContinue = "Continue"
+
+	defaultHookTimeoutSeconds = 30
+	// hookOutputLimit is the number of trailing bytes of the output of a
+	// hook that are recorded.
+	hookOutputLimit = 512
+)
+
+// hook is a command that is exec'd into the pods selected by PodSelector.
+type hook struct {
+	Name string `json:"name"`
+	// PodSelector selects the pods in the namespace of the rsync source.
+	PodSelector metav1.LabelSelector `json:"podSelector"`
+	// Container defaults to the first container of the pod.
+	Container string   `json:"container,omitempty"`
+	Command   []string `json:"command"`
+	// TimeoutSeconds defaults to 30.
+	TimeoutSeconds int32 `json:"timeoutSeconds,omitempty"`
+	// OnError is Fail or Continue. Defaults to Fail, the daemon isn't
+	// started and the hooks are retried.
+	OnError string `json:"onError,omitempty"`
+}
+
+type hooks struct {
+	Pre  []hook `json:"pre,omitempty"`
+	Post []hook `json:"post,omitempty"`
+}
+
+// hookResult is the result of a hook in one pod.
+type hookResult struct {
+	Stage          string      `json:"stage"`
+	Hook           string      `json:"hook"`
+	Pod            string      `json:"pod"`
+	Container      string      `json:"container"`
+	Succeeded      bool        `json:"succeeded"`
+	ExitCode       *int        `json:"exitCode,omitempty"`
+	Output         string      `json:"output,omitempty"`
+	Error          string      `json:"error,omitempty"`
+	CompletionTime metav1.Time `json:"completionTime"`
+}
+
+// hooksFromAnnotations returns the validated hooks of a rsync source.
+func hooksFromAnnotations(annotations map[string]string) (*hooks, error) {
+	raw, ok := annotations[hooksAnnotation]
+	if !ok || raw == "" {
+		return nil, nil
+	}
+	h := &hooks{}
+	if err := json.Unmarshal([]byte(raw), h); err != nil {
+		return nil, fmt.Errorf("invalid `%s` annotation, error: %s", hooksAnnotation, err)
+	}
+	for _, list := range [][]hook{h.Pre, h.Post} {
+		for i := range list {
+			if list[i].Name == "" || len(list[i].Command) == 0 {
+				return nil, fmt.Errorf("invalid `%s` annotation, hooks need a name and a command", hooksAnnotation)
+			}
+			if list[i].OnError == "" {
+				list[i].OnError = hookOnErrorFail
+			}
+			if list[i].OnError != hookOnErrorFail && list[i].OnError != hookOnErrorContinue {
+				return nil, fmt.Errorf("invalid `%s` annotation, onError of hook `%s` must be `%s` or `%s`",
+					hooksAnnotation, list[i].Name, hookOnErrorFail, hookOnErrorContinue)
+			}
+			if list[i].TimeoutSeconds <= 0 {
+				list[i].TimeoutSeconds = defaultHookTimeoutSeconds
+			}
+		}
+	}
+	return h, nil
+}
+
+// ensureHooks runs the pre hooks of a rsync source before its daemon is
+// started and the post hooks once it was released. The PreHooksCompleted
+// condition makes sure each stage runs once.
+func (c *controller) ensureHooks(ctx context.Context, cr internalv1.RsyncSource, release bool) error {
+	h, err := hooksFromAnnotations(cr.GetAnnotations())
+	if err != nil || h == nil {
+		return err
+	}
+	conds, err := conditions.FromAnnotations(cr.GetAnnotations())
+	if err != nil {
+		return err
+	}
+	completed := meta.IsStatusConditionTrue(conds, hooksConditionType)
+	if completed != release {
+		return nil
+	}
+	stage, list := hookStagePre, h.Pre
+	if release {
+		stage, list = hookStagePost, h.Post
+	}
+	allowed, err := c.hooksAllowed(ctx, cr.GetNamespace())
+	if err != nil {
+		return err
+	}
+	if !allowed {
+		condition := metav1.Condition{
+			Type:   hooksConditionType,
+			Status: metav1.ConditionFalse,
+			Reason: "HooksNotAllowed",
+			Message: fmt.Sprintf("hooks are not allowed in namespace `%s` without the `%s: %s` label",
+				cr.GetNamespace(), hooksNamespaceLabel, hooksEnabled),
+		}
+		if err := c.setRsyncSourceStatus(ctx, cr.GetNamespace(), cr.GetName(), nil, condition); err != nil {
+			return err
+		}
+		// A released source isn't kept from being deleted.
+		if release {
+			return nil
+		}
+		return fmt.Errorf("%s", condition.Message)
+	}
+	results, runErr := c.runHooks(ctx, cr.GetNamespace(), stage, list)
+	raw, err := json.Marshal(results)
+	if err != nil {
+		return err
+	}
+	value := string(raw)
+	condition := metav1.Condition{
+		Type:    hooksConditionType,
+		Status:  metav1.ConditionTrue,
+		Reason:  "PreHooksSucceeded",
+		Message: fmt.Sprintf("%d pre hook runs completed", len(results)),
+	}
+	switch {
+	case runErr != nil:
+		condition.Status = metav1.ConditionFalse
+		condition.Reason = "HookFailed"
+		condition.Message = runErr.Error()
+		if release {
+			// The post hooks are retried, the pre hooks stay completed.
+			condition.Status = metav1.ConditionTrue
+		}
+	case release:
+		condition.Status = metav1.ConditionFalse
+		condition.Reason = "PostHooksSucceeded"
+		condition.Message = fmt.Sprintf("%d post hook runs completed", len(results))
+	}
+	if err := c.setRsyncSourceStatus(ctx, cr.GetNamespace(), cr.GetName(), map[string]*string{
+		hookResultsAnnotation: &value,
+	}, condition); err != nil {
+		return err
+	}
+	return runErr
+}
+
+// hooksAllowed returns whether the namespace opted into hooks.
+func (c *controller) hooksAllowed(ctx context.Context, namespace string) (bool, error) {
+	ns, err := c.kubeClient.CoreV1().Namespaces().Get(ctx, namespace, metav1.GetOptions{})
+	if err != nil {
+		return false, err
+	}
+	return ns.GetLabels()[hooksNamespaceLabel] == hooksEnabled, nil
+}
+
+// runHooks execs the hooks of a stage into the running pods they select. It
+// stops at the first failed hook with the Fail policy.
+func (c *controller) runHooks(ctx context.Context, namespace, stage string, list []hook) ([]hookResult, error) {
+	results := []hookResult{}
+	for _, h := range list {
+		selector, err := metav1.LabelSelectorAsSelector(&h.PodSelector)
+		if err != nil {
+			return results, fmt.Errorf("invalid pod selector of hook `%s`, error: %s", h.Name, err)
+		}
+		pods, err := c.kubeClient.CoreV1().Pods(namespace).List(ctx, metav1.ListOptions{
+			LabelSelector: selector.String(),
+		})
+		if err != nil {
+			return results, err
+		}
+		for _, pod := range pods.Items {
+			if pod.Status.Phase != corev1.PodRunning || len(pod.Spec.Containers) == 0 {
+				continue
+			}
+			container := h.Container
+			if container == "" {
+				container = pod.Spec.Containers[0].Name
+			}
+			result := c.execHook(ctx, namespace, pod.GetName(), container, h)
+			result.Stage = stage
+			results = append(results, result)
+			klog.Infof("Ran %s hook `%s` in pod `%s` in `%s` namespace, succeeded: %t",
+				stage, h.Name, pod.GetName(), namespace, result.Succeeded)
+			if !result.Succeeded && h.OnError == hookOnErrorFail {
+				return results, fmt.Errorf("%s hook `%s` failed in pod `%s`: %s",
+					stage, h.Name, pod.GetName(), result.Error)
+			}
+		}
+	}
+	return results, nil
+}
+
+// cancelableUpgrader closes the connection of an exec stream once its
+// context is done. The executor of this client version takes no context.
+type cancelableUpgrader struct {
+	spdy.Upgrader
+	ctx context.Context
+}
+
+func (u *cancelableUpgrader) NewConnection(resp *http.Response) (httpstream.Connection, error) {
+	conn, err := u.Upgrader.NewConnection(resp)
+	if err != nil {
+		return nil, err
+	}
+	go func() {
+		select {
+		case <-u.ctx.Done():
+			conn.Close()
+		case <-conn.CloseChan():
+		}
+	}()
+	return conn, nil
+}
+
+// execHook runs the command of a hook in a container through the pods/exec
+// API. The stream is closed when the hook times out.
+func (c *controller) execHook(ctx context.Context, namespace, pod, container string, h hook) hookResult {
+	result := hookResult{
+		Hook:      h.Name,
+		Pod:       pod,
+		Container: container,
+	}
+	req := c.kubeClient.CoreV1().RESTClient().Post().
+		Resource("pods").Namespace(namespace).Name(pod).SubResource("exec").
+		VersionedParams(&corev1.PodExecOptions{
+			Container: container,
+			Command:   h.Command,
+			Stdout:    true,
+			Stderr:    true,
+		}, scheme.ParameterCodec)
+	ctx, cancel := context.WithTimeout(ctx, time.Duration(h.TimeoutSeconds)*time.Second)
+	defer cancel()
+	transport, upgrader, err := spdy.RoundTripperFor(c.config)
+	if err != nil {
+		result.Error = err.Error()
+		result.CompletionTime = metav1.Now()
+		return result
+	}
+	executor, err := remotecommand.NewSPDYExecutorForTransports(transport,
+		&cancelableUpgrader{Upgrader: upgrader, ctx: ctx}, "POST", req.URL())
+	if err != nil {
+		result.Error = err.Error()
+		result.CompletionTime = metav1.Now()
+		return result
+	}
+	output := &bytes.Buffer{}
+	done := make(chan error, 1)
+	go func() {
+		done <- executor.Stream(remotecommand.StreamOptions{
+			Stdout: output,
+			Stderr: output,
+		})
+	}()
+	select {
+	case err = <-done:
+		result.Output = tail(output.String(), hookOutputLimit)
+	case <-ctx.Done():
+		err = fmt.Errorf("timed out after %ds", h.TimeoutSeconds)
+	}
+	result.CompletionTime = metav1.Now()
+	if err != nil {
+		result.Error = err.Error()
+		if exitErr, ok := err.(utilexec.ExitError); ok {
+			code := exitErr.ExitStatus()
+			result.ExitCode = &code
+		}
+		return result
+	}
+	code := 0
+	result.ExitCode = &code
+	result.Succeeded = true
+	return result
+}
+
+func tail(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	return s[len(s)-n:]
+}
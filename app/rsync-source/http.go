@@ -0,0 +1,69 @@
+package main
+
+import (
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+)
+
+const (
+	// fileServerPort is unprivileged, so the file server runs as any user.
+	fileServerPort = 8080
+	httpPort       = 80
+)
+
+// httpBackend serves the volumes below serveRoot with the file server of
+// this repo: files with Range support and directories as streamed tar.
+type httpBackend struct {
+	tc *templateConfig
+}
+
+func (b *httpBackend) configFiles() (map[string]string, error) {
+	return map[string]string{}, nil
+}
+
+func (b *httpBackend) containers() []corev1.Container {
+	tc := b.tc
+	return []corev1.Container{
+		{
+			Name:            "file-server",
+			Image:           fileServerImage,
+			ImagePullPolicy: corev1.PullAlways,
+			Command:         []string{"file-server", "--listen=:8080", "--root=" + serveRoot},
+			SecurityContext: tc.security.containerSecurityContext(),
+			Env: []corev1.EnvVar{
+				tc.credentialEnv("FILE_SERVER_USER", credentialsUsernameKey),
+				tc.credentialEnv("FILE_SERVER_PASSWORD", credentialsPasswordKey),
+			},
+			Ports: []corev1.ContainerPort{
+				{
+					Name:          "http",
+					ContainerPort: fileServerPort,
+				},
+			},
+			ReadinessProbe: &corev1.Probe{
+				ProbeHandler: corev1.ProbeHandler{
+					HTTPGet: &corev1.HTTPGetAction{
+						Path: "/healthz",
+						Port: intstr.FromString("http"),
+					},
+				},
+			},
+			VolumeMounts: tc.servedMounts(serveRoot),
+		},
+	}
+}
+
+func (b *httpBackend) volumes() []corev1.Volume {
+	return nil
+}
+
+func (b *httpBackend) servicePorts() []corev1.ServicePort {
+	return []corev1.ServicePort{
+		{
+			Name:       "http",
+			Port:       httpPort,
+			TargetPort: intstr.FromString("http"),
+			Protocol:   corev1.ProtocolTCP,
+		},
+	}
+}
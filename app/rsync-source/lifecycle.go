@@ -0,0 +1,161 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	internalv1 "github.com/k8s-volume-copy/types/apis/demo.io/v1"
+)
+
+const (
+	// ttlAnnotation expires a rsync source the given number of seconds
+	// after it was created. A source scaled to zero after its TTL expires
+	// again right away once the expired annotation is removed, use the
+	// idle timeout for sources that are woken up again.
+	ttlAnnotation = "demo.io/ttl-seconds-after-creation"
+	// idleTimeoutAnnotation expires a rsync source once no session was seen
+	// for the given Go duration. Sessions are tracked by the log shipper, a
+	// source isn't idle while the sessions of one of its pods are unknown.
+	idleTimeoutAnnotation = "demo.io/idle-timeout"
+	// expiryActionAnnotation selects what happens to an expired source.
+	// `Delete`, the default, deletes it. `ScaleToZero` sets the expired
+	// annotation, which scales the daemon to zero until it is removed.
+	expiryActionAnnotation = "demo.io/expiry-action"
+	expiryActionDelete     = "Delete"
+	expiryActionScale      = "ScaleToZero"
+	// expiredAnnotation reports when a source scaled to zero on expiry.
+	expiredAnnotation = "demo.io/expired"
+
+	// expiredConditionType reports whether the TTL or the idle timeout of a
+	// rsync source passed.
+	expiredConditionType = "Expired"
+)
+
+// lifecycleConfig is the TTL and idle timeout of a source, zero if unset.
+type lifecycleConfig struct {
+	ttl         time.Duration
+	idleTimeout time.Duration
+	action      string
+}
+
+func lifecycleConfigFromAnnotations(annotations map[string]string, logShipper bool) (lifecycleConfig, error) {
+	lc := lifecycleConfig{
+		action: expiryActionDelete,
+	}
+	if raw, ok := annotations[ttlAnnotation]; ok {
+		seconds, err := strconv.ParseInt(raw, 10, 64)
+		if err != nil || seconds <= 0 {
+			return lc, fmt.Errorf("invalid `%s` annotation `%s`, expected a positive number of seconds",
+				ttlAnnotation, raw)
+		}
+		lc.ttl = time.Duration(seconds) * time.Second
+	}
+	if raw, ok := annotations[idleTimeoutAnnotation]; ok {
+		timeout, err := time.ParseDuration(raw)
+		if err != nil || timeout <= 0 {
+			return lc, fmt.Errorf("invalid `%s` annotation `%s`, expected a duration like `30m`",
+				idleTimeoutAnnotation, raw)
+		}
+		if !logShipper {
+			return lc, fmt.Errorf("`%s` requires `%s: %s`", idleTimeoutAnnotation, transferLogAnnotation, transferLogEnabled)
+		}
+		lc.idleTimeout = timeout
+	}
+	switch action := annotations[expiryActionAnnotation]; action {
+	case "", expiryActionDelete:
+	case expiryActionScale:
+		lc.action = expiryActionScale
+	default:
+		return lc, fmt.Errorf("invalid `%s` annotation `%s`, expected `%s` or `%s`",
+			expiryActionAnnotation, action, expiryActionDelete, expiryActionScale)
+	}
+	return lc, nil
+}
+
+// isExpired returns whether a rsync source was scaled to zero on expiry.
+func isExpired(cr internalv1.RsyncSource) bool {
+	_, ok := cr.GetAnnotations()[expiredAnnotation]
+	return ok
+}
+
+// ensureLifecycle expires a rsync source whose TTL or idle timeout passed.
+// lastActivity is when the last session was seen, if ever. It returns when
+// the source has to be checked again, zero if it doesn't expire.
+func (c *controller) ensureLifecycle(ctx context.Context, cr internalv1.RsyncSource, tc *templateConfig, lastActivity time.Time) (time.Duration, error) {
+	lc := tc.lifecycle
+	if (lc.ttl == 0 && lc.idleTimeout == 0) || isExpired(cr) {
+		return 0, nil
+	}
+	now := time.Now()
+	var requeue time.Duration
+	next := func(deadline time.Time) {
+		if remaining := deadline.Sub(now); requeue == 0 || remaining < requeue {
+			requeue = remaining
+		}
+	}
+	reason, message := "", ""
+	if lc.ttl != 0 {
+		deadline := cr.GetCreationTimestamp().Add(lc.ttl)
+		if now.Before(deadline) {
+			next(deadline)
+		} else {
+			reason, message = "TTLExpired", fmt.Sprintf("the source expired %s after its creation", lc.ttl)
+		}
+	}
+	if reason == "" && lc.idleTimeout != 0 {
+		// A source that never served a session is idle since its daemon
+		// was started.
+		deployment, err := c.kubeClient.AppsV1().Deployments(cr.GetNamespace()).
+			Get(ctx, cr.GetName(), metav1.GetOptions{})
+		if err != nil {
+			if errors.IsNotFound(err) {
+				return requeue, nil
+			}
+			return 0, err
+		}
+		since := deployment.GetCreationTimestamp().Time
+		if lastActivity.After(since) {
+			since = lastActivity
+		}
+		deadline := since.Add(lc.idleTimeout)
+		if now.Before(deadline) {
+			next(deadline)
+		} else {
+			reason, message = "IdleTimeout", fmt.Sprintf("no session was seen for %s", lc.idleTimeout)
+		}
+	}
+	if reason == "" {
+		return requeue, c.setRsyncSourceStatus(ctx, cr.GetNamespace(), cr.GetName(), nil, metav1.Condition{
+			Type:    expiredConditionType,
+			Status:  metav1.ConditionFalse,
+			Reason:  "Active",
+			Message: "neither the TTL nor the idle timeout passed",
+		})
+	}
+	condition := metav1.Condition{
+		Type:    expiredConditionType,
+		Status:  metav1.ConditionTrue,
+		Reason:  reason,
+		Message: message,
+	}
+	if lc.action == expiryActionScale {
+		expired := now.UTC().Format(time.RFC3339)
+		return 0, c.setRsyncSourceStatus(ctx, cr.GetNamespace(), cr.GetName(), map[string]*string{
+			expiredAnnotation: &expired,
+		}, condition)
+	}
+	if err := c.setRsyncSourceStatus(ctx, cr.GetNamespace(), cr.GetName(), nil, condition); err != nil {
+		return 0, err
+	}
+	err := c.dynamicClient.Resource(rsyncSourceGVR).Namespace(cr.GetNamespace()).
+		Delete(ctx, cr.GetName(), metav1.DeleteOptions{})
+	if err != nil && !errors.IsNotFound(err) {
+		return 0, err
+	}
+	return 0, nil
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLifecycleConfigFromAnnotations(t *testing.T) {
+	tests := []struct {
+		name        string
+		annotations map[string]string
+		logShipper  bool
+		want        lifecycleConfig
+		wantErr     bool
+	}{
+		{
+			name: "unset",
+			want: lifecycleConfig{action: expiryActionDelete},
+		},
+		{
+			name:        "TTL",
+			annotations: map[string]string{ttlAnnotation: "3600"},
+			want:        lifecycleConfig{ttl: time.Hour, action: expiryActionDelete},
+		},
+		{
+			name:        "zero TTL",
+			annotations: map[string]string{ttlAnnotation: "0"},
+			wantErr:     true,
+		},
+		{
+			name:        "TTL as duration",
+			annotations: map[string]string{ttlAnnotation: "1h"},
+			wantErr:     true,
+		},
+		{
+			name: "idle timeout scaled to zero",
+			annotations: map[string]string{
+				idleTimeoutAnnotation:  "90m",
+				expiryActionAnnotation: expiryActionScale,
+			},
+			logShipper: true,
+			want:       lifecycleConfig{idleTimeout: 90 * time.Minute, action: expiryActionScale},
+		},
+		{
+			name:        "idle timeout without log shipper",
+			annotations: map[string]string{idleTimeoutAnnotation: "1h"},
+			wantErr:     true,
+		},
+		{
+			name:        "negative idle timeout",
+			annotations: map[string]string{idleTimeoutAnnotation: "-1h"},
+			logShipper:  true,
+			wantErr:     true,
+		},
+		{
+			name:        "idle timeout without unit",
+			annotations: map[string]string{idleTimeoutAnnotation: "60"},
+			logShipper:  true,
+			wantErr:     true,
+		},
+		{
+			name:        "invalid expiry action",
+			annotations: map[string]string{expiryActionAnnotation: "Stop"},
+			wantErr:     true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := lifecycleConfigFromAnnotations(tt.annotations, tt.logShipper)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("lifecycleConfigFromAnnotations() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && got != tt.want {
+				t.Errorf("lifecycleConfigFromAnnotations() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
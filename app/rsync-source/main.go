@@ -10,6 +10,15 @@ import (
 	"k8s.io/klog/v2"
 )
 
+var (
+	rsyncProxyImage string
+	rsyncSSHDImage  string
+	fileServerImage string
+
+	rsyncLogShipperImage string
+	rsyncActivatorImage  string
+)
+
 func main() {
 	klog.InitFlags(nil)
 	var kubeconfig *string
@@ -19,6 +28,16 @@ func main() {
 	} else {
 		kubeconfig = flag.String("kubeconfig", "", "absolute path to the kubeconfig file")
 	}
+	flag.StringVar(&rsyncProxyImage, "rsync-proxy-image", "ghcr.io/k8svol/rsync-proxy:ci",
+		"Image of the authenticating proxy sidecar of sources with the demo.io/auth annotation")
+	flag.StringVar(&rsyncSSHDImage, "rsync-sshd-image", "ghcr.io/k8svol/rsync-sshd:ci",
+		"Image of the SSH server of sources with the SSH transport, it needs sshd and rsync")
+	flag.StringVar(&fileServerImage, "file-server-image", "ghcr.io/k8svol/file-server:ci",
+		"Image of the file server of sources with the HTTP backend")
+	flag.StringVar(&rsyncLogShipperImage, "rsync-log-shipper-image", "ghcr.io/k8svol/rsync-log-shipper:ci",
+		"Image of the sidecar shipping the transfer log of the rsync daemon")
+	flag.StringVar(&rsyncActivatorImage, "rsync-activator-image", "ghcr.io/k8svol/rsync-activator:ci",
+		"Image of the activator of sources with the demo.io/activator annotation")
 	flag.Parse()
 
 	cfg, err := clientcmd.BuildConfigFromFlags("", *kubeconfig)
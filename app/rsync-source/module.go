@@ -0,0 +1,68 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"path"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+const (
+	// dataModuleName is the rsync module that serves RsyncSourceSpec.Volume.
+	dataModuleName = "data"
+	// modulesAnnotation holds a JSON list of additional rsync modules that
+	// are served next to the data module. volume-source uses it to expose
+	// extra host roots from the same daemon.
+	modulesAnnotation = "demo.io/modules"
+	// moduleMountRoot is the directory inside the daemon container where
+	// the volumes of additional modules are mounted.
+	moduleMountRoot = "/modules"
+)
+
+// module is an additional rsync module served by a rsync source.
+type module struct {
+	// Name of the rsync module. It must be a valid DNS-1123 label as it is
+	// also used to name the pod volume.
+	Name string `json:"name"`
+	// ReadOnly makes both the volume mount and the rsync module read only.
+	ReadOnly bool `json:"readOnly"`
+	// Volume served by the module. Its name is ignored.
+	Volume corev1.Volume `json:"volume"`
+	// Options of the module, see moduleOptions.
+	Options *moduleOptions `json:"options,omitempty"`
+}
+
+func (m module) volumeName() string {
+	return "module-" + m.Name
+}
+
+func (m module) mountPath() string {
+	return path.Join(moduleMountRoot, m.Name)
+}
+
+// modulesFromAnnotations parses the additional modules of a rsync source.
+func modulesFromAnnotations(annotations map[string]string) ([]module, error) {
+	raw, ok := annotations[modulesAnnotation]
+	if !ok || raw == "" {
+		return nil, nil
+	}
+	modules := []module{}
+	if err := json.Unmarshal([]byte(raw), &modules); err != nil {
+		return nil, fmt.Errorf("invalid `%s` annotation, error: %s", modulesAnnotation, err)
+	}
+	seen := map[string]bool{dataModuleName: true}
+	for i := range modules {
+		name := modules[i].Name
+		if errs := validation.IsDNS1123Label(name); len(errs) != 0 {
+			return nil, fmt.Errorf("invalid module name `%s`: %v", name, errs)
+		}
+		if seen[name] {
+			return nil, fmt.Errorf("duplicate module name `%s`", name)
+		}
+		seen[name] = true
+		modules[i].Volume.Name = modules[i].volumeName()
+	}
+	return modules, nil
+}
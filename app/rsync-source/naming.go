@@ -0,0 +1,30 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+)
+
+const (
+	// nameHashLength is the number of hex chars of the hash that keeps
+	// shortened names unique.
+	nameHashLength = 10
+)
+
+// nameHash returns a short hash of s.
+func nameHash(s string) string {
+	sum := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(sum[:])[:nameHashLength]
+}
+
+// shortenName returns name if it has at most maxLength chars. Longer names
+// are truncated and followed by a hash of the full name, so different names
+// stay different.
+func shortenName(name string, maxLength int) string {
+	if len(name) <= maxLength {
+		return name
+	}
+	prefix := strings.TrimRight(name[:maxLength-nameHashLength-1], "-")
+	return prefix + "-" + nameHash(name)
+}
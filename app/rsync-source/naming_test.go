@@ -0,0 +1,39 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestShortenName(t *testing.T) {
+	long := strings.Repeat("a", 60) + "-activator"
+	tests := []struct {
+		name       string
+		input      string
+		maxLength  int
+		want       string
+		wantPrefix string
+	}{
+		{name: "short", input: "src-activator", maxLength: 63, want: "src-activator"},
+		{name: "at the limit", input: strings.Repeat("a", 63), maxLength: 63, want: strings.Repeat("a", 63)},
+		{name: "truncated", input: long, maxLength: 63, wantPrefix: strings.Repeat("a", 52) + "-"},
+		{name: "trailing dash trimmed", input: strings.Repeat("a", 51) + "-b" + long, maxLength: 63, wantPrefix: strings.Repeat("a", 51) + "-"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := shortenName(tt.input, tt.maxLength)
+			if len(got) > tt.maxLength {
+				t.Errorf("shortenName() = %s, longer than %d", got, tt.maxLength)
+			}
+			if tt.want != "" && got != tt.want {
+				t.Errorf("shortenName() = %s, want %s", got, tt.want)
+			}
+			if tt.wantPrefix != "" && (!strings.HasPrefix(got, tt.wantPrefix) || len(got) != len(tt.wantPrefix)+nameHashLength) {
+				t.Errorf("shortenName() = %s, want %s and the hash", got, tt.wantPrefix)
+			}
+		})
+	}
+	if shortenName(long+"1", 63) == shortenName(long+"2", 63) {
+		t.Errorf("shortenName() of different names are equal")
+	}
+}
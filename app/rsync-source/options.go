@@ -0,0 +1,115 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+const (
+	// moduleOptionsAnnotation holds a JSON object of moduleOptions by
+	// module name. It applies to the data module and the additional
+	// modules, which can also carry their options inline.
+	moduleOptionsAnnotation = "demo.io/module-options"
+	// bwlimitAnnotation limits the rate the daemon sends data with, e.g.
+	// `10m`. rsyncd.conf has no per-module limit, so it applies to all
+	// modules of the source.
+	bwlimitAnnotation = "demo.io/bwlimit"
+)
+
+var (
+	// refuseOptionPattern matches a rsync option name, optionally negated
+	// or with wildcards, e.g. `delete*` or `!a`.
+	refuseOptionPattern = regexp.MustCompile(`^!?[a-zA-Z0-9*?-]+$`)
+	bwlimitPattern      = regexp.MustCompile(`^[0-9]+(\.[0-9]+)?[bkmgtpBKMGTP]?$`)
+)
+
+// moduleOptions are the filter and transfer options of a rsync module.
+type moduleOptions struct {
+	// Include patterns take precedence over the exclude patterns.
+	Include []string `json:"include,omitempty"`
+	// Exclude patterns, e.g. `lost+found/` or `*.tmp`.
+	Exclude []string `json:"exclude,omitempty"`
+	// DontCompress lists file patterns that are sent uncompressed.
+	DontCompress []string `json:"dontCompress,omitempty"`
+	// MaxConnections of the module, 0 means unlimited.
+	MaxConnections int `json:"maxConnections,omitempty"`
+	// RefuseOptions lists client options the module refuses.
+	RefuseOptions []string `json:"refuseOptions,omitempty"`
+	// TimeoutSeconds of idle connections. Defaults to 600.
+	TimeoutSeconds int `json:"timeoutSeconds,omitempty"`
+}
+
+// validate rejects options that can't be rendered into rsyncd.conf. Lists
+// are space separated there, so patterns can't contain white space.
+func (o moduleOptions) validate() error {
+	for name, patterns := range map[string][]string{
+		"include":      o.Include,
+		"exclude":      o.Exclude,
+		"dontCompress": o.DontCompress,
+	} {
+		for _, p := range patterns {
+			if p == "" || strings.IndexFunc(p, isSpaceOrControl) != -1 {
+				return fmt.Errorf("invalid %s pattern `%s`, it must be non-empty without white space", name, p)
+			}
+		}
+	}
+	for _, opt := range o.RefuseOptions {
+		if !refuseOptionPattern.MatchString(opt) {
+			return fmt.Errorf("invalid refuse option `%s`", opt)
+		}
+	}
+	if o.MaxConnections < 0 {
+		return fmt.Errorf("invalid maxConnections %d, it must not be negative", o.MaxConnections)
+	}
+	if o.TimeoutSeconds < 0 {
+		return fmt.Errorf("invalid timeoutSeconds %d, it must not be negative", o.TimeoutSeconds)
+	}
+	return nil
+}
+
+func isSpaceOrControl(r rune) bool {
+	return r <= ' ' || r == 0x7f
+}
+
+// moduleOptionsFromAnnotations returns the validated options of the modules
+// of a rsync source by module name.
+func moduleOptionsFromAnnotations(annotations map[string]string, modules []module) (map[string]moduleOptions, error) {
+	options := map[string]moduleOptions{}
+	if raw, ok := annotations[moduleOptionsAnnotation]; ok && raw != "" {
+		if err := json.Unmarshal([]byte(raw), &options); err != nil {
+			return nil, fmt.Errorf("invalid `%s` annotation, error: %s", moduleOptionsAnnotation, err)
+		}
+	}
+	known := map[string]bool{dataModuleName: true}
+	for _, m := range modules {
+		known[m.Name] = true
+		if m.Options == nil {
+			continue
+		}
+		if _, ok := options[m.Name]; ok {
+			return nil, fmt.Errorf("options of module `%s` are set inline and in the `%s` annotation",
+				m.Name, moduleOptionsAnnotation)
+		}
+		options[m.Name] = *m.Options
+	}
+	for name, o := range options {
+		if !known[name] {
+			return nil, fmt.Errorf("invalid `%s` annotation, unknown module `%s`", moduleOptionsAnnotation, name)
+		}
+		if err := o.validate(); err != nil {
+			return nil, fmt.Errorf("invalid options of module `%s`: %s", name, err)
+		}
+	}
+	return options, nil
+}
+
+// bwlimitFromAnnotations returns the validated rate limit of the daemon.
+func bwlimitFromAnnotations(annotations map[string]string) (string, error) {
+	bwlimit := annotations[bwlimitAnnotation]
+	if bwlimit != "" && !bwlimitPattern.MatchString(bwlimit) {
+		return "", fmt.Errorf("invalid `%s` annotation `%s`", bwlimitAnnotation, bwlimit)
+	}
+	return bwlimit, nil
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestModuleOptionsFromAnnotations(t *testing.T) {
+	tests := []struct {
+		name        string
+		annotations map[string]string
+		modules     []module
+		want        map[string]moduleOptions
+		wantErr     bool
+	}{
+		{
+			name: "no options",
+			want: map[string]moduleOptions{},
+		},
+		{
+			name: "options of the data module",
+			annotations: map[string]string{
+				moduleOptionsAnnotation: `{"data": {"exclude": ["*.tmp"], "maxConnections": 2}}`,
+			},
+			want: map[string]moduleOptions{
+				dataModuleName: {Exclude: []string{"*.tmp"}, MaxConnections: 2},
+			},
+		},
+		{
+			name:    "inline options of a module",
+			modules: []module{{Name: "logs", Options: &moduleOptions{RefuseOptions: []string{"delete*"}}}},
+			want: map[string]moduleOptions{
+				"logs": {RefuseOptions: []string{"delete*"}},
+			},
+		},
+		{
+			name: "options of a module set twice",
+			annotations: map[string]string{
+				moduleOptionsAnnotation: `{"logs": {"exclude": ["*.tmp"]}}`,
+			},
+			modules: []module{{Name: "logs", Options: &moduleOptions{}}},
+			wantErr: true,
+		},
+		{
+			name:        "unknown module",
+			annotations: map[string]string{moduleOptionsAnnotation: `{"other": {}}`},
+			wantErr:     true,
+		},
+		{
+			name:        "invalid JSON",
+			annotations: map[string]string{moduleOptionsAnnotation: `{"data": [`},
+			wantErr:     true,
+		},
+		{
+			name:        "pattern with white space",
+			annotations: map[string]string{moduleOptionsAnnotation: `{"data": {"exclude": ["a b"]}}`},
+			wantErr:     true,
+		},
+		{
+			name:        "empty pattern",
+			annotations: map[string]string{moduleOptionsAnnotation: `{"data": {"include": [""]}}`},
+			wantErr:     true,
+		},
+		{
+			name:        "invalid refuse option",
+			annotations: map[string]string{moduleOptionsAnnotation: `{"data": {"refuseOptions": ["delete;rm"]}}`},
+			wantErr:     true,
+		},
+		{
+			name:        "negative max connections",
+			annotations: map[string]string{moduleOptionsAnnotation: `{"data": {"maxConnections": -1}}`},
+			wantErr:     true,
+		},
+		{
+			name:        "negative timeout",
+			annotations: map[string]string{moduleOptionsAnnotation: `{"data": {"timeoutSeconds": -1}}`},
+			wantErr:     true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := moduleOptionsFromAnnotations(tt.annotations, tt.modules)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("moduleOptionsFromAnnotations() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("moduleOptionsFromAnnotations() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBwlimitFromAnnotations(t *testing.T) {
+	tests := []struct {
+		name    string
+		value   string
+		want    string
+		wantErr bool
+	}{
+		{name: "unset"},
+		{name: "kilobytes", value: "500", want: "500"},
+		{name: "with unit", value: "10m", want: "10m"},
+		{name: "fraction", value: "1.5G", want: "1.5G"},
+		{name: "unknown unit", value: "10x", wantErr: true},
+		{name: "flag injection", value: "10m --port=1", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			annotations := map[string]string{}
+			if tt.value != "" {
+				annotations[bwlimitAnnotation] = tt.value
+			}
+			got, err := bwlimitFromAnnotations(annotations)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("bwlimitFromAnnotations() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("bwlimitFromAnnotations() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCommand(t *testing.T) {
+	tests := []struct {
+		name        string
+		bwlimit     string
+		nonRoot     bool
+		want        []string
+		secretsFile string
+	}{
+		{
+			name:        "entrypoint of the image",
+			secretsFile: "/etc/rsyncd.secrets",
+		},
+		{
+			name:        "rate limit",
+			bwlimit:     "10m",
+			want:        []string{"rsync", "--daemon", "--no-detach", "--config=/etc/rsyncd.conf", "--bwlimit=10m"},
+			secretsFile: secretsDir + "/" + credentialsSecretsFileKey,
+		},
+		{
+			name:        "non-root",
+			nonRoot:     true,
+			want:        []string{"rsync", "--daemon", "--no-detach", "--config=/etc/rsyncd.conf"},
+			secretsFile: secretsDir + "/" + credentialsSecretsFileKey,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tc := &templateConfig{bwlimit: tt.bwlimit, security: securityConfig{nonRoot: tt.nonRoot}}
+			if got := tc.command(); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("command() = %v, want %v", got, tt.want)
+			}
+			if got := tc.secretsFile(); got != tt.secretsFile {
+				t.Errorf("secretsFile() = %q, want %q", got, tt.secretsFile)
+			}
+			volumes, mounts := tc.secretsVolumes()
+			if (len(volumes) != 0) != (tt.want != nil) || len(volumes) != len(mounts) {
+				t.Errorf("secretsVolumes() = %v, %v", volumes, mounts)
+			}
+		})
+	}
+}
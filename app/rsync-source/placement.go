@@ -0,0 +1,308 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+
+	corev1 "k8s.io/api/core/v1"
+	storagev1 "k8s.io/api/storage/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/klog/v2"
+
+	internalv1 "github.com/k8s-volume-copy/types/apis/demo.io/v1"
+	"github.com/k8s-volume-copy/types/constant"
+)
+
+// A rsync source with the `demo.io/pvc-ref` annotation is placed
+// automatically: the daemon is pinned to the node where the claim is in use
+// and follows it when the consuming pods move. An unused claim is scheduled
+// freely.
+
+const (
+	// pvcRefAnnotation names the claim of spec.volume and opts the source
+	// into automatic placement. spec.hostName must be empty.
+	pvcRefAnnotation = "demo.io/pvc-ref"
+
+	// claimIndex indexes cached pods and automatically placed rsync sources
+	// by the namespace/name of the claims they use.
+	claimIndex = "claim"
+	// persistentVolumeIndex indexes volume attachments by volume name.
+	persistentVolumeIndex = "persistentVolume"
+)
+
+// pvcRefFromRsyncSource returns the claim of a rsync source that is placed
+// automatically, or "".
+func pvcRefFromRsyncSource(cr internalv1.RsyncSource) (string, error) {
+	claim, ok := cr.GetAnnotations()[pvcRefAnnotation]
+	if !ok {
+		return "", nil
+	}
+	if cr.Spec.HostName != "" {
+		return "", fmt.Errorf("`%s` annotation can't be used with spec.hostName", pvcRefAnnotation)
+	}
+	if pvc := cr.Spec.Volume.PersistentVolumeClaim; pvc == nil || pvc.ClaimName != claim {
+		return "", fmt.Errorf("`%s` annotation `%s` must name the claim of spec.volume", pvcRefAnnotation, claim)
+	}
+	return claim, nil
+}
+
+func rsyncSourceClaimIndexFunc(obj interface{}) ([]string, error) {
+	u, ok := obj.(*unstructured.Unstructured)
+	if !ok {
+		return nil, nil
+	}
+	claim, ok := u.GetAnnotations()[pvcRefAnnotation]
+	if !ok {
+		return nil, nil
+	}
+	return []string{u.GetNamespace() + "/" + claim}, nil
+}
+
+// podClaimIndexFunc returns the namespace/name keys of the claims of a pod.
+func podClaimIndexFunc(obj interface{}) ([]string, error) {
+	pod, ok := obj.(*corev1.Pod)
+	if !ok {
+		return nil, nil
+	}
+	claims := []string{}
+	for _, v := range pod.Spec.Volumes {
+		if v.PersistentVolumeClaim != nil {
+			claims = append(claims, pod.GetNamespace()+"/"+v.PersistentVolumeClaim.ClaimName)
+		}
+	}
+	return claims, nil
+}
+
+func persistentVolumeIndexFunc(obj interface{}) ([]string, error) {
+	va, ok := obj.(*storagev1.VolumeAttachment)
+	if !ok || va.Spec.Source.PersistentVolumeName == nil {
+		return nil, nil
+	}
+	return []string{*va.Spec.Source.PersistentVolumeName}, nil
+}
+
+// transformPod keeps the fields required to find where a claim is used.
+// Only the claim volumes of a pod are kept.
+func transformPod(obj interface{}) (interface{}, error) {
+	pod, ok := obj.(*corev1.Pod)
+	if !ok {
+		return obj, nil
+	}
+	volumes := []corev1.Volume{}
+	for _, v := range pod.Spec.Volumes {
+		if v.PersistentVolumeClaim != nil {
+			volumes = append(volumes, v)
+		}
+	}
+	return &corev1.Pod{
+		TypeMeta: pod.TypeMeta,
+		ObjectMeta: metav1.ObjectMeta{
+			Name:              pod.Name,
+			Namespace:         pod.Namespace,
+			UID:               pod.UID,
+			ResourceVersion:   pod.ResourceVersion,
+			CreationTimestamp: pod.CreationTimestamp,
+			DeletionTimestamp: pod.DeletionTimestamp,
+			Labels:            pod.Labels,
+		},
+		Spec: corev1.PodSpec{
+			NodeName: pod.Spec.NodeName,
+			Volumes:  volumes,
+		},
+		Status: corev1.PodStatus{
+			Phase: pod.Status.Phase,
+		},
+	}, nil
+}
+
+// handlePod queues the automatically placed rsync sources of the claims of
+// a pod.
+func (c *controller) handlePod(obj interface{}) {
+	if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+		obj = tombstone.Obj
+	}
+	claims, _ := podClaimIndexFunc(obj)
+	for _, claim := range claims {
+		sources, err := c.rsIndexer.ByIndex(claimIndex, claim)
+		if err != nil {
+			continue
+		}
+		for _, source := range sources {
+			c.handle(source)
+		}
+	}
+}
+
+// handleVolumeAttachment queues the automatically placed rsync sources of
+// the claim bound to the attached volume.
+func (c *controller) handleVolumeAttachment(obj interface{}) {
+	if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+		obj = tombstone.Obj
+	}
+	names, _ := persistentVolumeIndexFunc(obj)
+	for _, name := range names {
+		pv, err := c.pvLister.Get(name)
+		if err != nil || pv.Spec.ClaimRef == nil {
+			continue
+		}
+		sources, err := c.rsIndexer.ByIndex(claimIndex, pv.Spec.ClaimRef.Namespace+"/"+pv.Spec.ClaimRef.Name)
+		if err != nil {
+			continue
+		}
+		for _, source := range sources {
+			c.handle(source)
+		}
+	}
+}
+
+// handlePersistentVolumeClaim queues the automatically placed rsync sources
+// of a claim.
+func (c *controller) handlePersistentVolumeClaim(obj interface{}) {
+	if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+		obj = tombstone.Obj
+	}
+	pvc, ok := obj.(*corev1.PersistentVolumeClaim)
+	if !ok {
+		return
+	}
+	sources, err := c.rsIndexer.ByIndex(claimIndex, pvc.GetNamespace()+"/"+pvc.GetName())
+	if err != nil {
+		return
+	}
+	for _, source := range sources {
+		c.handle(source)
+	}
+}
+
+// transformPersistentVolumeClaim keeps the volume a claim is bound to.
+func transformPersistentVolumeClaim(obj interface{}) (interface{}, error) {
+	pvc, ok := obj.(*corev1.PersistentVolumeClaim)
+	if !ok {
+		return obj, nil
+	}
+	return &corev1.PersistentVolumeClaim{
+		TypeMeta: pvc.TypeMeta,
+		ObjectMeta: metav1.ObjectMeta{
+			Name:            pvc.Name,
+			Namespace:       pvc.Namespace,
+			UID:             pvc.UID,
+			ResourceVersion: pvc.ResourceVersion,
+		},
+		Spec: corev1.PersistentVolumeClaimSpec{
+			VolumeName: pvc.Spec.VolumeName,
+		},
+	}, nil
+}
+
+// transformNode keeps the kubernetes.io/hostname label of a node, the
+// daemons are pinned with it.
+func transformNode(obj interface{}) (interface{}, error) {
+	node, ok := obj.(*corev1.Node)
+	if !ok {
+		return obj, nil
+	}
+	labels := map[string]string{}
+	if hostName, ok := node.GetLabels()[constant.K8SIOHostName]; ok {
+		labels[constant.K8SIOHostName] = hostName
+	}
+	return &corev1.Node{
+		TypeMeta: node.TypeMeta,
+		ObjectMeta: metav1.ObjectMeta{
+			Name:            node.Name,
+			UID:             node.UID,
+			ResourceVersion: node.ResourceVersion,
+			Labels:          labels,
+		},
+	}, nil
+}
+
+// transformPersistentVolume keeps the claim a volume is bound to.
+func transformPersistentVolume(obj interface{}) (interface{}, error) {
+	pv, ok := obj.(*corev1.PersistentVolume)
+	if !ok {
+		return obj, nil
+	}
+	return &corev1.PersistentVolume{
+		TypeMeta: pv.TypeMeta,
+		ObjectMeta: metav1.ObjectMeta{
+			Name:            pv.Name,
+			UID:             pv.UID,
+			ResourceVersion: pv.ResourceVersion,
+		},
+		Spec: corev1.PersistentVolumeSpec{
+			ClaimRef: pv.Spec.ClaimRef,
+		},
+	}, nil
+}
+
+// nodeOfClaim returns the node a claim is in use on. Running pods other
+// than the rsync daemons are preferred, the newest one wins so that the
+// daemon follows an app that moves. Volume attachments are used when no
+// pod uses the claim. It returns "" for an unused claim.
+func (c *controller) nodeOfClaim(namespace, claimName string) (string, error) {
+	objs, err := c.podIndexer.ByIndex(claimIndex, namespace+"/"+claimName)
+	if err != nil {
+		return "", err
+	}
+	pods := []*corev1.Pod{}
+	for _, obj := range objs {
+		pod := obj.(*corev1.Pod)
+		if pod.Spec.NodeName == "" || pod.DeletionTimestamp != nil ||
+			pod.Status.Phase == corev1.PodSucceeded || pod.Status.Phase == corev1.PodFailed ||
+			pod.GetLabels()[constant.CreatedByLabel] == constant.ComponentNameRsyncSourceController {
+			continue
+		}
+		pods = append(pods, pod)
+	}
+	if len(pods) != 0 {
+		sort.Slice(pods, func(i, j int) bool {
+			return pods[j].CreationTimestamp.Before(&pods[i].CreationTimestamp)
+		})
+		return pods[0].Spec.NodeName, nil
+	}
+
+	pvc, err := c.pvcLister.PersistentVolumeClaims(namespace).Get(claimName)
+	if err != nil {
+		if errors.IsNotFound(err) {
+			return "", nil
+		}
+		return "", err
+	}
+	if pvc.Spec.VolumeName == "" {
+		return "", nil
+	}
+	attachments, err := c.vaIndexer.ByIndex(persistentVolumeIndex, pvc.Spec.VolumeName)
+	if err != nil {
+		return "", err
+	}
+	for _, obj := range attachments {
+		va := obj.(*storagev1.VolumeAttachment)
+		if va.Status.Attached && va.DeletionTimestamp == nil {
+			return va.Spec.NodeName, nil
+		}
+	}
+	return "", nil
+}
+
+// resolveHostName sets the HostName of an automatically placed rsync source
+// to the kubernetes.io/hostname label of the node its claim is used on.
+func (c *controller) resolveHostName(cr *internalv1.RsyncSource, claim string) error {
+	if claim == "" {
+		return nil
+	}
+	nodeName, err := c.nodeOfClaim(cr.GetNamespace(), claim)
+	if err != nil || nodeName == "" {
+		return err
+	}
+	node, err := c.nodeLister.Get(nodeName)
+	if err != nil {
+		return err
+	}
+	cr.Spec.HostName = node.GetLabels()[constant.K8SIOHostName]
+	klog.V(4).Infof("Claim `%s` of rsync source `%s` in `%s` namespace is used on node `%s`",
+		claim, cr.GetName(), cr.GetNamespace(), nodeName)
+	return nil
+}
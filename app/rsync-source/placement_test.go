@@ -0,0 +1,244 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	storagev1 "k8s.io/api/storage/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/util/workqueue"
+
+	internalv1 "github.com/k8s-volume-copy/types/apis/demo.io/v1"
+	"github.com/k8s-volume-copy/types/constant"
+)
+
+func TestPVCRefFromRsyncSource(t *testing.T) {
+	claimVolume := corev1.Volume{
+		Name: "data",
+		VolumeSource: corev1.VolumeSource{
+			PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{ClaimName: "app-data"},
+		},
+	}
+	tests := []struct {
+		name        string
+		annotations map[string]string
+		hostName    string
+		volume      corev1.Volume
+		want        string
+		wantErr     bool
+	}{
+		{
+			name:   "claim without annotation isn't placed",
+			volume: claimVolume,
+		},
+		{
+			name:        "annotation names the claim",
+			annotations: map[string]string{pvcRefAnnotation: "app-data"},
+			volume:      claimVolume,
+			want:        "app-data",
+		},
+		{
+			name:        "annotation names another claim",
+			annotations: map[string]string{pvcRefAnnotation: "other"},
+			volume:      claimVolume,
+			wantErr:     true,
+		},
+		{
+			name:        "annotation with host path",
+			annotations: map[string]string{pvcRefAnnotation: "app-data"},
+			volume: corev1.Volume{
+				Name:         "data",
+				VolumeSource: corev1.VolumeSource{HostPath: &corev1.HostPathVolumeSource{Path: "/data"}},
+			},
+			wantErr: true,
+		},
+		{
+			name:        "annotation with host name",
+			annotations: map[string]string{pvcRefAnnotation: "app-data"},
+			hostName:    "node-a",
+			volume:      claimVolume,
+			wantErr:     true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cr := internalv1.RsyncSource{
+				ObjectMeta: metav1.ObjectMeta{Annotations: tt.annotations},
+				Spec: internalv1.RsyncSourceSpec{
+					HostName: tt.hostName,
+					Volume:   tt.volume,
+				},
+			}
+			got, err := pvcRefFromRsyncSource(cr)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("pvcRefFromRsyncSource() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("pvcRefFromRsyncSource() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestResolveHostName(t *testing.T) {
+	started := metav1.NewTime(time.Date(2026, 10, 18, 12, 0, 0, 0, time.UTC))
+	pod := func(name, nodeName string, created metav1.Time, labels map[string]string) *corev1.Pod {
+		return &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{
+				Name: name, Namespace: "app", Labels: labels, CreationTimestamp: created,
+			},
+			Spec: corev1.PodSpec{
+				NodeName: nodeName,
+				Volumes: []corev1.Volume{{
+					Name: "data",
+					VolumeSource: corev1.VolumeSource{
+						PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{ClaimName: "app-data"},
+					},
+				}},
+			},
+			Status: corev1.PodStatus{Phase: corev1.PodRunning},
+		}
+	}
+	claim := &corev1.PersistentVolumeClaim{
+		ObjectMeta: metav1.ObjectMeta{Name: "app-data", Namespace: "app"},
+		Spec:       corev1.PersistentVolumeClaimSpec{VolumeName: "pv-1"},
+	}
+	volumeName := "pv-1"
+	attachment := &storagev1.VolumeAttachment{
+		ObjectMeta: metav1.ObjectMeta{Name: "va-1"},
+		Spec: storagev1.VolumeAttachmentSpec{
+			NodeName: "node-c",
+			Source:   storagev1.VolumeAttachmentSource{PersistentVolumeName: &volumeName},
+		},
+		Status: storagev1.VolumeAttachmentStatus{Attached: true},
+	}
+	tests := []struct {
+		name        string
+		pods        []*corev1.Pod
+		claims      []*corev1.PersistentVolumeClaim
+		attachments []*storagev1.VolumeAttachment
+		want        string
+		wantErr     bool
+	}{
+		{
+			name:   "unused claim",
+			claims: []*corev1.PersistentVolumeClaim{claim},
+		},
+		{
+			name: "claim not cached",
+		},
+		{
+			name: "newest pod wins",
+			pods: []*corev1.Pod{
+				pod("old", "node-a", started, nil),
+				pod("new", "node-b", metav1.NewTime(started.Add(time.Minute)), nil),
+			},
+			want: "host-b",
+		},
+		{
+			name: "rsync daemons are ignored",
+			pods: []*corev1.Pod{
+				pod("app", "node-a", started, nil),
+				pod("daemon", "node-b", metav1.NewTime(started.Add(time.Minute)), map[string]string{
+					constant.CreatedByLabel: constant.ComponentNameRsyncSourceController,
+				}),
+			},
+			want: "host-a",
+		},
+		{
+			name:        "attached volume",
+			claims:      []*corev1.PersistentVolumeClaim{claim},
+			attachments: []*storagev1.VolumeAttachment{attachment},
+			want:        "host-c",
+		},
+		{
+			name:    "node not cached",
+			pods:    []*corev1.Pod{pod("app", "node-d", started, nil)},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			podIndexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{claimIndex: podClaimIndexFunc})
+			for _, p := range tt.pods {
+				if err := podIndexer.Add(p); err != nil {
+					t.Fatal(err)
+				}
+			}
+			vaIndexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{persistentVolumeIndex: persistentVolumeIndexFunc})
+			for _, va := range tt.attachments {
+				if err := vaIndexer.Add(va); err != nil {
+					t.Fatal(err)
+				}
+			}
+			pvcIndexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+			for _, pvc := range tt.claims {
+				if err := pvcIndexer.Add(pvc); err != nil {
+					t.Fatal(err)
+				}
+			}
+			nodeIndexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+			for _, name := range []string{"a", "b", "c"} {
+				if err := nodeIndexer.Add(&corev1.Node{ObjectMeta: metav1.ObjectMeta{
+					Name:   "node-" + name,
+					Labels: map[string]string{constant.K8SIOHostName: "host-" + name},
+				}}); err != nil {
+					t.Fatal(err)
+				}
+			}
+			c := &controller{
+				podIndexer: podIndexer,
+				vaIndexer:  vaIndexer,
+				pvcLister:  corelisters.NewPersistentVolumeClaimLister(pvcIndexer),
+				nodeLister: corelisters.NewNodeLister(nodeIndexer),
+			}
+			cr := &internalv1.RsyncSource{ObjectMeta: metav1.ObjectMeta{Name: "src", Namespace: "app"}}
+			err := c.resolveHostName(cr, "app-data")
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("resolveHostName() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if cr.Spec.HostName != tt.want {
+				t.Errorf("resolveHostName() host name = %q, want %q", cr.Spec.HostName, tt.want)
+			}
+		})
+	}
+}
+
+func TestHandlePersistentVolumeClaim(t *testing.T) {
+	source := func(namespace, name, claim string) *unstructured.Unstructured {
+		u := &unstructured.Unstructured{}
+		u.SetNamespace(namespace)
+		u.SetName(name)
+		if claim != "" {
+			u.SetAnnotations(map[string]string{pvcRefAnnotation: claim})
+		}
+		return u
+	}
+	rsIndexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{claimIndex: rsyncSourceClaimIndexFunc})
+	for _, u := range []*unstructured.Unstructured{
+		source("app", "placed", "app-data"),
+		source("app", "pinned", ""),
+		source("other", "placed", "app-data"),
+	} {
+		if err := rsIndexer.Add(u); err != nil {
+			t.Fatal(err)
+		}
+	}
+	c := &controller{
+		rsIndexer: rsIndexer,
+		workqueue: workqueue.NewRateLimitingQueue(workqueue.DefaultControllerRateLimiter()),
+	}
+	defer c.workqueue.ShutDown()
+	pvc := &corev1.PersistentVolumeClaim{ObjectMeta: metav1.ObjectMeta{Name: "app-data", Namespace: "app"}}
+	c.handlePersistentVolumeClaim(cache.DeletedFinalStateUnknown{Key: "app/app-data", Obj: pvc})
+	if c.workqueue.Len() != 1 {
+		t.Fatalf("handlePersistentVolumeClaim() queued %d sources, want 1", c.workqueue.Len())
+	}
+	if key, _ := c.workqueue.Get(); key != "app/placed" {
+		t.Errorf("handlePersistentVolumeClaim() queued %v, want app/placed", key)
+	}
+}
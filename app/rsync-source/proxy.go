@@ -0,0 +1,164 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+const (
+	// authAnnotation selects how clients authenticate. With
+	// `ServiceAccountToken` the daemon is only reachable through the
+	// rsync-proxy sidecar, which accepts ServiceAccount tokens of subjects
+	// allowed to `create` the `rsyncsources/connect` subresource.
+	authAnnotation = "demo.io/auth"
+
+	authServiceAccountToken = "ServiceAccountToken"
+	// proxyServiceAccount is the ServiceAccount of the daemon pod, it must
+	// be allowed to create TokenReviews and SubjectAccessReviews. It isn't
+	// configurable, so sources can't run as other ServiceAccounts of their
+	// namespace.
+	proxyServiceAccount = "rsync-proxy"
+
+	// proxyBackendPort is the port the daemon listens on behind the proxy.
+	proxyBackendPort = 8874
+	proxyBackendHost = "127.0.0.1"
+
+	proxyTLSDir      = "/etc/rsync-proxy/tls"
+	proxyClientCADir = "/etc/rsync-proxy/client-ca"
+)
+
+// proxyConfig is the authenticating proxy in front of the daemon.
+type proxyConfig struct{}
+
+func proxyConfigFromAnnotations(annotations map[string]string) (*proxyConfig, error) {
+	switch auth := annotations[authAnnotation]; auth {
+	case "":
+		return nil, nil
+	case authServiceAccountToken:
+	default:
+		return nil, fmt.Errorf("invalid `%s` annotation `%s`, only `%s` is supported",
+			authAnnotation, auth, authServiceAccountToken)
+	}
+	return &proxyConfig{}, nil
+}
+
+// serviceAccountName returns the ServiceAccount of the daemon pod.
+func (tc *templateConfig) serviceAccountName() string {
+	if tc.proxy == nil {
+		return ""
+	}
+	return proxyServiceAccount
+}
+
+// hasProxy returns whether the daemon is served through the proxy sidecar,
+// which authenticates clients, terminates TLS or both.
+func (tc *templateConfig) hasProxy() bool {
+	return tc.proxy != nil || tc.tls != nil
+}
+
+// daemonPorts returns the ports of the daemon container, behind a proxy the
+// daemon isn't exposed.
+func (tc *templateConfig) daemonPorts() []corev1.ContainerPort {
+	if tc.hasProxy() {
+		return nil
+	}
+	return []corev1.ContainerPort{
+		{
+			Name:          "rsync-daemon",
+			ContainerPort: tc.security.port(),
+		},
+	}
+}
+
+// proxyContainers returns the proxy sidecar, if any. It takes over the
+// named port of the daemon so the service doesn't change.
+func (tc *templateConfig) proxyContainers() []corev1.Container {
+	if !tc.hasProxy() {
+		return nil
+	}
+	command := []string{
+		"rsync-proxy", "serve",
+		"--listen=:" + strconv.Itoa(int(tc.security.port())),
+		fmt.Sprintf("--backend=%s:%d", proxyBackendHost, proxyBackendPort),
+		"--namespace=" + tc.namespace,
+		"--name=" + tc.name,
+	}
+	if tc.proxy == nil {
+		// Only TLS is terminated, the daemon checks the password.
+		command = append(command, "--auth=None")
+	}
+	mounts := []corev1.VolumeMount{}
+	if tc.tls != nil {
+		command = append(command,
+			"--tls-cert-file="+proxyTLSDir+"/"+tlsCertKey,
+			"--tls-key-file="+proxyTLSDir+"/"+tlsKeyKey)
+		mounts = append(mounts, corev1.VolumeMount{
+			Name:      "proxy-tls",
+			MountPath: proxyTLSDir,
+			ReadOnly:  true,
+		})
+		if tc.tls.clientCA != "" {
+			command = append(command, "--tls-client-ca-file="+proxyClientCADir+"/"+caCertKey)
+			mounts = append(mounts, corev1.VolumeMount{
+				Name:      "proxy-client-ca",
+				MountPath: proxyClientCADir,
+				ReadOnly:  true,
+			})
+		}
+	}
+	return []corev1.Container{
+		{
+			Name:            "rsync-proxy",
+			Image:           rsyncProxyImage,
+			ImagePullPolicy: corev1.PullAlways,
+			Command:         command,
+			SecurityContext: tc.security.containerSecurityContext(),
+			Ports: []corev1.ContainerPort{
+				{
+					Name:          "rsync-daemon",
+					ContainerPort: tc.security.port(),
+				},
+			},
+			VolumeMounts: mounts,
+		},
+	}
+}
+
+// proxyVolumes returns the certificate volumes of the proxy sidecar.
+func (tc *templateConfig) proxyVolumes() []corev1.Volume {
+	if tc.tls == nil {
+		return nil
+	}
+	volumes := []corev1.Volume{
+		{
+			Name: "proxy-tls",
+			VolumeSource: corev1.VolumeSource{
+				Secret: &corev1.SecretVolumeSource{
+					SecretName: tc.tls.secretName,
+					Items: []corev1.KeyToPath{
+						{Key: tlsCertKey, Path: tlsCertKey},
+						{Key: tlsKeyKey, Path: tlsKeyKey},
+					},
+				},
+			},
+		},
+	}
+	if tc.tls.clientCA != "" {
+		volumes = append(volumes, corev1.Volume{
+			Name: "proxy-client-ca",
+			VolumeSource: corev1.VolumeSource{
+				ConfigMap: &corev1.ConfigMapVolumeSource{
+					LocalObjectReference: corev1.LocalObjectReference{
+						Name: tc.tls.clientCA,
+					},
+					Items: []corev1.KeyToPath{
+						{Key: caCertKey, Path: caCertKey},
+					},
+				},
+			},
+		})
+	}
+	return volumes
+}
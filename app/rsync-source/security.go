@@ -0,0 +1,116 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+const (
+	// runAsUserAnnotation runs the daemon as the given non-root user, in a
+	// pod that complies with the Pod Security "restricted" profile. Without
+	// it the daemon runs as root, which is required for hostPath sources.
+	runAsUserAnnotation = "demo.io/run-as-user"
+	// runAsGroupAnnotation defaults to the user.
+	runAsGroupAnnotation = "demo.io/run-as-group"
+	// fsGroupAnnotation defaults to the group.
+	fsGroupAnnotation = "demo.io/fs-group"
+
+	// nonRootPort is the daemon port of a non-root daemon, which can't bind
+	// the privileged rsync port. The service still exposes 873.
+	nonRootPort = 8873
+	rootPort    = 873
+	// runDir is an emptyDir holding the pid and lock files of a
+	// non-root daemon, as its root filesystem is read only.
+	runDir        = "/run/rsyncd"
+	runVolumeName = "run"
+)
+
+// securityConfig is the identity the daemon runs with.
+type securityConfig struct {
+	nonRoot    bool
+	runAsUser  int64
+	runAsGroup int64
+	fsGroup    int64
+}
+
+func securityConfigFromAnnotations(annotations map[string]string, volumes []corev1.Volume) (securityConfig, error) {
+	sc := securityConfig{}
+	raw, ok := annotations[runAsUserAnnotation]
+	if !ok {
+		return sc, nil
+	}
+	for _, v := range volumes {
+		if v.HostPath != nil {
+			return sc, fmt.Errorf("`%s` can't be used with hostPath volumes, they are only served by a root daemon",
+				runAsUserAnnotation)
+		}
+	}
+	var err error
+	if sc.runAsUser, err = parseID(runAsUserAnnotation, raw); err != nil {
+		return sc, err
+	}
+	sc.runAsGroup = sc.runAsUser
+	if raw, ok := annotations[runAsGroupAnnotation]; ok {
+		if sc.runAsGroup, err = parseID(runAsGroupAnnotation, raw); err != nil {
+			return sc, err
+		}
+	}
+	sc.fsGroup = sc.runAsGroup
+	if raw, ok := annotations[fsGroupAnnotation]; ok {
+		if sc.fsGroup, err = parseID(fsGroupAnnotation, raw); err != nil {
+			return sc, err
+		}
+	}
+	sc.nonRoot = true
+	return sc, nil
+}
+
+func parseID(key, raw string) (int64, error) {
+	id, err := strconv.ParseInt(raw, 10, 64)
+	if err != nil || id <= 0 {
+		return 0, fmt.Errorf("invalid `%s` annotation `%s`, it must be a non-root id", key, raw)
+	}
+	return id, nil
+}
+
+func (sc securityConfig) port() int32 {
+	if sc.nonRoot {
+		return nonRootPort
+	}
+	return rootPort
+}
+
+func (sc securityConfig) podSecurityContext() *corev1.PodSecurityContext {
+	if !sc.nonRoot {
+		return nil
+	}
+	nonRoot := true
+	return &corev1.PodSecurityContext{
+		RunAsNonRoot: &nonRoot,
+		RunAsUser:    &sc.runAsUser,
+		RunAsGroup:   &sc.runAsGroup,
+		FSGroup:      &sc.fsGroup,
+		SeccompProfile: &corev1.SeccompProfile{
+			Type: corev1.SeccompProfileTypeRuntimeDefault,
+		},
+	}
+}
+
+func (sc securityConfig) containerSecurityContext() *corev1.SecurityContext {
+	if !sc.nonRoot {
+		return nil
+	}
+	nonRoot := true
+	allowPrivilegeEscalation := false
+	readOnlyRootFilesystem := true
+	return &corev1.SecurityContext{
+		RunAsNonRoot:             &nonRoot,
+		AllowPrivilegeEscalation: &allowPrivilegeEscalation,
+		ReadOnlyRootFilesystem:   &readOnlyRootFilesystem,
+		Capabilities: &corev1.Capabilities{
+			Drop: []corev1.Capability{"ALL"},
+		},
+	}
+}
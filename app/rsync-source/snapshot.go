@@ -0,0 +1,301 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+
+	internalv1 "github.com/k8s-volume-copy/types/apis/demo.io/v1"
+	"github.com/k8s-volume-copy/types/constant"
+)
+
+const (
+	// consistencyAnnotation selects how the claim of a rsync source is
+	// served. With `Snapshot` a CSI snapshot of the claim is served instead
+	// of the live claim, so files don't change during a copy.
+	consistencyAnnotation = "demo.io/consistency"
+	consistencySnapshot   = "Snapshot"
+	// volumeSnapshotClassAnnotation optionally sets the VolumeSnapshotClass
+	// of the snapshot. The default class of the driver is used otherwise.
+	volumeSnapshotClassAnnotation = "demo.io/volume-snapshot-class"
+	// snapshotTimeAnnotation reports when the served snapshot was taken.
+	snapshotTimeAnnotation = "demo.io/snapshot-time"
+	// snapshotFailuresAnnotation counts the failed snapshots since the last
+	// ready one. It sets the backoff before the next snapshot is taken.
+	snapshotFailuresAnnotation = "demo.io/snapshot-failures"
+
+	// snapshotReadyConditionType reports whether the snapshot of the claim
+	// is ready to be served.
+	snapshotReadyConditionType = "SnapshotReady"
+
+	snapshotGroup = "snapshot.storage.k8s.io"
+
+	// A failed snapshot is kept for snapshotRetryBaseDelay, doubled with
+	// every failure up to snapshotRetryMaxDelay, before it is deleted and
+	// taken again.
+	snapshotRetryBaseDelay = 30 * time.Second
+	snapshotRetryMaxDelay  = 30 * time.Minute
+)
+
+var volumeSnapshotGVR = schema.GroupVersionResource{
+	Group:    snapshotGroup,
+	Version:  "v1",
+	Resource: "volumesnapshots",
+}
+
+func isSnapshotConsistent(cr internalv1.RsyncSource) bool {
+	return cr.GetAnnotations()[consistencyAnnotation] == consistencySnapshot
+}
+
+// snapshotName is the name of the VolumeSnapshot and of the claim it is
+// restored to.
+func snapshotName(rsyncSourceName string) string {
+	return rsyncSourceName + "-snapshot"
+}
+
+func snapshotLabels(rsyncSourceName string) map[string]string {
+	return map[string]string{
+		constant.CreatedByLabel: constant.ComponentNameRsyncSourceController,
+		constant.NameLabel:      rsyncSourceName,
+	}
+}
+
+// snapshotFailures returns the number of failed snapshots of a rsync source
+// since the last ready one.
+func snapshotFailures(cr internalv1.RsyncSource) int {
+	failures, err := strconv.Atoi(cr.GetAnnotations()[snapshotFailuresAnnotation])
+	if err != nil || failures < 0 {
+		return 0
+	}
+	return failures
+}
+
+// snapshotRetryDelay is the time a failed snapshot is kept before it is
+// taken again.
+func snapshotRetryDelay(failures int) time.Duration {
+	delay := snapshotRetryBaseDelay
+	for i := 0; i < failures && delay < snapshotRetryMaxDelay; i++ {
+		delay *= 2
+	}
+	if delay > snapshotRetryMaxDelay {
+		return snapshotRetryMaxDelay
+	}
+	return delay
+}
+
+// snapshotRetryTime returns when a failed snapshot is taken again. The
+// backoff starts at the time of the error, or at the creation of the
+// snapshot if the driver didn't report one.
+func snapshotRetryTime(snapshot *unstructured.Unstructured, failures int) time.Time {
+	failed := snapshot.GetCreationTimestamp().Time
+	if raw, found, _ := unstructured.NestedString(snapshot.Object, "status", "error", "time"); found {
+		if t, err := time.Parse(time.RFC3339, raw); err == nil {
+			failed = t
+		}
+	}
+	return failed.Add(snapshotRetryDelay(failures))
+}
+
+// ensureSnapshotVolume takes a snapshot of the claim of a rsync source and
+// restores it to a temporary claim. It returns the read only volume of the
+// restored claim, or nil and the time to wait while the snapshot isn't
+// ready yet. A failed snapshot is deleted and taken again with backoff.
+func (c *controller) ensureSnapshotVolume(ctx context.Context, cr internalv1.RsyncSource) (*corev1.Volume, time.Duration, error) {
+	if cr.Spec.Volume.PersistentVolumeClaim == nil {
+		return nil, 0, fmt.Errorf("`%s: %s` requires a persistentVolumeClaim volume",
+			consistencyAnnotation, consistencySnapshot)
+	}
+	name := snapshotName(cr.GetName())
+	snapshot, err := c.dynamicClient.Resource(volumeSnapshotGVR).Namespace(cr.GetNamespace()).
+		Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		if !errors.IsNotFound(err) {
+			return nil, 0, err
+		}
+		if _, err := c.dynamicClient.Resource(volumeSnapshotGVR).Namespace(cr.GetNamespace()).
+			Create(ctx, getVolumeSnapshotTemplate(cr), metav1.CreateOptions{}); err != nil {
+			return nil, 0, err
+		}
+		return nil, snapshotPollInterval, c.setRsyncSourceStatus(ctx, cr.GetNamespace(), cr.GetName(), map[string]*string{
+			snapshotTimeAnnotation: nil,
+		}, metav1.Condition{
+			Type:    snapshotReadyConditionType,
+			Status:  metav1.ConditionFalse,
+			Reason:  "SnapshotCreated",
+			Message: fmt.Sprintf("volume snapshot `%s` created", name),
+		})
+	}
+	if snapshot.GetLabels()[constant.CreatedByLabel] != constant.ComponentNameRsyncSourceController {
+		return nil, 0, fmt.Errorf("resource found but not created by this operator")
+	}
+	if message, found, _ := unstructured.NestedString(snapshot.Object, "status", "error", "message"); found {
+		failures := snapshotFailures(cr)
+		retryTime := snapshotRetryTime(snapshot, failures)
+		if wait := time.Until(retryTime); wait > 0 {
+			return nil, wait, c.setSnapshotFailed(ctx, cr, fmt.Sprintf("volume snapshot `%s` failed: %s, "+
+				"a new snapshot is taken at %s", name, message, retryTime.UTC().Format(time.RFC3339)), nil)
+		}
+		err := c.dynamicClient.Resource(volumeSnapshotGVR).Namespace(cr.GetNamespace()).
+			Delete(ctx, name, metav1.DeleteOptions{})
+		if err != nil && !errors.IsNotFound(err) {
+			return nil, 0, err
+		}
+		count := strconv.Itoa(failures + 1)
+		return nil, snapshotPollInterval, c.setSnapshotFailed(ctx, cr, fmt.Sprintf("volume snapshot `%s` failed: %s, "+
+			"taking a new snapshot", name, message), &count)
+	}
+	if ready, _, _ := unstructured.NestedBool(snapshot.Object, "status", "readyToUse"); !ready {
+		return nil, snapshotPollInterval, nil
+	}
+
+	pvc, err := c.kubeClient.CoreV1().PersistentVolumeClaims(cr.GetNamespace()).
+		Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		if !errors.IsNotFound(err) {
+			return nil, 0, err
+		}
+		source, err := c.kubeClient.CoreV1().PersistentVolumeClaims(cr.GetNamespace()).
+			Get(ctx, cr.Spec.Volume.PersistentVolumeClaim.ClaimName, metav1.GetOptions{})
+		if err != nil {
+			return nil, 0, err
+		}
+		restoreSize := source.Spec.Resources.Requests[corev1.ResourceStorage]
+		if raw, found, _ := unstructured.NestedString(snapshot.Object, "status", "restoreSize"); found {
+			if size, err := resource.ParseQuantity(raw); err == nil && size.Cmp(restoreSize) > 0 {
+				restoreSize = size
+			}
+		}
+		if pvc, err = c.kubeClient.CoreV1().PersistentVolumeClaims(cr.GetNamespace()).
+			Create(ctx, getRestoreClaimTemplate(cr, source, restoreSize), metav1.CreateOptions{}); err != nil {
+			return nil, 0, err
+		}
+	}
+	if pvc.GetLabels()[constant.CreatedByLabel] != constant.ComponentNameRsyncSourceController {
+		return nil, 0, fmt.Errorf("resource found but not created by this operator")
+	}
+
+	snapshotTime, _, _ := unstructured.NestedString(snapshot.Object, "status", "creationTime")
+	if err := c.setSnapshotStatus(ctx, cr.GetNamespace(), cr.GetName(), &snapshotTime, metav1.Condition{
+		Type:    snapshotReadyConditionType,
+		Status:  metav1.ConditionTrue,
+		Reason:  "SnapshotReady",
+		Message: fmt.Sprintf("serving volume snapshot `%s` taken at %s", name, snapshotTime),
+	}); err != nil {
+		return nil, 0, err
+	}
+	return &corev1.Volume{
+		Name: cr.Spec.Volume.Name,
+		VolumeSource: corev1.VolumeSource{
+			PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{
+				ClaimName: pvc.GetName(),
+				ReadOnly:  true,
+			},
+		},
+	}, 0, nil
+}
+
+// deleteSnapshotVolume deletes the restored claim and the snapshot of a
+// rsync source.
+func (c *controller) deleteSnapshotVolume(ctx context.Context, cr internalv1.RsyncSource) error {
+	name := snapshotName(cr.GetName())
+	err := c.kubeClient.CoreV1().PersistentVolumeClaims(cr.GetNamespace()).
+		Delete(ctx, name, metav1.DeleteOptions{})
+	if err != nil && !errors.IsNotFound(err) {
+		return err
+	}
+	err = c.dynamicClient.Resource(volumeSnapshotGVR).Namespace(cr.GetNamespace()).
+		Delete(ctx, name, metav1.DeleteOptions{})
+	if err != nil && !errors.IsNotFound(err) {
+		return err
+	}
+	return nil
+}
+
+func getVolumeSnapshotTemplate(cr internalv1.RsyncSource) *unstructured.Unstructured {
+	labels := map[string]interface{}{}
+	for k, v := range snapshotLabels(cr.GetName()) {
+		labels[k] = v
+	}
+	spec := map[string]interface{}{
+		"source": map[string]interface{}{
+			"persistentVolumeClaimName": cr.Spec.Volume.PersistentVolumeClaim.ClaimName,
+		},
+	}
+	if class, ok := cr.GetAnnotations()[volumeSnapshotClassAnnotation]; ok {
+		spec["volumeSnapshotClassName"] = class
+	}
+	return &unstructured.Unstructured{
+		Object: map[string]interface{}{
+			"apiVersion": snapshotGroup + "/v1",
+			"kind":       "VolumeSnapshot",
+			"metadata": map[string]interface{}{
+				"name":   snapshotName(cr.GetName()),
+				"labels": labels,
+			},
+			"spec": spec,
+		},
+	}
+}
+
+func getRestoreClaimTemplate(cr internalv1.RsyncSource, source *corev1.PersistentVolumeClaim, size resource.Quantity) *corev1.PersistentVolumeClaim {
+	apiGroup := snapshotGroup
+	pvc := corev1.PersistentVolumeClaim{
+		TypeMeta: metav1.TypeMeta{
+			Kind:       "PersistentVolumeClaim",
+			APIVersion: "v1",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:   snapshotName(cr.GetName()),
+			Labels: snapshotLabels(cr.GetName()),
+		},
+		Spec: corev1.PersistentVolumeClaimSpec{
+			AccessModes: source.Spec.AccessModes,
+			Resources: corev1.ResourceRequirements{
+				Requests: corev1.ResourceList{
+					corev1.ResourceStorage: size,
+				},
+			},
+			StorageClassName: source.Spec.StorageClassName,
+			VolumeMode:       source.Spec.VolumeMode,
+			DataSource: &corev1.TypedLocalObjectReference{
+				APIGroup: &apiGroup,
+				Kind:     "VolumeSnapshot",
+				Name:     snapshotName(cr.GetName()),
+			},
+		},
+	}
+	return &pvc
+}
+
+// setSnapshotStatus sets the snapshot time annotation, or removes it if
+// snapshotTime is nil, and a condition on a rsync source. The failure
+// count is reset.
+func (c *controller) setSnapshotStatus(ctx context.Context, namespace, name string, snapshotTime *string, condition metav1.Condition) error {
+	return c.setRsyncSourceStatus(ctx, namespace, name, map[string]*string{
+		snapshotTimeAnnotation:     snapshotTime,
+		snapshotFailuresAnnotation: nil,
+	}, condition)
+}
+
+// setSnapshotFailed reports a failed snapshot of a rsync source. The failure
+// count is only updated if failures is set.
+func (c *controller) setSnapshotFailed(ctx context.Context, cr internalv1.RsyncSource, message string, failures *string) error {
+	values := map[string]*string{snapshotTimeAnnotation: nil}
+	if failures != nil {
+		values[snapshotFailuresAnnotation] = failures
+	}
+	return c.setRsyncSourceStatus(ctx, cr.GetNamespace(), cr.GetName(), values, metav1.Condition{
+		Type:    snapshotReadyConditionType,
+		Status:  metav1.ConditionFalse,
+		Reason:  "SnapshotFailed",
+		Message: message,
+	})
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+
+	internalv1 "github.com/k8s-volume-copy/types/apis/demo.io/v1"
+)
+
+func TestSnapshotFailures(t *testing.T) {
+	tests := []struct {
+		name  string
+		value *string
+		want  int
+	}{
+		{name: "unset", want: 0},
+		{name: "counted", value: stringPtr("3"), want: 3},
+		{name: "invalid", value: stringPtr("many"), want: 0},
+		{name: "negative", value: stringPtr("-1"), want: 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cr := internalv1.RsyncSource{}
+			if tt.value != nil {
+				cr.SetAnnotations(map[string]string{snapshotFailuresAnnotation: *tt.value})
+			}
+			if got := snapshotFailures(cr); got != tt.want {
+				t.Errorf("snapshotFailures() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSnapshotRetryDelay(t *testing.T) {
+	tests := []struct {
+		name     string
+		failures int
+		want     time.Duration
+	}{
+		{name: "first failure", failures: 0, want: 30 * time.Second},
+		{name: "doubled", failures: 2, want: 2 * time.Minute},
+		{name: "capped", failures: 6, want: 30 * time.Minute},
+		{name: "many failures", failures: 1000, want: 30 * time.Minute},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := snapshotRetryDelay(tt.failures); got != tt.want {
+				t.Errorf("snapshotRetryDelay() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSnapshotRetryTime(t *testing.T) {
+	created := time.Date(2026, 10, 18, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name      string
+		errorTime string
+		failures  int
+		want      time.Time
+	}{
+		{
+			name:      "from the error",
+			errorTime: "2026-10-18T12:05:00Z",
+			failures:  1,
+			want:      created.Add(5*time.Minute + time.Minute),
+		},
+		{
+			name:     "from the creation without error time",
+			failures: 0,
+			want:     created.Add(30 * time.Second),
+		},
+		{
+			name:      "from the creation with invalid error time",
+			errorTime: "yesterday",
+			failures:  0,
+			want:      created.Add(30 * time.Second),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			snapshot := &unstructured.Unstructured{Object: map[string]interface{}{}}
+			snapshot.SetCreationTimestamp(metav1.NewTime(created))
+			snapshotError := map[string]interface{}{"message": "failed to take snapshot"}
+			if tt.errorTime != "" {
+				snapshotError["time"] = tt.errorTime
+			}
+			if err := unstructured.SetNestedMap(snapshot.Object, snapshotError, "status", "error"); err != nil {
+				t.Fatal(err)
+			}
+			if got := snapshotRetryTime(snapshot, tt.failures); !got.Equal(tt.want) {
+				t.Errorf("snapshotRetryTime() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,358 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/pem"
+	"fmt"
+	"strings"
+	"text/template"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+
+	internalv1 "github.com/k8s-volume-copy/types/apis/demo.io/v1"
+	"github.com/k8s-volume-copy/types/constant"
+)
+
+const (
+	// transportAnnotation selects how clients reach the rsync backend. With
+	// `SSH` an SSH server restricted to rsync is run instead of the daemon,
+	// so clients use `rsync -e ssh root@<source>::<module>`.
+	transportAnnotation = "demo.io/transport"
+	transportRsync      = "Rsync"
+	transportSSH        = "SSH"
+	// sshAuthorizedKeysAnnotation is a comma separated list of Secrets with
+	// an `authorized_keys` key. Their keys may log in with the SSH transport
+	// or the SFTP backend.
+	sshAuthorizedKeysAnnotation = "demo.io/ssh-authorized-keys"
+	// sshKnownHostsAnnotation publishes the known_hosts line of the host key.
+	sshKnownHostsAnnotation = "demo.io/ssh-known-hosts"
+
+	authorizedKeysKey = "authorized_keys"
+	hostKeyKey        = "ssh_host_ecdsa_key"
+	hostKeyPubKey     = "ssh_host_ecdsa_key.pub"
+
+	sshPort          = 22
+	sshHostKeyDir    = "/etc/ssh/host-keys"
+	sshAuthorizedDir = "/etc/ssh/authorized-keys"
+)
+
+// sshConfig is the SSH server of a source.
+type sshConfig struct {
+	authorizedKeySecrets []string
+}
+
+func isSSHTransport(annotations map[string]string) (bool, error) {
+	switch transport := annotations[transportAnnotation]; transport {
+	case "", transportRsync:
+		return false, nil
+	case transportSSH:
+		return true, nil
+	default:
+		return false, fmt.Errorf("invalid `%s` annotation `%s`, expected `%s` or `%s`",
+			transportAnnotation, transport, transportRsync, transportSSH)
+	}
+}
+
+func sshConfigFromAnnotations(annotations map[string]string) (*sshConfig, error) {
+	sc := &sshConfig{}
+	for _, name := range strings.Split(annotations[sshAuthorizedKeysAnnotation], ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			sc.authorizedKeySecrets = append(sc.authorizedKeySecrets, name)
+		}
+	}
+	if len(sc.authorizedKeySecrets) == 0 {
+		return nil, fmt.Errorf("SSH requires the `%s` annotation", sshAuthorizedKeysAnnotation)
+	}
+	for _, key := range []string{authAnnotation, tlsAnnotation, runAsUserAnnotation} {
+		if _, ok := annotations[key]; ok {
+			return nil, fmt.Errorf("SSH can't be combined with `%s`", key)
+		}
+	}
+	return sc, nil
+}
+
+// sshHostKeySecretName is the controller owned Secret with the host key.
+func sshHostKeySecretName(rsyncSourceName string) string {
+	return rsyncSourceName + "-ssh-host-key"
+}
+
+// sshdConfigTemplate only allows the forced command, either the rsync
+// daemon protocol or SFTP.
+var sshdConfigTemplate = template.Must(template.New("sshd_config").Parse(`
+Port {{ .Port }}
+HostKey {{ .HostKeyDir }}/` + hostKeyKey + `
+AuthorizedKeysFile{{ range .AuthorizedKeysFiles }} {{ . }}{{ end }}
+PermitRootLogin prohibit-password
+PasswordAuthentication no
+KbdInteractiveAuthentication no
+StrictModes no
+AllowAgentForwarding no
+AllowTcpForwarding no
+AllowStreamLocalForwarding no
+GatewayPorts no
+PermitTunnel no
+PermitTTY no
+PermitUserRC no
+PermitUserEnvironment no
+X11Forwarding no
+{{- if .ChrootDirectory }}
+ChrootDirectory {{ .ChrootDirectory }}
+Subsystem sftp internal-sftp
+{{- end }}
+ForceCommand {{ .ForceCommand }}
+`))
+
+type sshdConfig struct {
+	Port                int
+	HostKeyDir          string
+	AuthorizedKeysFiles []string
+	ChrootDirectory     string
+	ForceCommand        string
+}
+
+// renderSSHDConfig renders the sshd_config of a source. SFTP sessions are
+// locked into chroot.
+func (tc *templateConfig) renderSSHDConfig(forceCommand, chroot string) (string, error) {
+	conf := sshdConfig{
+		Port:            sshPort,
+		HostKeyDir:      sshHostKeyDir,
+		ChrootDirectory: chroot,
+		ForceCommand:    forceCommand,
+	}
+	for _, name := range tc.ssh.authorizedKeySecrets {
+		conf.AuthorizedKeysFiles = append(conf.AuthorizedKeysFiles, sshAuthorizedDir+"/"+name+"/"+authorizedKeysKey)
+	}
+	buf := &bytes.Buffer{}
+	if err := sshdConfigTemplate.Execute(buf, conf); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+// sshContainers returns the SSH server serving the given mounts.
+func (tc *templateConfig) sshContainers(mounts []corev1.VolumeMount) []corev1.Container {
+	mounts = append(mounts,
+		configMount("sshd_config", "/etc/ssh/sshd_config"),
+		corev1.VolumeMount{
+			Name:      "ssh-host-key",
+			MountPath: sshHostKeyDir,
+			ReadOnly:  true,
+		})
+	for i, name := range tc.ssh.authorizedKeySecrets {
+		mounts = append(mounts, corev1.VolumeMount{
+			Name:      fmt.Sprintf("ssh-authorized-keys-%d", i),
+			MountPath: sshAuthorizedDir + "/" + name,
+			ReadOnly:  true,
+		})
+	}
+	return []corev1.Container{
+		{
+			Name:            "rsync-sshd",
+			Image:           rsyncSSHDImage,
+			ImagePullPolicy: corev1.PullAlways,
+			Command:         []string{"/usr/sbin/sshd", "-D", "-e", "-f", "/etc/ssh/sshd_config"},
+			Ports: []corev1.ContainerPort{
+				{
+					Name:          "ssh",
+					ContainerPort: sshPort,
+				},
+			},
+			VolumeMounts: mounts,
+		},
+	}
+}
+
+// sshVolumes returns the host key and authorized keys volumes.
+func (tc *templateConfig) sshVolumes() []corev1.Volume {
+	if tc.ssh == nil {
+		return nil
+	}
+	privateMode := int32(0400)
+	volumes := []corev1.Volume{
+		{
+			Name: "ssh-host-key",
+			VolumeSource: corev1.VolumeSource{
+				Secret: &corev1.SecretVolumeSource{
+					SecretName:  sshHostKeySecretName(tc.name),
+					DefaultMode: &privateMode,
+				},
+			},
+		},
+	}
+	for i, name := range tc.ssh.authorizedKeySecrets {
+		volumes = append(volumes, corev1.Volume{
+			Name: fmt.Sprintf("ssh-authorized-keys-%d", i),
+			VolumeSource: corev1.VolumeSource{
+				Secret: &corev1.SecretVolumeSource{
+					SecretName: name,
+					Items: []corev1.KeyToPath{
+						{Key: authorizedKeysKey, Path: authorizedKeysKey},
+					},
+				},
+			},
+		})
+	}
+	return volumes
+}
+
+// rsyncSSHBackend serves the rsync daemon protocol over SSH, the SSH server
+// runs the daemon for every connection.
+type rsyncSSHBackend struct {
+	tc *templateConfig
+}
+
+func (b *rsyncSSHBackend) configFiles() (map[string]string, error) {
+	command := "rsync --server --daemon --config=/etc/rsyncd.conf ."
+	if b.tc.bwlimit != "" {
+		command = "rsync --server --daemon --config=/etc/rsyncd.conf --bwlimit=" + b.tc.bwlimit + " ."
+	}
+	sshdConf, err := b.tc.renderSSHDConfig(command, "")
+	if err != nil {
+		return nil, err
+	}
+	return map[string]string{
+		"rsyncd.conf": b.tc.rsyncdConf,
+		"sshd_config": sshdConf,
+	}, nil
+}
+
+func (b *rsyncSSHBackend) containers() []corev1.Container {
+	mounts := append(append(b.tc.volumeMounts(), b.tc.daemonLogMounts()...), configMount("rsyncd.conf", "/etc/rsyncd.conf"))
+	return append(b.tc.sshContainers(mounts), b.tc.logShipperContainers()...)
+}
+
+func (b *rsyncSSHBackend) volumes() []corev1.Volume {
+	return append(b.tc.sshVolumes(), b.tc.logShipperVolumes()...)
+}
+
+func (b *rsyncSSHBackend) servicePorts() []corev1.ServicePort {
+	return sshServicePorts()
+}
+
+// sftpBackend serves the volumes with SFTP below serveRoot. Read only
+// volumes are mounted read only.
+type sftpBackend struct {
+	tc *templateConfig
+}
+
+func (b *sftpBackend) configFiles() (map[string]string, error) {
+	sshdConf, err := b.tc.renderSSHDConfig("internal-sftp", serveRoot)
+	if err != nil {
+		return nil, err
+	}
+	return map[string]string{
+		"sshd_config": sshdConf,
+	}, nil
+}
+
+func (b *sftpBackend) containers() []corev1.Container {
+	return b.tc.sshContainers(b.tc.servedMounts(serveRoot))
+}
+
+func (b *sftpBackend) volumes() []corev1.Volume {
+	return b.tc.sshVolumes()
+}
+
+func (b *sftpBackend) servicePorts() []corev1.ServicePort {
+	return sshServicePorts()
+}
+
+func sshServicePorts() []corev1.ServicePort {
+	return []corev1.ServicePort{
+		{
+			Name:       "ssh",
+			Port:       sshPort,
+			TargetPort: intstr.FromString("ssh"),
+			Protocol:   corev1.ProtocolTCP,
+		},
+	}
+}
+
+// ensureSSHHostKey creates the host key of a source and publishes it as
+// known_hosts line.
+func (c *controller) ensureSSHHostKey(ctx context.Context, cr internalv1.RsyncSource) error {
+	name := sshHostKeySecretName(cr.GetName())
+	secret, err := c.kubeClient.CoreV1().Secrets(cr.GetNamespace()).Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		if !errors.IsNotFound(err) {
+			return err
+		}
+		if secret, err = c.createSSHHostKey(ctx, cr, name); err != nil {
+			return err
+		}
+	}
+	if secret.GetLabels()[constant.CreatedByLabel] != constant.ComponentNameRsyncSourceController {
+		return fmt.Errorf("secret `%s` found but not created by this operator", name)
+	}
+	hosts := strings.Join(dnsNames(cr), ",")
+	knownHosts := hosts + " " + strings.TrimSpace(string(secret.Data[hostKeyPubKey]))
+	return c.setRsyncSourceStatus(ctx, cr.GetNamespace(), cr.GetName(), map[string]*string{
+		sshKnownHostsAnnotation: &knownHosts,
+	})
+}
+
+func (c *controller) createSSHHostKey(ctx context.Context, cr internalv1.RsyncSource, name string) (*corev1.Secret, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	der, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:   name,
+			Labels: snapshotLabels(cr.GetName()),
+		},
+		Type: corev1.SecretTypeOpaque,
+		Data: map[string][]byte{
+			hostKeyKey:    pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der}),
+			hostKeyPubKey: []byte(marshalAuthorizedKey(&key.PublicKey) + "\n"),
+		},
+	}
+	return c.kubeClient.CoreV1().Secrets(cr.GetNamespace()).Create(ctx, secret, metav1.CreateOptions{})
+}
+
+// deleteSSHHostKey removes the host key of a source.
+func (c *controller) deleteSSHHostKey(ctx context.Context, cr internalv1.RsyncSource) error {
+	name := sshHostKeySecretName(cr.GetName())
+	secret, err := c.kubeClient.CoreV1().Secrets(cr.GetNamespace()).Get(ctx, name, metav1.GetOptions{})
+	if err != nil && !errors.IsNotFound(err) {
+		return err
+	}
+	if err != nil || secret.GetLabels()[constant.CreatedByLabel] != constant.ComponentNameRsyncSourceController {
+		return nil
+	}
+	err = c.kubeClient.CoreV1().Secrets(cr.GetNamespace()).Delete(ctx, name, metav1.DeleteOptions{})
+	if errors.IsNotFound(err) {
+		return nil
+	}
+	return err
+}
+
+// marshalAuthorizedKey encodes a P-256 public key in the authorized_keys
+// format, see RFC 5656.
+func marshalAuthorizedKey(key *ecdsa.PublicKey) string {
+	const keyType = "ecdsa-sha2-nistp256"
+	buf := &bytes.Buffer{}
+	for _, field := range [][]byte{
+		[]byte(keyType),
+		[]byte("nistp256"),
+		elliptic.Marshal(elliptic.P256(), key.X, key.Y),
+	} {
+		binary.Write(buf, binary.BigEndian, uint32(len(field)))
+		buf.Write(field)
+	}
+	return keyType + " " + base64.StdEncoding.EncodeToString(buf.Bytes())
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/x509"
+	"encoding/pem"
+	"testing"
+)
+
+func TestMarshalAuthorizedKey(t *testing.T) {
+	// The keys were generated with ssh-keygen, which printed the
+	// authorized key and, with `-e -m PKCS8`, the public key.
+	tests := []struct {
+		name      string
+		publicKey string
+		want      string
+	}{
+		{
+			name: "first key",
+			publicKey: `-----BEGIN PUBLIC KEY-----
+MFkwEwYHKoZIzj0CAQYIKoZIzj0DAQcDQgAE2T0vasAfrgsoV2cwb/6gmOiUqHQA
+NWL44i0NuxsN64A5Pr1rB0QnfxYS6sFYl54qP8h0actUf+h1OPVaYGh2yg==
+-----END PUBLIC KEY-----`,
+			want: "ecdsa-sha2-nistp256 AAAAE2VjZHNhLXNoYTItbmlzdHAyNTYAAAAIbmlzdHAyNTYAAABBBNk9L2rAH64LKFdnMG/+oJjolKh0ADVi+OItDbsbDeuAOT69awdEJ38WEurBWJeeKj/IdGnLVH/odTj1WmBodso=",
+		},
+		{
+			name: "second key",
+			publicKey: `-----BEGIN PUBLIC KEY-----
+MFkwEwYHKoZIzj0CAQYIKoZIzj0DAQcDQgAEnehP2Asa1aCX/DuedqR4T/U4TaNq
+nrqFKrsxxG8yQLVx2ccIw6En9IJQXFA6eRDlueT5bZfvgAdJxycw3e1GOA==
+-----END PUBLIC KEY-----`,
+			want: "ecdsa-sha2-nistp256 AAAAE2VjZHNhLXNoYTItbmlzdHAyNTYAAAAIbmlzdHAyNTYAAABBBJ3oT9gLGtWgl/w7nnakeE/1OE2jap66hSq7McRvMkC1cdnHCMOhJ/SCUFxQOnkQ5bnk+W2X74AHSccnMN3tRjg=",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			block, _ := pem.Decode([]byte(tt.publicKey))
+			if block == nil {
+				t.Fatalf("no PEM block")
+			}
+			key, err := x509.ParsePKIXPublicKey(block.Bytes)
+			if err != nil {
+				t.Fatalf("ParsePKIXPublicKey() error = %v", err)
+			}
+			if got := marshalAuthorizedKey(key.(*ecdsa.PublicKey)); got != tt.want {
+				t.Errorf("marshalAuthorizedKey() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"context"
+	"reflect"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	internalv1 "github.com/k8s-volume-copy/types/apis/demo.io/v1"
+	"github.com/k8s-volume-copy/volume-source/pkg/conditions"
+)
+
+const (
+	// configValidConditionType reports whether the annotations of a rsync
+	// source could be rendered into a daemon configuration.
+	configValidConditionType = "ConfigValid"
+)
+
+// setRsyncSourceStatus sets status annotations and conditions on a rsync
+// source. An annotation with a nil value is removed. The source is read
+// again, so the update doesn't conflict with earlier updates of the sync.
+func (c *controller) setRsyncSourceStatus(ctx context.Context, namespace, name string, values map[string]*string, conds ...metav1.Condition) error {
+	obj, err := c.dynamicClient.Resource(rsyncSourceGVR).Namespace(namespace).
+		Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		return err
+	}
+	annotations := obj.GetAnnotations()
+	updated, _, err := conditions.Set(annotations, conds...)
+	if err != nil {
+		return err
+	}
+	for k, v := range values {
+		if v == nil {
+			delete(updated, k)
+		} else {
+			updated[k] = *v
+		}
+	}
+	if reflect.DeepEqual(annotations, updated) || (len(annotations) == 0 && len(updated) == 0) {
+		return nil
+	}
+	obj.SetAnnotations(updated)
+	_, err = c.dynamicClient.Resource(rsyncSourceGVR).Namespace(namespace).
+		Update(ctx, obj, metav1.UpdateOptions{})
+	return err
+}
+
+// setRsyncSourceCondition sets a condition that is reported on every sync.
+// The cached source is checked first, so the source is only read again
+// when the condition changed.
+func (c *controller) setRsyncSourceCondition(ctx context.Context, cr internalv1.RsyncSource, condition metav1.Condition) error {
+	if _, changed, err := conditions.Set(cr.GetAnnotations(), condition); err != nil || !changed {
+		return err
+	}
+	return c.setRsyncSourceStatus(ctx, cr.GetNamespace(), cr.GetName(), nil, condition)
+}
@@ -1,6 +1,14 @@
 package main
 
 import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"strings"
+	"text/template"
+	"time"
+
 	appsv1 "k8s.io/api/apps/v1"
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
@@ -9,21 +17,333 @@ import (
 	"github.com/k8s-volume-copy/types/constant"
 )
 
+const (
+	// configHashAnnotation is added to the pod template of the daemon. It
+	// changes whenever the rendered config or the served volumes change so
+	// that the deployment is recreated with the new configuration.
+	configHashAnnotation = "demo.io/config-hash"
+	// credentialsVersionAnnotation is the resourceVersion of the credentials
+	// Secret, which the servers read when they start. The credentials
+	// aren't part of the config hash, which anyone reading the deployment
+	// can see.
+	credentialsVersionAnnotation = "demo.io/credentials-version"
+
+	credentialsUsernameKey = "username"
+	credentialsPasswordKey = "password"
+	// credentialsSecretsFileKey holds the rsyncd secrets file, which is
+	// mounted in secretsDir when the daemon is started without the
+	// entrypoint of the image.
+	credentialsSecretsFileKey = "rsyncd.secrets"
+	secretsDir                = "/etc/rsyncd-secrets"
+	secretsVolumeName         = "secrets"
+)
+
 type templateConfig struct {
-	name      string
-	namespace string
-	rsync     internalv1.RsyncSourceSpec
+	name       string
+	namespace  string
+	rsync      internalv1.RsyncSourceSpec
+	modules    []module
+	snapshot   bool
+	readWrite  bool
+	options    map[string]moduleOptions
+	bwlimit    string
+	security   securityConfig
+	proxy      *proxyConfig
+	tls        *tlsConfig
+	ssh        *sshConfig
+	logShipper bool
+	drainGrace time.Duration
+	lifecycle  lifecycleConfig
+	activator  *activatorConfig
+	backend    backend
+	rsyncdConf string
+	files      map[string]string
+	configHash string
 }
 
+// templateConfigFromRsyncSource parses the settings of a rsync source. The
+// RsyncSource type is defined in the shared types module, so every setting
+// beyond its spec is a `demo.io/*` annotation.
 func templateConfigFromRsyncSource(cr internalv1.RsyncSource) (*templateConfig, error) {
+	modules, err := modulesFromAnnotations(cr.GetAnnotations())
+	if err != nil {
+		return nil, err
+	}
+	readWrite, err := isReadWrite(cr)
+	if err != nil {
+		return nil, err
+	}
+	options, err := moduleOptionsFromAnnotations(cr.GetAnnotations(), modules)
+	if err != nil {
+		return nil, err
+	}
+	bwlimit, err := bwlimitFromAnnotations(cr.GetAnnotations())
+	if err != nil {
+		return nil, err
+	}
 	tc := &templateConfig{
 		name:      cr.GetName(),
 		namespace: cr.GetNamespace(),
 		rsync:     cr.Spec,
+		modules:   modules,
+		snapshot:  isSnapshotConsistent(cr),
+		readWrite: readWrite,
+		options:   options,
+		bwlimit:   bwlimit,
+	}
+	if tc.security, err = securityConfigFromAnnotations(cr.GetAnnotations(), tc.volumes()); err != nil {
+		return nil, err
+	}
+	if tc.proxy, err = proxyConfigFromAnnotations(cr.GetAnnotations()); err != nil {
+		return nil, err
+	}
+	if tc.tls, err = tlsConfigFromAnnotations(tc.name, cr.GetAnnotations()); err != nil {
+		return nil, err
+	}
+	sshTransport, err := isSSHTransport(cr.GetAnnotations())
+	if err != nil {
+		return nil, err
+	}
+	if sshTransport {
+		if tc.ssh, err = sshConfigFromAnnotations(cr.GetAnnotations()); err != nil {
+			return nil, err
+		}
+	}
+	if tc.logShipper, err = logShipperFromAnnotations(cr.GetAnnotations()); err != nil {
+		return nil, err
+	}
+	if tc.drainGrace, err = drainGracePeriodFromAnnotations(cr.GetAnnotations()); err != nil {
+		return nil, err
+	}
+	// The availability schedule is applied to the replicas before, it is
+	// only validated here.
+	if _, err := availabilityFromAnnotations(cr.GetAnnotations()); err != nil {
+		return nil, err
+	}
+	if tc.backend, tc.ssh, err = backendFromAnnotations(tc, cr.GetAnnotations()); err != nil {
+		return nil, err
+	}
+	// The idle timeout needs the sessions of the log shipper.
+	if tc.lifecycle, err = lifecycleConfigFromAnnotations(cr.GetAnnotations(), tc.logShipper); err != nil {
+		return nil, err
+	}
+	if tc.activator, err = activatorConfigFromAnnotations(cr.GetAnnotations(), &tc.lifecycle); err != nil {
+		return nil, err
+	}
+	if err := tc.render(); err != nil {
+		return nil, err
 	}
 	return tc, nil
 }
 
+// releaseTemplateConfig is the config of a deleted source whose annotations
+// are invalid. Its templates only name the resources that are removed.
+func releaseTemplateConfig(cr internalv1.RsyncSource) *templateConfig {
+	tc := &templateConfig{
+		name:      cr.GetName(),
+		namespace: cr.GetNamespace(),
+		rsync:     cr.Spec,
+	}
+	tc.backend = &rsyncBackend{tc: tc}
+	return tc
+}
+
+// render generates the config files of the backend and the config hash of
+// the source.
+func (tc *templateConfig) render() error {
+	conf := rsyncdConfig{
+		UID:         0,
+		GID:         0,
+		UseChroot:   true,
+		Port:        tc.security.port(),
+		RunDir:      "/var/run",
+		SecretsFile: tc.secretsFile(),
+		Auth:        true,
+		Modules: []rsyncdModule{
+			{
+				Name:     dataModuleName,
+				Path:     "/data",
+				ReadOnly: !tc.readWrite,
+				Options:  tc.options[dataModuleName],
+			},
+		},
+	}
+	if tc.security.nonRoot {
+		// A non-root daemon can't change its identity or chroot.
+		conf.UID = tc.security.runAsUser
+		conf.GID = tc.security.runAsGroup
+		conf.UseChroot = false
+		conf.RunDir = runDir
+	}
+	if tc.hasProxy() {
+		// The daemon only accepts connections from the proxy.
+		conf.Address = proxyBackendHost
+		conf.Port = proxyBackendPort
+	}
+	if tc.proxy != nil || tc.ssh != nil {
+		// Clients are authenticated by the proxy or the SSH server.
+		conf.Auth = false
+	}
+	if tc.logShipper {
+		conf.LogFile = transferLogFile
+		conf.LogFormat = transferLogFormat
+	}
+	for _, m := range tc.modules {
+		conf.Modules = append(conf.Modules, rsyncdModule{
+			Name:     m.Name,
+			Path:     m.mountPath(),
+			ReadOnly: m.ReadOnly,
+			Options:  tc.options[m.Name],
+		})
+	}
+	buf := &bytes.Buffer{}
+	if err := rsyncdConfigTemplate.Execute(buf, conf); err != nil {
+		return err
+	}
+	tc.rsyncdConf = buf.String()
+	files, err := tc.backend.configFiles()
+	if err != nil {
+		return err
+	}
+	tc.files = files
+
+	volumes, err := json.Marshal(tc.volumes())
+	if err != nil {
+		return err
+	}
+	hash := sha256.New()
+	rawFiles, err := json.Marshal(tc.files)
+	if err != nil {
+		return err
+	}
+	hash.Write(rawFiles)
+	hash.Write(volumes)
+	hash.Write([]byte(tc.bwlimit))
+	security, err := json.Marshal(tc.security.podSecurityContext())
+	if err != nil {
+		return err
+	}
+	hash.Write(security)
+	hash.Write([]byte(tc.serviceAccountName()))
+	backendVolumes, err := json.Marshal(tc.backend.volumes())
+	if err != nil {
+		return err
+	}
+	hash.Write(backendVolumes)
+	tc.configHash = hex.EncodeToString(hash.Sum(nil))[:16]
+	return nil
+}
+
+// volumes returns the volumes served by the daemon, data volume first.
+func (tc *templateConfig) volumes() []corev1.Volume {
+	volumes := []corev1.Volume{tc.rsync.Volume}
+	for _, m := range tc.modules {
+		volumes = append(volumes, m.Volume)
+	}
+	return volumes
+}
+
+func (tc *templateConfig) volumeMounts() []corev1.VolumeMount {
+	hostToContainer := corev1.MountPropagationHostToContainer
+	mounts := []corev1.VolumeMount{
+		{
+			Name:             tc.rsync.Volume.Name,
+			MountPath:        "/data",
+			ReadOnly:         !tc.readWrite,
+			MountPropagation: &hostToContainer,
+		},
+	}
+	for _, m := range tc.modules {
+		mounts = append(mounts, corev1.VolumeMount{
+			Name:             m.volumeName(),
+			MountPath:        m.mountPath(),
+			ReadOnly:         m.ReadOnly,
+			MountPropagation: &hostToContainer,
+		})
+	}
+	return mounts
+}
+
+// command returns the command of the daemon container. The entrypoint of
+// the image is used for a root daemon without rate limit, otherwise rsync
+// is started directly with the secrets file of the credentials Secret.
+func (tc *templateConfig) command() []string {
+	if tc.bwlimit == "" && !tc.security.nonRoot {
+		return nil
+	}
+	command := []string{"rsync", "--daemon", "--no-detach", "--config=/etc/rsyncd.conf"}
+	if tc.bwlimit != "" {
+		command = append(command, "--bwlimit="+tc.bwlimit)
+	}
+	return command
+}
+
+// secretsVolumes mounts the secrets file of a daemon that doesn't run the
+// entrypoint of the image. rsync refuses a secrets file others can read,
+// a non-root daemon reads it through its fs group.
+func (tc *templateConfig) secretsVolumes() ([]corev1.Volume, []corev1.VolumeMount) {
+	if tc.command() == nil {
+		return nil, nil
+	}
+	mode := int32(0440)
+	volumes := []corev1.Volume{
+		{
+			Name: secretsVolumeName,
+			VolumeSource: corev1.VolumeSource{
+				Secret: &corev1.SecretVolumeSource{
+					SecretName: credentialsSecretName(tc.name),
+					Items: []corev1.KeyToPath{
+						{
+							Key:  credentialsSecretsFileKey,
+							Path: credentialsSecretsFileKey,
+						},
+					},
+					DefaultMode: &mode,
+				},
+			},
+		},
+	}
+	mounts := []corev1.VolumeMount{
+		{
+			Name:      secretsVolumeName,
+			MountPath: secretsDir,
+			ReadOnly:  true,
+		},
+	}
+	return volumes, mounts
+}
+
+// runVolumes returns the writable volumes of a non-root daemon.
+func (tc *templateConfig) runVolumes() ([]corev1.Volume, []corev1.VolumeMount) {
+	if !tc.security.nonRoot {
+		return nil, nil
+	}
+	volumes := []corev1.Volume{
+		{
+			Name: runVolumeName,
+			VolumeSource: corev1.VolumeSource{
+				EmptyDir: &corev1.EmptyDirVolumeSource{
+					Medium: corev1.StorageMediumMemory,
+				},
+			},
+		},
+	}
+	mounts := []corev1.VolumeMount{
+		{
+			Name:      runVolumeName,
+			MountPath: runDir,
+		},
+	}
+	return volumes, mounts
+}
+
+func (tc *templateConfig) secretsFile() string {
+	if tc.command() != nil {
+		return secretsDir + "/" + credentialsSecretsFileKey
+	}
+	return "/etc/rsyncd.secrets"
+}
+
 func (tc *templateConfig) getDeploymentTemplate() *appsv1.Deployment {
 	nodeSelector := make(map[string]string)
 	if tc.rsync.HostName != "" {
@@ -59,57 +379,24 @@ func (tc *templateConfig) getDeploymentTemplate() *appsv1.Deployment {
 						constant.NameLabel:      tc.name,
 						constant.AppLabel:       tc.name,
 					},
+					Annotations: tc.podAnnotations(),
 				},
 				Spec: corev1.PodSpec{
-					NodeSelector: nodeSelector,
-					Containers: []corev1.Container{
-						{
-							Name:            "rsync-daemon",
-							Image:           tc.rsync.Image,
-							ImagePullPolicy: corev1.PullAlways,
-							Env: []corev1.EnvVar{
-								{
-									Name:  "RSYNC_PASSWORD",
-									Value: tc.rsync.Password,
-								},
-							},
-							Ports: []corev1.ContainerPort{
-								{
-									Name:          "rsync-daemon",
-									ContainerPort: 873,
-								},
-							},
-							VolumeMounts: []corev1.VolumeMount{
-								{
-									Name:      tc.rsync.Volume.Name,
-									MountPath: "/data",
-									ReadOnly:  true,
-									MountPropagation: func() *corev1.MountPropagationMode {
-										name := corev1.MountPropagationHostToContainer
-										return &name
-									}(),
-								},
-								{
-									Name:      "config",
-									MountPath: "/etc/rsyncd.con",
-									SubPath:   "rsyncd.con",
+					NodeSelector:          nodeSelector,
+					ServiceAccountName:    tc.serviceAccountName(),
+					SecurityContext:       tc.security.podSecurityContext(),
+					ShareProcessNamespace: tc.shareProcessNamespace(),
+					Containers:            tc.backend.containers(),
+					Volumes: append(append(tc.volumes(), tc.backend.volumes()...), corev1.Volume{
+						Name: "config",
+						VolumeSource: corev1.VolumeSource{
+							ConfigMap: &corev1.ConfigMapVolumeSource{
+								LocalObjectReference: corev1.LocalObjectReference{
+									Name: tc.name,
 								},
 							},
 						},
-					},
-					Volumes: []corev1.Volume{
-						tc.rsync.Volume,
-						{
-							Name: "config",
-							VolumeSource: corev1.VolumeSource{
-								ConfigMap: &corev1.ConfigMapVolumeSource{
-									LocalObjectReference: corev1.LocalObjectReference{
-										Name: tc.name,
-									},
-								},
-							},
-						},
-					},
+					}),
 				},
 			},
 		},
@@ -130,13 +417,56 @@ func (tc *templateConfig) getCmTemplate() *corev1.ConfigMap {
 				constant.NameLabel:      tc.name,
 			},
 		},
-		Data: map[string]string{
-			"rsyncd.conf": rsyncdconfig,
-		},
+		Data: tc.files,
 	}
 	return &cm
 }
 
+// credentialsSecretName is the Secret holding the username and password of
+// a source for its servers.
+func credentialsSecretName(rsyncSourceName string) string {
+	return rsyncSourceName + "-credentials"
+}
+
+// credentialEnv returns an env var set from the credentials Secret.
+func (tc *templateConfig) credentialEnv(name, key string) corev1.EnvVar {
+	return corev1.EnvVar{
+		Name: name,
+		ValueFrom: &corev1.EnvVarSource{
+			SecretKeyRef: &corev1.SecretKeySelector{
+				LocalObjectReference: corev1.LocalObjectReference{
+					Name: credentialsSecretName(tc.name),
+				},
+				Key: key,
+			},
+		},
+	}
+}
+
+func (tc *templateConfig) getSecretTemplate() *corev1.Secret {
+	secret := corev1.Secret{
+		TypeMeta: metav1.TypeMeta{
+			Kind:       "Secret",
+			APIVersion: "v1",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name: credentialsSecretName(tc.name),
+			Labels: map[string]string{
+				constant.CreatedByLabel: constant.ComponentNameRsyncSourceController,
+				constant.NameLabel:      tc.name,
+			},
+		},
+		Type: corev1.SecretTypeOpaque,
+		Data: map[string][]byte{
+			credentialsUsernameKey: []byte(tc.rsync.Username),
+			credentialsPasswordKey: []byte(tc.rsync.Password),
+			// The secrets file lists the user of the rsyncd.conf.
+			credentialsSecretsFileKey: []byte("user:" + tc.rsync.Password + "\n"),
+		},
+	}
+	return &secret
+}
+
 func (tc *templateConfig) getSvcTemplate() *corev1.Service {
 	svc := corev1.Service{
 		TypeMeta: metav1.TypeMeta{
@@ -151,13 +481,7 @@ func (tc *templateConfig) getSvcTemplate() *corev1.Service {
 			},
 		},
 		Spec: corev1.ServiceSpec{
-			Ports: []corev1.ServicePort{
-				{
-					Name:     "rsync-daemon",
-					Port:     873,
-					Protocol: corev1.ProtocolTCP,
-				},
-			},
+			Ports: tc.backend.servicePorts(),
 			Selector: map[string]string{
 				constant.CreatedByLabel: constant.ComponentNameRsyncSourceController,
 				constant.NameLabel:      tc.name,
@@ -168,23 +492,84 @@ func (tc *templateConfig) getSvcTemplate() *corev1.Service {
 	return &svc
 }
 
-var rsyncdconfig = `
+type rsyncdConfig struct {
+	UID         int64
+	GID         int64
+	UseChroot   bool
+	Address     string
+	Port        int32
+	RunDir      string
+	SecretsFile string
+	LogFile     string
+	LogFormat   string
+	Auth        bool
+	Modules     []rsyncdModule
+}
+
+type rsyncdModule struct {
+	Name     string
+	Path     string
+	ReadOnly bool
+	Options  moduleOptions
+}
+
+// TimeoutSeconds returns the idle timeout of the module.
+func (m rsyncdModule) TimeoutSeconds() int {
+	if m.Options.TimeoutSeconds == 0 {
+		return 600
+	}
+	return m.Options.TimeoutSeconds
+}
+
+var rsyncdConfigTemplate = template.Must(template.New("rsyncd.conf").Funcs(template.FuncMap{
+	"join": func(list []string) string { return strings.Join(list, " ") },
+}).Parse(`
 # /etc/rsyncd.conf
 # Minimal configuration file for rsync daemon
 # See rsync(1) and rsyncd.conf(5) man pages for help
 # This line is required by the /etc/init.d/rsyncd script
-pid file = /var/run/rsyncd.pid
-uid = 0
-gid = 0
-use chroot = yes
+pid file = {{ .RunDir }}/rsyncd.pid
+{{- if .Address }}
+address = {{ .Address }}
+{{- end }}
+port = {{ .Port }}
+uid = {{ .UID }}
+gid = {{ .GID }}
+use chroot = {{ if .UseChroot }}yes{{ else }}no{{ end }}
 reverse lookup = no
-[data]
+{{- if .LogFile }}
+log file = {{ .LogFile }}
+log format = {{ .LogFormat }}
+{{- end }}
+{{- range $m := .Modules }}
+[{{ .Name }}]
     hosts deny = *
     hosts allow = 0.0.0.0/0
-    read only = false
-    path = /data
+    read only = {{ .ReadOnly }}
+    path = {{ .Path }}
+{{- if $.Auth }}
     auth users = , user:rw
-    secrets file = /etc/rsyncd.secrets
-    timeout = 600
+    secrets file = {{ $.SecretsFile }}
+{{- end }}
+    timeout = {{ .TimeoutSeconds }}
     transfer logging = true
-`
+{{- with .Options }}
+{{- if .MaxConnections }}
+    max connections = {{ .MaxConnections }}
+    lock file = {{ $.RunDir }}/rsyncd-{{ $m.Name }}.lock
+{{- end }}
+{{- if .Include }}
+    include = {{ join .Include }}
+{{- end }}
+{{- if .Exclude }}
+    exclude = {{ join .Exclude }}
+{{- end }}
+{{- if .DontCompress }}
+    dont compress = {{ join .DontCompress }}
+{{- end }}
+{{- if .RefuseOptions }}
+    refuse options = {{ join .RefuseOptions }}
+{{- end }}
+{{- end }}
+{{- end }}
+`))
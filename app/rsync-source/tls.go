@@ -0,0 +1,471 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"time"
+
+	certificatesv1 "k8s.io/api/certificates/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/klog/v2"
+
+	internalv1 "github.com/k8s-volume-copy/types/apis/demo.io/v1"
+	"github.com/k8s-volume-copy/volume-source/pkg/conditions"
+)
+
+const (
+	// tlsAnnotation serves the daemon with TLS through the proxy sidecar.
+	// With `Secret` the certificate is taken from the Secret named by
+	// `demo.io/tls-secret`, with `CSR` the controller requests it from the
+	// signer named by `demo.io/tls-signer-name` with the CSR API.
+	tlsAnnotation           = "demo.io/tls"
+	tlsSecretAnnotation     = "demo.io/tls-secret"
+	tlsSignerNameAnnotation = "demo.io/tls-signer-name"
+	// tlsClientCAAnnotation names a ConfigMap with a `ca.crt` bundle, only
+	// clients with a certificate of this CA are accepted.
+	tlsClientCAAnnotation = "demo.io/tls-client-ca"
+	// caBundleAnnotation publishes the CA bundle clients verify the server
+	// certificate with.
+	caBundleAnnotation = "demo.io/ca-bundle"
+
+	tlsModeSecret = "Secret"
+	tlsModeCSR    = "CSR"
+
+	// tlsReadyConditionType reports whether the certificate of the source
+	// is available.
+	tlsReadyConditionType = "TLSReady"
+
+	tlsCertKey = "tls.crt"
+	tlsKeyKey  = "tls.key"
+	caCertKey  = "ca.crt"
+
+	// tlsSecretLabel marks the Secrets holding the key of a source in CSR
+	// mode, also those named with `demo.io/tls-secret`. They are deleted
+	// by this label.
+	tlsSecretLabel = "demo.io/tls-certificate"
+	// csrNamespaceLabel is the namespace of the source of a cluster scoped
+	// CertificateSigningRequest.
+	csrNamespaceLabel = "demo.io/namespace"
+	// tlsSecretIndex indexes rsync sources in Secret mode by the
+	// namespace/name of their Secret.
+	tlsSecretIndex = "tlsSecret"
+
+	// tlsPollInterval is used while waiting for a certificate.
+	tlsPollInterval = 30 * time.Second
+)
+
+// tlsConfig is the certificate setup of a source.
+type tlsConfig struct {
+	mode       string
+	secretName string
+	signerName string
+	clientCA   string
+}
+
+func tlsConfigFromAnnotations(name string, annotations map[string]string) (*tlsConfig, error) {
+	tc := &tlsConfig{
+		mode:       annotations[tlsAnnotation],
+		secretName: annotations[tlsSecretAnnotation],
+		signerName: annotations[tlsSignerNameAnnotation],
+		clientCA:   annotations[tlsClientCAAnnotation],
+	}
+	switch tc.mode {
+	case "":
+		return nil, nil
+	case tlsModeSecret:
+		if tc.secretName == "" {
+			return nil, fmt.Errorf("`%s: %s` requires the `%s` annotation", tlsAnnotation, tlsModeSecret, tlsSecretAnnotation)
+		}
+	case tlsModeCSR:
+		if tc.signerName == "" {
+			return nil, fmt.Errorf("`%s: %s` requires the `%s` annotation", tlsAnnotation, tlsModeCSR, tlsSignerNameAnnotation)
+		}
+		if tc.secretName == "" {
+			tc.secretName = tlsSecretName(name)
+		}
+	default:
+		return nil, fmt.Errorf("invalid `%s` annotation `%s`, expected `%s` or `%s`",
+			tlsAnnotation, tc.mode, tlsModeSecret, tlsModeCSR)
+	}
+	return tc, nil
+}
+
+// tlsSecretName is the Secret holding the key and the issued certificate of
+// a source in CSR mode.
+func tlsSecretName(rsyncSourceName string) string {
+	return rsyncSourceName + "-tls"
+}
+
+// csrName is the name of the cluster scoped CertificateSigningRequest of a
+// source. Namespace and name can't be joined unambiguously with a dash, so
+// a hash of both keeps the requests of sources apart.
+func csrName(cr internalv1.RsyncSource) string {
+	name := fmt.Sprintf("rsync-source-%s-%s", cr.GetNamespace(), cr.GetName())
+	return shortenName(name, validation.DNS1123SubdomainMaxLength-nameHashLength-1) + "-" +
+		nameHash(cr.GetNamespace()+"/"+cr.GetName())
+}
+
+// csrLabels are the labels of the CertificateSigningRequest of a source.
+func csrLabels(cr internalv1.RsyncSource) map[string]string {
+	labels := snapshotLabels(cr.GetName())
+	labels[csrNamespaceLabel] = cr.GetNamespace()
+	return labels
+}
+
+// dnsNames are the names of the service of a source.
+func dnsNames(cr internalv1.RsyncSource) []string {
+	name, namespace := cr.GetName(), cr.GetNamespace()
+	return []string{
+		name,
+		name + "." + namespace,
+		name + "." + namespace + ".svc",
+		name + "." + namespace + ".svc.cluster.local",
+	}
+}
+
+// ensureTLS makes the certificate of a source available and publishes its
+// CA bundle. It returns whether the certificate can be served and when the
+// source has to be synced again, e.g. to renew the certificate.
+func (c *controller) ensureTLS(ctx context.Context, cr internalv1.RsyncSource, tc *tlsConfig) (bool, time.Duration, error) {
+	secret, err := c.kubeClient.CoreV1().Secrets(cr.GetNamespace()).Get(ctx, tc.secretName, metav1.GetOptions{})
+	if err != nil && !errors.IsNotFound(err) {
+		return false, 0, err
+	}
+	if tc.mode == tlsModeSecret {
+		if err != nil {
+			return false, 0, c.setTLSStatus(ctx, cr, nil, metav1.ConditionFalse, "SecretNotFound",
+				fmt.Sprintf("secret `%s` not found", tc.secretName))
+		}
+		cert, err := parseCertificate(secret.Data[tlsCertKey])
+		if err != nil || len(secret.Data[tlsKeyKey]) == 0 {
+			return false, 0, c.setTLSStatus(ctx, cr, nil, metav1.ConditionFalse, "InvalidSecret",
+				fmt.Sprintf("secret `%s` has no valid `%s` and `%s`", tc.secretName, tlsCertKey, tlsKeyKey))
+		}
+		// Renewal is up to whoever manages the Secret, the source is synced
+		// again when it changes to publish a changed CA bundle.
+		return true, 0, c.setTLSStatus(ctx, cr, secret.Data[caCertKey], metav1.ConditionTrue, "SecretFound",
+			fmt.Sprintf("certificate valid until %s", cert.NotAfter.UTC().Format(time.RFC3339)))
+	}
+
+	if err != nil {
+		if secret, err = c.createTLSSecret(ctx, cr, tc.secretName); err != nil {
+			return false, 0, err
+		}
+	}
+	if !isTLSSecretOf(secret.GetLabels(), cr.GetName()) {
+		return false, 0, fmt.Errorf("secret `%s` found but not created by this operator", tc.secretName)
+	}
+	key, err := parsePrivateKey(secret.Data[tlsKeyKey])
+	if err != nil {
+		return false, 0, err
+	}
+	cert, _ := parseCertificate(secret.Data[tlsCertKey])
+	if cert != nil {
+		if public, ok := cert.PublicKey.(interface{ Equal(crypto.PublicKey) bool }); !ok || !public.Equal(key.Public()) {
+			cert = nil
+		}
+	}
+	ready := cert != nil && time.Now().Before(cert.NotAfter)
+	if cert != nil {
+		if wait := time.Until(renewalTime(cert)); wait > 0 {
+			return true, wait, c.setTLSStatus(ctx, cr, secret.Data[caCertKey], metav1.ConditionTrue, "Issued",
+				fmt.Sprintf("certificate valid until %s", cert.NotAfter.UTC().Format(time.RFC3339)))
+		}
+	}
+
+	// The certificate is missing or due for renewal, the current one is
+	// served until the new one is issued.
+	issued, err := c.ensureCSR(ctx, cr, tc, key)
+	if err != nil {
+		if ready {
+			klog.Errorf("error renewing certificate of rsync source `%s` in `%s` namespace error: %s",
+				cr.GetName(), cr.GetNamespace(), err)
+			return true, tlsPollInterval, nil
+		}
+		return false, tlsPollInterval, c.setTLSStatus(ctx, cr, nil, metav1.ConditionFalse, "CSRFailed", err.Error())
+	}
+	if issued == nil {
+		if ready {
+			return true, tlsPollInterval, nil
+		}
+		return false, tlsPollInterval, c.setTLSStatus(ctx, cr, nil, metav1.ConditionFalse, "CSRPending",
+			fmt.Sprintf("waiting for signer `%s` to issue CertificateSigningRequest `%s`", tc.signerName, csrName(cr)))
+	}
+	leaf, chain := splitChain(issued)
+	if leaf == nil {
+		return ready, tlsPollInterval, c.setTLSStatus(ctx, cr, nil, metav1.ConditionFalse, "InvalidCertificate",
+			fmt.Sprintf("CertificateSigningRequest `%s` issued no valid certificate", csrName(cr)))
+	}
+	secret.Data[tlsCertKey] = issued
+	secret.Data[caCertKey] = chain
+	if _, err := c.kubeClient.CoreV1().Secrets(cr.GetNamespace()).Update(ctx, secret, metav1.UpdateOptions{}); err != nil {
+		return false, 0, err
+	}
+	if err := c.kubeClient.CertificatesV1().CertificateSigningRequests().
+		Delete(ctx, csrName(cr), metav1.DeleteOptions{}); err != nil && !errors.IsNotFound(err) {
+		return false, 0, err
+	}
+	return true, time.Until(renewalTime(leaf)), c.setTLSStatus(ctx, cr, chain, metav1.ConditionTrue, "Issued",
+		fmt.Sprintf("certificate valid until %s", leaf.NotAfter.UTC().Format(time.RFC3339)))
+}
+
+// renewalTime is when a certificate is renewed, after two thirds of its
+// lifetime.
+func renewalTime(cert *x509.Certificate) time.Time {
+	return cert.NotAfter.Add(-cert.NotAfter.Sub(cert.NotBefore) / 3)
+}
+
+func (c *controller) createTLSSecret(ctx context.Context, cr internalv1.RsyncSource, name string) (*corev1.Secret, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	der, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:   name,
+			Labels: tlsSecretLabels(cr.GetName()),
+		},
+		Type: corev1.SecretTypeOpaque,
+		Data: map[string][]byte{
+			tlsKeyKey: pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der}),
+		},
+	}
+	return c.kubeClient.CoreV1().Secrets(cr.GetNamespace()).Create(ctx, secret, metav1.CreateOptions{})
+}
+
+// ensureCSR requests a certificate for key. It returns the issued
+// certificate chain, or nil while the request is pending.
+func (c *controller) ensureCSR(ctx context.Context, cr internalv1.RsyncSource, tc *tlsConfig, key *ecdsa.PrivateKey) ([]byte, error) {
+	csrs := c.kubeClient.CertificatesV1().CertificateSigningRequests()
+	csr, err := csrs.Get(ctx, csrName(cr), metav1.GetOptions{})
+	if err != nil && !errors.IsNotFound(err) {
+		return nil, err
+	}
+	if err == nil && !isCSROf(csr.GetLabels(), cr) {
+		return nil, fmt.Errorf("CertificateSigningRequest `%s` found but not created for this source", csr.GetName())
+	}
+	if err == nil && !isCSRFor(csr, tc.signerName, key) {
+		// The request was made for another key or signer.
+		if err := csrs.Delete(ctx, csr.GetName(), metav1.DeleteOptions{}); err != nil && !errors.IsNotFound(err) {
+			return nil, err
+		}
+		return nil, nil
+	}
+	if errors.IsNotFound(err) {
+		request, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{
+			Subject:  pkix.Name{CommonName: fmt.Sprintf("%s.%s.svc", cr.GetName(), cr.GetNamespace())},
+			DNSNames: dnsNames(cr),
+		}, key)
+		if err != nil {
+			return nil, err
+		}
+		csr = &certificatesv1.CertificateSigningRequest{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:   csrName(cr),
+				Labels: csrLabels(cr),
+			},
+			Spec: certificatesv1.CertificateSigningRequestSpec{
+				Request:    pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: request}),
+				SignerName: tc.signerName,
+				Usages: []certificatesv1.KeyUsage{
+					certificatesv1.UsageDigitalSignature,
+					certificatesv1.UsageKeyEncipherment,
+					certificatesv1.UsageServerAuth,
+				},
+			},
+		}
+		_, err = csrs.Create(ctx, csr, metav1.CreateOptions{})
+		return nil, err
+	}
+	for _, condition := range csr.Status.Conditions {
+		if condition.Type == certificatesv1.CertificateDenied || condition.Type == certificatesv1.CertificateFailed {
+			return nil, fmt.Errorf("CertificateSigningRequest `%s` %s: %s", csr.GetName(), condition.Type, condition.Message)
+		}
+	}
+	if len(csr.Status.Certificate) == 0 {
+		return nil, nil
+	}
+	return csr.Status.Certificate, nil
+}
+
+// isCSRFor returns whether csr requests a certificate for key from signer.
+func isCSRFor(csr *certificatesv1.CertificateSigningRequest, signer string, key *ecdsa.PrivateKey) bool {
+	if csr.Spec.SignerName != signer {
+		return false
+	}
+	block, _ := pem.Decode(csr.Spec.Request)
+	if block == nil {
+		return false
+	}
+	request, err := x509.ParseCertificateRequest(block.Bytes)
+	if err != nil {
+		return false
+	}
+	public, ok := request.PublicKey.(*ecdsa.PublicKey)
+	return ok && public.Equal(key.Public())
+}
+
+// isCSROf returns whether the labels are those of the
+// CertificateSigningRequest of a source.
+func isCSROf(labels map[string]string, cr internalv1.RsyncSource) bool {
+	for k, v := range csrLabels(cr) {
+		if labels[k] != v {
+			return false
+		}
+	}
+	return true
+}
+
+// tlsSecretLabels are the labels of the Secrets created for the key of a
+// source.
+func tlsSecretLabels(rsyncSourceName string) map[string]string {
+	labels := snapshotLabels(rsyncSourceName)
+	labels[tlsSecretLabel] = "true"
+	return labels
+}
+
+func isTLSSecretOf(labels map[string]string, rsyncSourceName string) bool {
+	for k, v := range tlsSecretLabels(rsyncSourceName) {
+		if labels[k] != v {
+			return false
+		}
+	}
+	return true
+}
+
+// deleteTLS removes the CertificateSigningRequest and the Secrets created
+// for a source in CSR mode. A Secret referenced in Secret mode is left
+// alone.
+func (c *controller) deleteTLS(ctx context.Context, cr internalv1.RsyncSource) error {
+	csrs := c.kubeClient.CertificatesV1().CertificateSigningRequests()
+	csr, err := csrs.Get(ctx, csrName(cr), metav1.GetOptions{})
+	if err != nil && !errors.IsNotFound(err) {
+		return err
+	}
+	if err == nil && isCSROf(csr.GetLabels(), cr) {
+		if err := csrs.Delete(ctx, csr.GetName(), metav1.DeleteOptions{
+			Preconditions: &metav1.Preconditions{UID: &csr.UID},
+		}); err != nil && !errors.IsNotFound(err) {
+			return err
+		}
+	}
+	secrets, err := c.kubeClient.CoreV1().Secrets(cr.GetNamespace()).List(ctx, metav1.ListOptions{
+		LabelSelector: labels.SelectorFromSet(tlsSecretLabels(cr.GetName())).String(),
+	})
+	if err != nil {
+		return err
+	}
+	for _, secret := range secrets.Items {
+		err := c.kubeClient.CoreV1().Secrets(cr.GetNamespace()).Delete(ctx, secret.GetName(), metav1.DeleteOptions{})
+		if err != nil && !errors.IsNotFound(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+func rsyncSourceTLSSecretIndexFunc(obj interface{}) ([]string, error) {
+	u, ok := obj.(*unstructured.Unstructured)
+	if !ok || u.GetAnnotations()[tlsAnnotation] != tlsModeSecret {
+		return nil, nil
+	}
+	secret, ok := u.GetAnnotations()[tlsSecretAnnotation]
+	if !ok {
+		return nil, nil
+	}
+	return []string{u.GetNamespace() + "/" + secret}, nil
+}
+
+// handleSecret queues the rsync sources serving the certificate of a
+// Secret.
+func (c *controller) handleSecret(obj interface{}) {
+	if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+		obj = tombstone.Obj
+	}
+	secret, ok := obj.(metav1.Object)
+	if !ok {
+		return
+	}
+	sources, err := c.rsIndexer.ByIndex(tlsSecretIndex, secret.GetNamespace()+"/"+secret.GetName())
+	if err != nil {
+		return
+	}
+	for _, source := range sources {
+		c.handle(source)
+	}
+}
+
+// isTLSEnabled returns whether TLS was set up for a source, so it has to be
+// cleaned up once it is disabled.
+func isTLSEnabled(cr internalv1.RsyncSource) bool {
+	conds, err := conditions.FromAnnotations(cr.GetAnnotations())
+	if err != nil {
+		return false
+	}
+	condition := meta.FindStatusCondition(conds, tlsReadyConditionType)
+	return condition != nil && condition.Reason != "Disabled"
+}
+
+func (c *controller) setTLSStatus(ctx context.Context, cr internalv1.RsyncSource, caBundle []byte,
+	status metav1.ConditionStatus, reason, message string) error {
+	var bundle *string
+	if len(caBundle) != 0 {
+		value := string(caBundle)
+		bundle = &value
+	}
+	return c.setRsyncSourceStatus(ctx, cr.GetNamespace(), cr.GetName(), map[string]*string{
+		caBundleAnnotation: bundle,
+	}, metav1.Condition{
+		Type:    tlsReadyConditionType,
+		Status:  status,
+		Reason:  reason,
+		Message: message,
+	})
+}
+
+func parseCertificate(raw []byte) (*x509.Certificate, error) {
+	block, _ := pem.Decode(raw)
+	if block == nil || block.Type != "CERTIFICATE" {
+		return nil, fmt.Errorf("no certificate found")
+	}
+	return x509.ParseCertificate(block.Bytes)
+}
+
+func parsePrivateKey(raw []byte) (*ecdsa.PrivateKey, error) {
+	block, _ := pem.Decode(raw)
+	if block == nil {
+		return nil, fmt.Errorf("no private key found")
+	}
+	return x509.ParseECPrivateKey(block.Bytes)
+}
+
+// splitChain returns the leaf of an issued chain and the certificates
+// following it, which are published as CA bundle. Signers returning only
+// the leaf have to distribute their CA to clients themselves.
+func splitChain(raw []byte) (*x509.Certificate, []byte) {
+	block, rest := pem.Decode(raw)
+	if block == nil {
+		return nil, nil
+	}
+	leaf, _ := x509.ParseCertificate(block.Bytes)
+	return leaf, bytes.TrimSpace(rest)
+}
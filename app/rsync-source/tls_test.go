@@ -0,0 +1,68 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+
+	internalv1 "github.com/k8s-volume-copy/types/apis/demo.io/v1"
+)
+
+func TestCSRName(t *testing.T) {
+	source := func(namespace, name string) internalv1.RsyncSource {
+		return internalv1.RsyncSource{ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: name}}
+	}
+	tests := []struct {
+		name  string
+		a, b  internalv1.RsyncSource
+		equal bool
+	}{
+		{name: "same source", a: source("a", "b-c"), b: source("a", "b-c"), equal: true},
+		{name: "dash moved between namespace and name", a: source("a", "b-c"), b: source("a-b", "c")},
+		{name: "other namespace", a: source("a", "src"), b: source("b", "src")},
+		{
+			name: "long names",
+			a:    source(strings.Repeat("n", 63), strings.Repeat("a", 253)),
+			b:    source(strings.Repeat("n", 63), strings.Repeat("a", 252)+"b"),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a, b := csrName(tt.a), csrName(tt.b)
+			for _, name := range []string{a, b} {
+				if errs := validation.IsDNS1123Subdomain(name); len(errs) != 0 {
+					t.Errorf("csrName() = %s, invalid: %v", name, errs)
+				}
+			}
+			if (a == b) != tt.equal {
+				t.Errorf("csrName() = %s and %s, want equal %t", a, b, tt.equal)
+			}
+		})
+	}
+}
+
+func TestIsCSROf(t *testing.T) {
+	source := internalv1.RsyncSource{ObjectMeta: metav1.ObjectMeta{Namespace: "a", Name: "b-c"}}
+	tests := []struct {
+		name   string
+		labels map[string]string
+		want   bool
+	}{
+		{name: "own request", labels: csrLabels(source), want: true},
+		{
+			name:   "request of a source in another namespace",
+			labels: csrLabels(internalv1.RsyncSource{ObjectMeta: metav1.ObjectMeta{Namespace: "a-b", Name: "b-c"}}),
+		},
+		{name: "request without namespace label", labels: snapshotLabels("b-c")},
+		{name: "not created by this controller"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isCSROf(tt.labels, source); got != tt.want {
+				t.Errorf("isCSROf() = %t, want %t", got, tt.want)
+			}
+		})
+	}
+}
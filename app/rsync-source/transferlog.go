@@ -0,0 +1,269 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/klog/v2"
+
+	internalv1 "github.com/k8s-volume-copy/types/apis/demo.io/v1"
+	"github.com/k8s-volume-copy/types/constant"
+)
+
+const (
+	// transferLogAnnotation adds a log shipper sidecar to the rsync daemon
+	// with `Enabled`. The sidecar tails the transfer log of the daemon,
+	// writes every transfer as JSON to its stdout and exports Prometheus
+	// metrics per module. Draining and the idle timeout rely on the
+	// sessions it tracks.
+	transferLogAnnotation = "demo.io/transfer-log"
+	transferLogEnabled    = "Enabled"
+	transferLogDisabled   = "Disabled"
+	// recentSessionsAnnotation reports the last sessions of the daemon as
+	// JSON list, newest first.
+	recentSessionsAnnotation = "demo.io/recent-sessions"
+	// activeSessionsAnnotation reports the number of sessions in progress.
+	activeSessionsAnnotation = "demo.io/active-sessions"
+	// lastActivityAnnotation reports when the last session was seen.
+	lastActivityAnnotation = "demo.io/last-activity"
+
+	transferLogDir      = "/var/log/rsyncd"
+	transferLogFile     = transferLogDir + "/rsyncd.log"
+	transferLogFormat   = "transfer %o %a %m %l %b %f"
+	logShipperPort      = 9090
+	maxRecentSessions   = 10
+	sessionPollInterval = time.Minute
+)
+
+// transferSession is a session reported by the log shipper, see
+// app/rsync-log-shipper.
+type transferSession struct {
+	PID           int        `json:"pid"`
+	Client        string     `json:"client"`
+	User          string     `json:"user,omitempty"`
+	Module        string     `json:"module"`
+	Direction     string     `json:"direction"`
+	StartTime     time.Time  `json:"startTime"`
+	EndTime       *time.Time `json:"endTime,omitempty"`
+	Files         int64      `json:"files"`
+	Bytes         int64      `json:"bytes"`
+	BytesSent     int64      `json:"bytesSent"`
+	BytesReceived int64      `json:"bytesReceived"`
+	Result        string     `json:"result,omitempty"`
+	Error         string     `json:"error,omitempty"`
+	// Pod is set by the controller.
+	Pod string `json:"pod,omitempty"`
+}
+
+type transferSessions struct {
+	Active []transferSession `json:"active"`
+	Recent []transferSession `json:"recent"`
+	// unknown counts the running daemon pods whose sessions are unknown,
+	// as they have no log shipper or it isn't reachable.
+	unknown int
+}
+
+func logShipperFromAnnotations(annotations map[string]string) (bool, error) {
+	switch v := annotations[transferLogAnnotation]; v {
+	case "", transferLogDisabled:
+		return false, nil
+	case transferLogEnabled:
+		return true, nil
+	default:
+		return false, fmt.Errorf("invalid `%s` annotation `%s`, expected `%s` or `%s`",
+			transferLogAnnotation, v, transferLogEnabled, transferLogDisabled)
+	}
+}
+
+// logShipperContainers returns the log shipper sidecar, if any.
+func (tc *templateConfig) logShipperContainers() []corev1.Container {
+	if !tc.logShipper {
+		return nil
+	}
+	return []corev1.Container{
+		{
+			Name:            "rsync-log-shipper",
+			Image:           rsyncLogShipperImage,
+			ImagePullPolicy: corev1.PullAlways,
+			Command: []string{
+				"rsync-log-shipper",
+				"--log-file=" + transferLogFile,
+				"--listen=:" + strconv.Itoa(logShipperPort),
+			},
+			SecurityContext: tc.security.containerSecurityContext(),
+			Ports: []corev1.ContainerPort{
+				{
+					Name:          "metrics",
+					ContainerPort: logShipperPort,
+				},
+			},
+			VolumeMounts: []corev1.VolumeMount{tc.transferLogMount()},
+		},
+	}
+}
+
+// transferLogMount mounts the log directory shared by the daemon and the
+// log shipper.
+func (tc *templateConfig) transferLogMount() corev1.VolumeMount {
+	return corev1.VolumeMount{
+		Name:      "transfer-log",
+		MountPath: transferLogDir,
+	}
+}
+
+// daemonLogMounts returns the mounts of the container running the daemon.
+func (tc *templateConfig) daemonLogMounts() []corev1.VolumeMount {
+	if !tc.logShipper {
+		return nil
+	}
+	return []corev1.VolumeMount{tc.transferLogMount()}
+}
+
+// logShipperVolumes returns the log directory volume.
+func (tc *templateConfig) logShipperVolumes() []corev1.Volume {
+	if !tc.logShipper {
+		return nil
+	}
+	return []corev1.Volume{
+		{
+			Name: "transfer-log",
+			VolumeSource: corev1.VolumeSource{
+				EmptyDir: &corev1.EmptyDirVolumeSource{},
+			},
+		},
+	}
+}
+
+// shareProcessNamespace lets the log shipper see the daemon processes, a
+// session whose process is gone without logging its end is failed.
+func (tc *templateConfig) shareProcessNamespace() *bool {
+	if !tc.logShipper {
+		return nil
+	}
+	share := true
+	return &share
+}
+
+// podAnnotations returns the annotations of the daemon pod.
+func (tc *templateConfig) podAnnotations() map[string]string {
+	annotations := map[string]string{
+		configHashAnnotation: tc.configHash,
+	}
+	if tc.logShipper {
+		annotations["prometheus.io/scrape"] = "true"
+		annotations["prometheus.io/port"] = strconv.Itoa(logShipperPort)
+	}
+	return annotations
+}
+
+// getSessions returns the sessions of all daemon pods of a rsync source.
+// Pods without a reachable log shipper are counted as unknown.
+func (c *controller) getSessions(ctx context.Context, cr internalv1.RsyncSource, tracked bool) (*transferSessions, error) {
+	pods, err := c.podIndexer.ByIndex(cache.NamespaceIndex, cr.GetNamespace())
+	if err != nil {
+		return nil, err
+	}
+	selector := labels.SelectorFromSet(labels.Set{
+		constant.CreatedByLabel: constant.ComponentNameRsyncSourceController,
+		constant.NameLabel:      cr.GetName(),
+		constant.AppLabel:       cr.GetName(),
+	})
+	sessions := &transferSessions{
+		Active: []transferSession{},
+		Recent: []transferSession{},
+	}
+	for _, obj := range pods {
+		pod, ok := obj.(*corev1.Pod)
+		if !ok || !selector.Matches(labels.Set(pod.GetLabels())) || pod.Status.Phase != corev1.PodRunning {
+			continue
+		}
+		if !tracked {
+			sessions.unknown++
+			continue
+		}
+		raw, err := c.kubeClient.CoreV1().Pods(pod.GetNamespace()).
+			ProxyGet("http", pod.GetName(), strconv.Itoa(logShipperPort), "/sessions", nil).DoRaw(ctx)
+		if err != nil {
+			klog.V(4).Infof("Failed to get sessions of pod `%s` in `%s` namespace: %v",
+				pod.GetName(), pod.GetNamespace(), err)
+			sessions.unknown++
+			continue
+		}
+		podSessions := transferSessions{}
+		if err := json.Unmarshal(raw, &podSessions); err != nil {
+			return nil, fmt.Errorf("invalid sessions of pod `%s`, error: %s", pod.GetName(), err)
+		}
+		for _, s := range podSessions.Active {
+			s.Pod = pod.GetName()
+			sessions.Active = append(sessions.Active, s)
+		}
+		for _, s := range podSessions.Recent {
+			s.Pod = pod.GetName()
+			sessions.Recent = append(sessions.Recent, s)
+		}
+	}
+	sort.Slice(sessions.Recent, func(i, j int) bool {
+		return sessions.Recent[i].EndTime.After(*sessions.Recent[j].EndTime)
+	})
+	if len(sessions.Recent) > maxRecentSessions {
+		sessions.Recent = sessions.Recent[:maxRecentSessions]
+	}
+	return sessions, nil
+}
+
+// ensureSessionStatus summarizes the sessions of a rsync source on its
+// annotations. It returns when the source was last used, which is zero if
+// no session was seen yet. While the sessions of a pod are unknown it
+// returns now, so the source doesn't expire while it may be in use.
+func (c *controller) ensureSessionStatus(ctx context.Context, cr internalv1.RsyncSource) (time.Time, error) {
+	sessions, err := c.getSessions(ctx, cr, true)
+	if err != nil {
+		return time.Time{}, err
+	}
+	recent, err := json.Marshal(sessions.Recent)
+	if err != nil {
+		return time.Time{}, err
+	}
+	rawRecent := string(recent)
+	active := strconv.Itoa(len(sessions.Active))
+	values := map[string]*string{
+		recentSessionsAnnotation: &rawRecent,
+		activeSessionsAnnotation: &active,
+	}
+	// The last activity is kept in minutes, so polling an active source
+	// updates it at most once a minute.
+	lastActivity, _ := time.Parse(time.RFC3339, cr.GetAnnotations()[lastActivityAnnotation])
+	if len(sessions.Active) != 0 {
+		lastActivity = time.Now().Truncate(time.Minute)
+	}
+	for _, s := range sessions.Recent {
+		if s.EndTime.After(lastActivity) {
+			lastActivity = s.EndTime.Truncate(time.Minute)
+		}
+	}
+	if !lastActivity.IsZero() {
+		rawLastActivity := lastActivity.UTC().Format(time.RFC3339)
+		values[lastActivityAnnotation] = &rawLastActivity
+	}
+	if sessions.unknown != 0 {
+		return time.Now(), c.setRsyncSourceStatus(ctx, cr.GetNamespace(), cr.GetName(), values)
+	}
+	return lastActivity, c.setRsyncSourceStatus(ctx, cr.GetNamespace(), cr.GetName(), values)
+}
+
+// deleteSessionStatus removes the session annotations of a rsync source
+// whose transfer log was disabled.
+func (c *controller) deleteSessionStatus(ctx context.Context, cr internalv1.RsyncSource) error {
+	return c.setRsyncSourceStatus(ctx, cr.GetNamespace(), cr.GetName(), map[string]*string{
+		recentSessionsAnnotation: nil,
+		activeSessionsAnnotation: nil,
+		lastActivityAnnotation:   nil,
+	})
+}
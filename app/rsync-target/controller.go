@@ -0,0 +1,621 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"reflect"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/dynamic/dynamicinformer"
+	"k8s.io/client-go/dynamic/dynamiclister"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	batchlisters "k8s.io/client-go/listers/batch/v1"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/util/workqueue"
+	"k8s.io/klog/v2"
+
+	internalv1 "github.com/k8s-volume-copy/types/apis/demo.io/v1"
+	"github.com/k8s-volume-copy/types/constant"
+	"github.com/robfig/cron/v3"
+)
+
+const (
+	rsyncTargetKind     = "RsyncTarget"
+	rsyncTargetResource = "rsynctargets"
+
+	// sourceReadyConditionType reports whether the referenced rsync source
+	// exists.
+	sourceReadyConditionType = "SourceReady"
+	// completeConditionType reports whether the client Job finished.
+	completeConditionType = "Complete"
+	// scheduleValidConditionType reports whether the schedule of a target
+	// is a valid cron expression.
+	scheduleValidConditionType = "ScheduleValid"
+)
+
+var (
+	rsyncTargetGVR = schema.GroupVersionResource{
+		Group:    constant.GroupDemoIO,
+		Version:  constant.VersionV1,
+		Resource: rsyncTargetResource,
+	}
+
+	rsyncTargetGK = schema.GroupKind{
+		Group: constant.GroupDemoIO,
+		Kind:  rsyncTargetKind,
+	}
+
+	rsyncSourceGVR = schema.GroupVersionResource{
+		Group:    constant.GroupDemoIO,
+		Version:  constant.VersionV1,
+		Resource: constant.RsyncSourceResource,
+	}
+)
+
+type controller struct {
+	kubeClient        kubernetes.Interface
+	dynamicClient     dynamic.Interface
+	rsyncTargetLister dynamiclister.Lister
+	rsyncTargetSynced cache.InformerSynced
+	jobLister         batchlisters.JobLister
+	jobSynced         cache.InformerSynced
+	workqueue         workqueue.RateLimitingInterface
+}
+
+func runController(cfg *rest.Config) {
+	klog.Infof("Starting controller for %s", strings.ToLower(rsyncTargetGK.String()))
+	stopCh := make(chan struct{})
+	sigCh := make(chan os.Signal, 2)
+	signal.Notify(sigCh, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-sigCh
+		close(stopCh)
+		<-sigCh
+		os.Exit(1) // second signal. Exit directly.
+	}()
+
+	kubeClient, err := kubernetes.NewForConfig(cfg)
+	if nil != err {
+		klog.Fatalf("Failed to create kube client: %v", err)
+	}
+
+	dynamicClient, err := dynamic.NewForConfig(cfg)
+	if nil != err {
+		klog.Fatalf("Failed to create dynamic client: %v", err)
+	}
+
+	// Only jobs created by this controller are cached.
+	informerFactory := informers.NewSharedInformerFactoryWithOptions(kubeClient, 30*time.Second,
+		informers.WithTweakListOptions(func(options *metav1.ListOptions) {
+			options.LabelSelector = constant.CreatedByLabel + "=" + componentNameRsyncTargetController
+		}))
+	jobInformer := informerFactory.Batch().V1().Jobs().Informer()
+
+	dynamicInformerFactory := dynamicinformer.NewDynamicSharedInformerFactory(dynamicClient, 30*time.Second)
+	rsyncTargetInformer := dynamicInformerFactory.ForResource(rsyncTargetGVR).Informer()
+	c := &controller{
+		kubeClient:        kubeClient,
+		dynamicClient:     dynamicClient,
+		rsyncTargetLister: dynamiclister.New(rsyncTargetInformer.GetIndexer(), rsyncTargetGVR),
+		rsyncTargetSynced: rsyncTargetInformer.HasSynced,
+		jobLister:         informerFactory.Batch().V1().Jobs().Lister(),
+		jobSynced:         jobInformer.HasSynced,
+		workqueue:         workqueue.NewRateLimitingQueue(workqueue.DefaultControllerRateLimiter()),
+	}
+
+	rsyncTargetInformer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: c.handle,
+		UpdateFunc: func(oldObj, newObj interface{}) {
+			c.handle(newObj)
+		},
+		DeleteFunc: c.handle,
+	})
+
+	jobInformer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: c.handleJob,
+		UpdateFunc: func(oldObj, newObj interface{}) {
+			c.handleJob(newObj)
+		},
+		DeleteFunc: c.handleJob,
+	})
+
+	dynamicInformerFactory.Start(stopCh)
+	informerFactory.Start(stopCh)
+	if err := c.run(stopCh); nil != err {
+		klog.Fatalf("Failed to run controller: %v", err)
+	}
+}
+
+func (c *controller) handle(obj interface{}) {
+	key, err := cache.DeletionHandlingMetaNamespaceKeyFunc(obj)
+	if err != nil {
+		utilruntime.HandleError(err)
+		return
+	}
+	c.workqueue.Add(key)
+}
+
+// handleJob queues the rsync target of a job. Jobs of scheduled targets
+// are owned by a CronJob, so the target is found with the name label.
+func (c *controller) handleJob(obj interface{}) {
+	if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+		obj = tombstone.Obj
+	}
+	job, ok := obj.(*batchv1.Job)
+	if !ok {
+		return
+	}
+	name, ok := job.GetLabels()[constant.NameLabel]
+	if !ok {
+		return
+	}
+	c.workqueue.Add(job.GetNamespace() + "/" + name)
+}
+
+func (c *controller) run(stopCh <-chan struct{}) error {
+	defer utilruntime.HandleCrash()
+	defer c.workqueue.ShutDown()
+
+	if ok := cache.WaitForCacheSync(stopCh, c.rsyncTargetSynced, c.jobSynced); !ok {
+		return fmt.Errorf("failed to wait for caches to sync")
+	}
+
+	go wait.Until(c.runWorker, time.Second, stopCh)
+	<-stopCh
+	return nil
+}
+
+func (c *controller) runWorker() {
+	processNext := func(obj interface{}) error {
+		defer c.workqueue.Done(obj)
+		var key string
+		var ok bool
+		if key, ok = obj.(string); !ok {
+			c.workqueue.Forget(obj)
+			utilruntime.HandleError(fmt.Errorf("expected string in workqueue but got %#v", obj))
+			return nil
+		}
+		parts := strings.Split(key, "/")
+		if len(parts) != 2 {
+			utilruntime.HandleError(fmt.Errorf("invalid resource key: %s", key))
+			return nil
+		}
+		if err := c.syncRsyncTarget(context.TODO(), key, parts[0], parts[1]); err != nil {
+			c.workqueue.AddRateLimited(key)
+			return fmt.Errorf("error syncing '%s': %s, requeuing", key, err.Error())
+		}
+		c.workqueue.Forget(obj)
+		return nil
+	}
+
+	for {
+		obj, shutdown := c.workqueue.Get()
+		if shutdown {
+			return
+		}
+		if err := processNext(obj); err != nil {
+			utilruntime.HandleError(err)
+		}
+	}
+}
+
+func (c *controller) syncRsyncTarget(ctx context.Context, key, namespace, name string) error {
+	unstruct, err := c.rsyncTargetLister.Namespace(namespace).Get(name)
+	if err != nil {
+		if errors.IsNotFound(err) {
+			deleteMetrics(namespace, name)
+			utilruntime.HandleError(fmt.Errorf("rsync target '%s' in work queue no longer exists", key))
+			return nil
+		}
+		return fmt.Errorf("error getting rsync target, error: %s", err)
+	}
+	rsyncTarget := RsyncTarget{}
+	if err := runtime.DefaultUnstructuredConverter.FromUnstructured(unstruct.UnstructuredContent(),
+		&rsyncTarget); err != nil {
+		return fmt.Errorf("error converting rsync target `%s` in `%s` namespace error: %s",
+			unstruct.GetName(), unstruct.GetNamespace(), err)
+	}
+	if rsyncTarget.DeletionTimestamp != nil {
+		// The secret and the job are garbage collected with the target.
+		return nil
+	}
+	scheduled := rsyncTarget.Spec.Schedule != ""
+	if !scheduled &&
+		(rsyncTarget.Status.Phase == RsyncTargetSucceeded || rsyncTarget.Status.Phase == RsyncTargetFailed) {
+		return nil
+	}
+	status := rsyncTarget.Status.DeepCopy()
+	if status.Phase == "" {
+		status.Phase = RsyncTargetPending
+	}
+
+	// The password of the source is copied into the namespace of the target,
+	// so a target can only pull from a source in its own namespace.
+	sourceNamespace := rsyncTarget.Spec.SourceRef.Namespace
+	if sourceNamespace != "" && sourceNamespace != namespace {
+		meta.SetStatusCondition(&status.Conditions, metav1.Condition{
+			Type:   sourceReadyConditionType,
+			Status: metav1.ConditionFalse,
+			Reason: "CrossNamespaceReference",
+			Message: fmt.Sprintf("rsync source `%s/%s` is not in the namespace of the target",
+				sourceNamespace, rsyncTarget.Spec.SourceRef.Name),
+		})
+		return c.updateRsyncTargetStatus(ctx, &rsyncTarget, status)
+	}
+	sourceNamespace = namespace
+	sourceUnstruct, err := c.dynamicClient.Resource(rsyncSourceGVR).Namespace(sourceNamespace).
+		Get(ctx, rsyncTarget.Spec.SourceRef.Name, metav1.GetOptions{})
+	if err != nil {
+		if !errors.IsNotFound(err) {
+			return err
+		}
+		meta.SetStatusCondition(&status.Conditions, metav1.Condition{
+			Type:    sourceReadyConditionType,
+			Status:  metav1.ConditionFalse,
+			Reason:  "SourceNotFound",
+			Message: fmt.Sprintf("rsync source `%s/%s` not found", sourceNamespace, rsyncTarget.Spec.SourceRef.Name),
+		})
+		c.workqueue.AddAfter(key, 30*time.Second)
+		return c.updateRsyncTargetStatus(ctx, &rsyncTarget, status)
+	}
+	rsyncSource := internalv1.RsyncSource{}
+	if err := runtime.DefaultUnstructuredConverter.FromUnstructured(sourceUnstruct.UnstructuredContent(),
+		&rsyncSource); err != nil {
+		return fmt.Errorf("error converting rsync source `%s` in `%s` namespace error: %s",
+			sourceUnstruct.GetName(), sourceUnstruct.GetNamespace(), err)
+	}
+	meta.SetStatusCondition(&status.Conditions, metav1.Condition{
+		Type:    sourceReadyConditionType,
+		Status:  metav1.ConditionTrue,
+		Reason:  "SourceFound",
+		Message: fmt.Sprintf("rsync source `%s/%s` found", sourceNamespace, rsyncTarget.Spec.SourceRef.Name),
+	})
+
+	if scheduled {
+		if _, err := cron.ParseStandard(rsyncTarget.Spec.Schedule); err != nil {
+			meta.SetStatusCondition(&status.Conditions, metav1.Condition{
+				Type:    scheduleValidConditionType,
+				Status:  metav1.ConditionFalse,
+				Reason:  "InvalidSchedule",
+				Message: fmt.Sprintf("invalid schedule `%s`, error: %s", rsyncTarget.Spec.Schedule, err),
+			})
+			return c.updateRsyncTargetStatus(ctx, &rsyncTarget, status)
+		}
+		meta.SetStatusCondition(&status.Conditions, metav1.Condition{
+			Type:    scheduleValidConditionType,
+			Status:  metav1.ConditionTrue,
+			Reason:  "ValidSchedule",
+			Message: fmt.Sprintf("runs are scheduled with `%s`", rsyncTarget.Spec.Schedule),
+		})
+	}
+
+	tc := templateConfigFromRsyncTarget(rsyncTarget, rsyncSource)
+	if err := c.ensureSecret(ctx, namespace, tc.getSecretTemplate()); err != nil {
+		return fmt.Errorf("error ensuring secret for rsync target `%s` in `%s` namespace error: %s",
+			name, namespace, err)
+	}
+	if scheduled {
+		if err := c.syncSchedule(ctx, tc, status); err != nil {
+			return fmt.Errorf("error syncing schedule of rsync target `%s` in `%s` namespace error: %s",
+				name, namespace, err)
+		}
+		return c.updateRsyncTargetStatus(ctx, &rsyncTarget, status)
+	}
+	// The schedule was removed, the target runs once.
+	if err := c.deleteCronJob(ctx, tc); err != nil {
+		return fmt.Errorf("error deleting cron job of rsync target `%s` in `%s` namespace error: %s",
+			name, namespace, err)
+	}
+	syncLag.delete(namespace, name)
+	status.LastScheduleTime = nil
+	status.Lag = nil
+	meta.RemoveStatusCondition(&status.Conditions, scheduleValidConditionType)
+	job, err := c.ensureJob(ctx, namespace, tc.getJobTemplate())
+	if err != nil {
+		return fmt.Errorf("error ensuring job for rsync target `%s` in `%s` namespace error: %s",
+			name, namespace, err)
+	}
+	if err := c.updateStatusFromJob(ctx, job, status); err != nil {
+		return err
+	}
+	return c.updateRsyncTargetStatus(ctx, &rsyncTarget, status)
+}
+
+/*
+if found and not created by the controller then return error
+if found return it
+if !found -> create return it
+*/
+func (c *controller) ensureJob(ctx context.Context, namespace string, job *batchv1.Job) (*batchv1.Job, error) {
+	obj, err := c.jobLister.Jobs(namespace).Get(job.GetName())
+	if err != nil {
+		if !errors.IsNotFound(err) {
+			return nil, err
+		}
+		return c.kubeClient.BatchV1().Jobs(namespace).Create(ctx, job.DeepCopy(), metav1.CreateOptions{})
+	}
+	if owner := metav1.GetControllerOf(obj); owner == nil || owner.UID != job.OwnerReferences[0].UID {
+		return nil, fmt.Errorf("resource found but not created by this operator")
+	}
+	return obj, nil
+}
+
+/*
+if found and not created by the controller then return error
+if found -> update spec if changed return it
+if !found -> create return it
+*/
+func (c *controller) ensureCronJob(ctx context.Context, namespace string, cronJob *batchv1.CronJob) (*batchv1.CronJob, error) {
+	obj, err := c.kubeClient.BatchV1().CronJobs(namespace).Get(ctx, cronJob.GetName(), metav1.GetOptions{})
+	if err != nil {
+		if !errors.IsNotFound(err) {
+			return nil, err
+		}
+		return c.kubeClient.BatchV1().CronJobs(namespace).Create(ctx, cronJob.DeepCopy(), metav1.CreateOptions{})
+	}
+	if owner := metav1.GetControllerOf(obj); owner == nil || owner.UID != cronJob.OwnerReferences[0].UID {
+		return nil, fmt.Errorf("resource found but not created by this operator")
+	}
+	if !isCronJobUpdateRequired(obj, cronJob) {
+		return obj, nil
+	}
+	clone := obj.DeepCopy()
+	clone.Annotations = cronJob.Annotations
+	clone.Spec.Schedule = cronJob.Spec.Schedule
+	clone.Spec.Suspend = cronJob.Spec.Suspend
+	clone.Spec.ConcurrencyPolicy = cronJob.Spec.ConcurrencyPolicy
+	clone.Spec.StartingDeadlineSeconds = cronJob.Spec.StartingDeadlineSeconds
+	clone.Spec.SuccessfulJobsHistoryLimit = cronJob.Spec.SuccessfulJobsHistoryLimit
+	clone.Spec.FailedJobsHistoryLimit = cronJob.Spec.FailedJobsHistoryLimit
+	clone.Spec.JobTemplate = cronJob.Spec.JobTemplate
+	return c.kubeClient.BatchV1().CronJobs(namespace).Update(ctx, clone, metav1.UpdateOptions{})
+}
+
+// deleteCronJob deletes the CronJob of a target that is no longer
+// scheduled, together with its jobs.
+func (c *controller) deleteCronJob(ctx context.Context, tc *templateConfig) error {
+	obj, err := c.kubeClient.BatchV1().CronJobs(tc.namespace).Get(ctx, tc.name, metav1.GetOptions{})
+	if err != nil {
+		if errors.IsNotFound(err) {
+			return nil
+		}
+		return err
+	}
+	if owner := metav1.GetControllerOf(obj); owner == nil || owner.UID != tc.owner.UID {
+		return nil
+	}
+	propagation := metav1.DeletePropagationBackground
+	err = c.kubeClient.BatchV1().CronJobs(tc.namespace).Delete(ctx, obj.GetName(), metav1.DeleteOptions{
+		Preconditions:     &metav1.Preconditions{UID: &obj.UID},
+		PropagationPolicy: &propagation,
+	})
+	if errors.IsNotFound(err) {
+		return nil
+	}
+	return err
+}
+
+/*
+if found and not created by the controller then return error
+if found -> update data if changed return error/nil
+if !found -> create return error/nil
+*/
+func (c *controller) ensureSecret(ctx context.Context, namespace string, secret *corev1.Secret) error {
+	obj, err := c.kubeClient.CoreV1().Secrets(namespace).Get(ctx, secret.GetName(), metav1.GetOptions{})
+	if err != nil {
+		if !errors.IsNotFound(err) {
+			return err
+		}
+		_, err := c.kubeClient.CoreV1().Secrets(namespace).Create(ctx, secret.DeepCopy(), metav1.CreateOptions{})
+		return err
+	}
+	if obj.GetLabels()[constant.CreatedByLabel] != componentNameRsyncTargetController {
+		return fmt.Errorf("resource found but not created by this operator")
+	}
+	// The password of the source may have been rotated, later runs read it
+	// from the copy.
+	if reflect.DeepEqual(obj.Data, secret.Data) {
+		return nil
+	}
+	clone := obj.DeepCopy()
+	clone.Data = secret.Data
+	_, err = c.kubeClient.CoreV1().Secrets(namespace).Update(ctx, clone, metav1.UpdateOptions{})
+	return err
+}
+
+// updateStatusFromJob copies the progress of the client job to the status.
+// Once the job finished the exit code and the transfer statistics are read
+// from the terminated client container.
+func (c *controller) updateStatusFromJob(ctx context.Context, job *batchv1.Job, status *RsyncTargetStatus) error {
+	if job.Status.StartTime != nil {
+		status.StartTime = job.Status.StartTime
+		status.Phase = RsyncTargetRunning
+	}
+	finished := isFinished(job)
+	if finished == nil {
+		meta.SetStatusCondition(&status.Conditions, metav1.Condition{
+			Type:    completeConditionType,
+			Status:  metav1.ConditionFalse,
+			Reason:  "JobRunning",
+			Message: fmt.Sprintf("job `%s` is running", job.GetName()),
+		})
+		return nil
+	}
+
+	terminated, err := c.lastTerminatedClient(ctx, job)
+	if err != nil {
+		return err
+	}
+	if terminated != nil {
+		exitCode := terminated.ExitCode
+		status.ExitCode = &exitCode
+		status.BytesTransferred, status.FilesTransferred = parseTransferStats(terminated.Message)
+	}
+	completionTime := finished.LastTransitionTime
+	status.CompletionTime = &completionTime
+	if finished.Type == batchv1.JobComplete {
+		status.Phase = RsyncTargetSucceeded
+	} else {
+		status.Phase = RsyncTargetFailed
+	}
+	meta.SetStatusCondition(&status.Conditions, metav1.Condition{
+		Type:    completeConditionType,
+		Status:  metav1.ConditionTrue,
+		Reason:  "Job" + string(finished.Type),
+		Message: finished.Message,
+	})
+	return nil
+}
+
+// syncSchedule ensures the CronJob of a scheduled target and copies the
+// result of its last finished run to the status.
+func (c *controller) syncSchedule(ctx context.Context, tc *templateConfig, status *RsyncTargetStatus) error {
+	cronJob, err := c.ensureCronJob(ctx, tc.namespace, tc.getCronJobTemplate())
+	if err != nil {
+		return err
+	}
+	status.LastScheduleTime = cronJob.Status.LastScheduleTime
+
+	jobs, err := c.jobLister.Jobs(tc.namespace).List(labels.SelectorFromSet(tc.labels()))
+	if err != nil {
+		return err
+	}
+	var lastFinished, lastSucceeded *batchv1.Job
+	active := false
+	for _, job := range jobs {
+		if owner := metav1.GetControllerOf(job); owner == nil || owner.UID != cronJob.GetUID() {
+			continue
+		}
+		finished := isFinished(job)
+		if finished == nil {
+			active = true
+			continue
+		}
+		if lastFinished == nil || isFinished(lastFinished).LastTransitionTime.Before(&finished.LastTransitionTime) {
+			lastFinished = job
+		}
+		if finished.Type == batchv1.JobComplete && job.Status.StartTime != nil &&
+			(lastSucceeded == nil || lastSucceeded.Status.StartTime.Before(job.Status.StartTime)) {
+			lastSucceeded = job
+		}
+	}
+
+	// The statistics of a run are read once, when it shows up as the last
+	// finished run.
+	if lastFinished != nil && (status.CompletionTime == nil ||
+		!status.CompletionTime.Equal(&isFinished(lastFinished).LastTransitionTime)) {
+		if err := c.updateStatusFromJob(ctx, lastFinished, status); err != nil {
+			return err
+		}
+	}
+	if lastSucceeded != nil && (status.LastSuccessfulSyncTime == nil ||
+		status.LastSuccessfulSyncTime.Before(lastSucceeded.Status.StartTime)) {
+		status.LastSuccessfulSyncTime = lastSucceeded.Status.StartTime
+	}
+	if status.LastSuccessfulSyncTime != nil {
+		// The lag only changes with a new run, the metric reports the
+		// current age of the last successful run.
+		if status.LastScheduleTime != nil {
+			lag := status.LastScheduleTime.Sub(status.LastSuccessfulSyncTime.Time)
+			if lag < 0 {
+				lag = 0
+			}
+			status.Lag = &metav1.Duration{Duration: lag.Round(time.Second)}
+		}
+		lastSuccessfulSyncTimestamp.WithLabelValues(tc.namespace, tc.name).
+			Set(float64(status.LastSuccessfulSyncTime.Unix()))
+		syncLag.set(tc.namespace, tc.name, status.LastSuccessfulSyncTime.Time)
+	}
+	if active {
+		status.Phase = RsyncTargetRunning
+	} else {
+		status.Phase = RsyncTargetScheduled
+	}
+	return nil
+}
+
+// lastTerminatedClient returns the terminated state of the client container
+// of the most recent pod of a job.
+func (c *controller) lastTerminatedClient(ctx context.Context, job *batchv1.Job) (*corev1.ContainerStateTerminated, error) {
+	pods, err := c.kubeClient.CoreV1().Pods(job.GetNamespace()).List(ctx, metav1.ListOptions{
+		LabelSelector: metav1.FormatLabelSelector(job.Spec.Selector),
+	})
+	if err != nil {
+		return nil, err
+	}
+	var last *corev1.ContainerStateTerminated
+	for _, pod := range pods.Items {
+		for _, cs := range pod.Status.ContainerStatuses {
+			t := cs.State.Terminated
+			if cs.Name != "rsync-client" || t == nil {
+				continue
+			}
+			if last == nil || last.FinishedAt.Before(&t.FinishedAt) {
+				last = t
+			}
+		}
+	}
+	return last, nil
+}
+
+// parseTransferStats reads the received bytes and the transferred files from
+// the `--stats` output of the rsync client.
+func parseTransferStats(stats string) (int64, int64) {
+	var bytes, files int64
+	for _, line := range strings.Split(stats, "\n") {
+		parts := strings.SplitN(line, ":", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		fields := strings.Fields(parts[1])
+		if len(fields) == 0 {
+			continue
+		}
+		value, err := strconv.ParseInt(strings.ReplaceAll(fields[0], ",", ""), 10, 64)
+		if err != nil {
+			continue
+		}
+		switch {
+		case parts[0] == "Total bytes received":
+			bytes = value
+		case strings.HasPrefix(parts[0], "Number of") && strings.HasSuffix(parts[0], "files transferred"):
+			files = value
+		}
+	}
+	return bytes, files
+}
+
+// updateRsyncTargetStatus updates the status of a rsync target if it changed.
+func (c *controller) updateRsyncTargetStatus(ctx context.Context, cr *RsyncTarget, status *RsyncTargetStatus) error {
+	if reflect.DeepEqual(cr.Status, *status) {
+		return nil
+	}
+	clone := cr.DeepCopy()
+	clone.Status = *status
+	rtMap, err := runtime.DefaultUnstructuredConverter.ToUnstructured(clone)
+	if err != nil {
+		return err
+	}
+	_, err = c.dynamicClient.Resource(rsyncTargetGVR).Namespace(clone.GetNamespace()).
+		UpdateStatus(ctx, &unstructured.Unstructured{Object: rtMap}, metav1.UpdateOptions{})
+	return err
+}
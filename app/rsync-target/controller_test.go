@@ -0,0 +1,217 @@
+package main
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+
+	"github.com/k8s-volume-copy/types/constant"
+)
+
+func TestEnsureSecret(t *testing.T) {
+	template := testTemplateConfig(RsyncTargetSpec{}).getSecretTemplate()
+	existing := func(labels map[string]string, password string) *corev1.Secret {
+		return &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: "backup", Namespace: "app", Labels: labels},
+			Data:       map[string][]byte{"RSYNC_PASSWORD": []byte(password)},
+		}
+	}
+	tests := []struct {
+		name     string
+		existing []runtime.Object
+		wantData map[string][]byte
+		wantErr  bool
+	}{
+		{
+			name:     "created",
+			wantData: map[string][]byte{"RSYNC_PASSWORD": []byte("pass")},
+		},
+		{
+			name:     "unchanged",
+			existing: []runtime.Object{existing(template.Labels, "pass")},
+			wantData: map[string][]byte{"RSYNC_PASSWORD": []byte("pass")},
+		},
+		{
+			name:     "rotated password",
+			existing: []runtime.Object{existing(template.Labels, "old")},
+			wantData: map[string][]byte{"RSYNC_PASSWORD": []byte("pass")},
+		},
+		{
+			name:     "not created by the controller",
+			existing: []runtime.Object{existing(map[string]string{constant.CreatedByLabel: "someone"}, "old")},
+			wantData: map[string][]byte{"RSYNC_PASSWORD": []byte("old")},
+			wantErr:  true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &controller{kubeClient: fake.NewSimpleClientset(tt.existing...)}
+			err := c.ensureSecret(context.TODO(), "app", template)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ensureSecret() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			got, err := c.kubeClient.CoreV1().Secrets("app").Get(context.TODO(), "backup", metav1.GetOptions{})
+			if err != nil {
+				t.Fatalf("getting secret error = %v", err)
+			}
+			if !reflect.DeepEqual(got.Data, tt.wantData) {
+				t.Errorf("ensureSecret() data = %s, want %s", got.Data, tt.wantData)
+			}
+		})
+	}
+}
+
+func testJob(started *metav1.Time, conditions ...batchv1.JobCondition) *batchv1.Job {
+	return &batchv1.Job{
+		ObjectMeta: metav1.ObjectMeta{Name: "backup", Namespace: "app", UID: "job-uid"},
+		Spec: batchv1.JobSpec{
+			Selector: &metav1.LabelSelector{MatchLabels: map[string]string{"job-name": "backup"}},
+		},
+		Status: batchv1.JobStatus{StartTime: started, Conditions: conditions},
+	}
+}
+
+func testClientPod(name string, finished time.Time, exitCode int32, message string) *corev1.Pod {
+	return &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "app", Labels: map[string]string{"job-name": "backup"}},
+		Status: corev1.PodStatus{ContainerStatuses: []corev1.ContainerStatus{{
+			Name: "rsync-client",
+			State: corev1.ContainerState{Terminated: &corev1.ContainerStateTerminated{
+				ExitCode:   exitCode,
+				FinishedAt: metav1.NewTime(finished),
+				Message:    message,
+			}},
+		}}},
+	}
+}
+
+func TestUpdateStatusFromJob(t *testing.T) {
+	started := metav1.NewTime(time.Date(2026, 10, 18, 2, 0, 0, 0, time.UTC))
+	finished := metav1.NewTime(started.Add(time.Hour))
+	exitCode := func(i int32) *int32 { return &i }
+	tests := []struct {
+		name          string
+		job           *batchv1.Job
+		pods          []runtime.Object
+		wantPhase     RsyncTargetPhase
+		wantExitCode  *int32
+		wantBytes     int64
+		wantFiles     int64
+		wantCompleted *metav1.Time
+		wantReason    string
+	}{
+		{
+			name:       "pending",
+			job:        testJob(nil),
+			wantPhase:  RsyncTargetPending,
+			wantReason: "JobRunning",
+		},
+		{
+			name:       "running",
+			job:        testJob(&started),
+			wantPhase:  RsyncTargetRunning,
+			wantReason: "JobRunning",
+		},
+		{
+			name: "succeeded",
+			job: testJob(&started, batchv1.JobCondition{
+				Type: batchv1.JobComplete, Status: corev1.ConditionTrue, LastTransitionTime: finished,
+			}),
+			pods: []runtime.Object{
+				testClientPod("backup-1", finished.Time,
+					0, "Number of regular files transferred: 1,204\nTotal bytes received: 2,097,152\n"),
+			},
+			wantPhase:     RsyncTargetSucceeded,
+			wantExitCode:  exitCode(0),
+			wantBytes:     2097152,
+			wantFiles:     1204,
+			wantCompleted: &finished,
+			wantReason:    "JobComplete",
+		},
+		{
+			name: "failed after retries",
+			job: testJob(&started, batchv1.JobCondition{
+				Type: batchv1.JobFailed, Status: corev1.ConditionTrue, LastTransitionTime: finished,
+			}),
+			pods: []runtime.Object{
+				testClientPod("backup-1", started.Add(time.Minute), 10, ""),
+				testClientPod("backup-2", started.Add(2*time.Minute), 23, "Total bytes received: 512\n"),
+			},
+			wantPhase:     RsyncTargetFailed,
+			wantExitCode:  exitCode(23),
+			wantBytes:     512,
+			wantCompleted: &finished,
+			wantReason:    "JobFailed",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &controller{kubeClient: fake.NewSimpleClientset(tt.pods...)}
+			status := &RsyncTargetStatus{Phase: RsyncTargetPending}
+			if err := c.updateStatusFromJob(context.TODO(), tt.job, status); err != nil {
+				t.Fatalf("updateStatusFromJob() error = %v", err)
+			}
+			if status.Phase != tt.wantPhase {
+				t.Errorf("updateStatusFromJob() phase = %s, want %s", status.Phase, tt.wantPhase)
+			}
+			if !reflect.DeepEqual(status.ExitCode, tt.wantExitCode) {
+				t.Errorf("updateStatusFromJob() exit code = %v, want %v", status.ExitCode, tt.wantExitCode)
+			}
+			if status.BytesTransferred != tt.wantBytes || status.FilesTransferred != tt.wantFiles {
+				t.Errorf("updateStatusFromJob() transferred = %d bytes %d files, want %d bytes %d files",
+					status.BytesTransferred, status.FilesTransferred, tt.wantBytes, tt.wantFiles)
+			}
+			if !reflect.DeepEqual(status.CompletionTime, tt.wantCompleted) {
+				t.Errorf("updateStatusFromJob() completion time = %v, want %v", status.CompletionTime, tt.wantCompleted)
+			}
+			if len(status.Conditions) != 1 || status.Conditions[0].Reason != tt.wantReason {
+				t.Errorf("updateStatusFromJob() conditions = %v, want reason %s", status.Conditions, tt.wantReason)
+			}
+		})
+	}
+}
+
+func TestParseTransferStats(t *testing.T) {
+	tests := []struct {
+		name      string
+		stats     string
+		wantBytes int64
+		wantFiles int64
+	}{
+		{
+			name:  "empty",
+			stats: "",
+		},
+		{
+			name:      "rsync 3.2",
+			stats:     "Number of regular files transferred: 3,450\nTotal bytes received: 1,234,567\n",
+			wantBytes: 1234567,
+			wantFiles: 3450,
+		},
+		{
+			name:      "rsync 3.0",
+			stats:     "Number of files transferred: 12\nTotal bytes received: 4096\n",
+			wantBytes: 4096,
+			wantFiles: 12,
+		},
+		{
+			name:  "unparsable values",
+			stats: "Total bytes received: lots\nNumber of files transferred:\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			bytes, files := parseTransferStats(tt.stats)
+			if bytes != tt.wantBytes || files != tt.wantFiles {
+				t.Errorf("parseTransferStats() = %d, %d, want %d, %d", bytes, files, tt.wantBytes, tt.wantFiles)
+			}
+		})
+	}
+}
@@ -0,0 +1,21 @@
+package main
+
+import (
+	batchv1 "k8s.io/api/batch/v1"
+)
+
+func isCronJobUpdateRequired(old, new *batchv1.CronJob) bool {
+	return old.GetAnnotations()[specHashAnnotation] != new.GetAnnotations()[specHashAnnotation]
+}
+
+// isFinished returns the condition of a finished job or nil.
+func isFinished(job *batchv1.Job) *batchv1.JobCondition {
+	for i := range job.Status.Conditions {
+		cond := &job.Status.Conditions[i]
+		if (cond.Type == batchv1.JobComplete || cond.Type == batchv1.JobFailed) &&
+			cond.Status == "True" {
+			return cond
+		}
+	}
+	return nil
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"k8s.io/klog/v2"
+)
+
+var (
+	lastSuccessfulSyncTimestamp = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "rsync_target_last_successful_sync_timestamp_seconds",
+		Help: "Start time of the last successful run of a rsync target.",
+	}, []string{"namespace", "name"})
+
+	syncLag = &lagCollector{
+		desc: prometheus.NewDesc("rsync_target_sync_lag_seconds",
+			"Age of the last successful run of a scheduled rsync target.",
+			[]string{"namespace", "name"}, nil),
+		lastSuccess: map[[2]string]time.Time{},
+	}
+)
+
+func init() {
+	prometheus.MustRegister(lastSuccessfulSyncTimestamp, syncLag)
+}
+
+// lagCollector computes the lag of scheduled targets when it is scraped, so
+// the targets don't have to be synced to keep it current.
+type lagCollector struct {
+	desc        *prometheus.Desc
+	mu          sync.Mutex
+	lastSuccess map[[2]string]time.Time
+}
+
+func (l *lagCollector) set(namespace, name string, lastSuccess time.Time) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.lastSuccess[[2]string{namespace, name}] = lastSuccess
+}
+
+func (l *lagCollector) delete(namespace, name string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	delete(l.lastSuccess, [2]string{namespace, name})
+}
+
+func (l *lagCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- l.desc
+}
+
+func (l *lagCollector) Collect(ch chan<- prometheus.Metric) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for key, lastSuccess := range l.lastSuccess {
+		ch <- prometheus.MustNewConstMetric(l.desc, prometheus.GaugeValue,
+			time.Since(lastSuccess).Seconds(), key[0], key[1])
+	}
+}
+
+// deleteMetrics removes the metrics of a deleted rsync target.
+func deleteMetrics(namespace, name string) {
+	lastSuccessfulSyncTimestamp.DeleteLabelValues(namespace, name)
+	syncLag.delete(namespace, name)
+}
+
+func serveMetrics(address string) {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.Handler())
+	if err := http.ListenAndServe(address, mux); err != nil {
+		klog.Fatalf("Failed to serve metrics: %v", err)
+	}
+}
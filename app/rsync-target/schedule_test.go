@@ -0,0 +1,218 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/fake"
+	batchlisters "k8s.io/client-go/listers/batch/v1"
+	"k8s.io/client-go/tools/cache"
+
+	"github.com/k8s-volume-copy/types/constant"
+)
+
+func TestGetCronJobTemplate(t *testing.T) {
+	rsyncClientImage = "rsync-client"
+	spec := RsyncTargetSpec{DestinationPVC: "restore", Schedule: "0 * * * *"}
+	tc := testTemplateConfig(spec)
+	cronJob := tc.getCronJobTemplate()
+	if cronJob.Spec.Schedule != "0 * * * *" {
+		t.Errorf("getCronJobTemplate() schedule = %s, want 0 * * * *", cronJob.Spec.Schedule)
+	}
+	if cronJob.Spec.ConcurrencyPolicy != batchv1.ForbidConcurrent {
+		t.Errorf("getCronJobTemplate() concurrency = %s, want Forbid", cronJob.Spec.ConcurrencyPolicy)
+	}
+	if got := cronJob.Spec.JobTemplate.Spec.Template.Spec.Containers[0].Command; len(got) != 5 || got[4] != "--archive" {
+		t.Errorf("getCronJobTemplate() runs command %v, want the client job", got)
+	}
+	if got := cronJob.Spec.JobTemplate.Labels; got[constant.NameLabel] != "backup" {
+		t.Errorf("getCronJobTemplate() job labels = %v, want the target name", got)
+	}
+
+	tests := []struct {
+		name       string
+		change     func(tc *templateConfig)
+		wantUpdate bool
+	}{
+		{
+			name:   "unchanged",
+			change: func(tc *templateConfig) {},
+		},
+		{
+			name:       "schedule",
+			change:     func(tc *templateConfig) { tc.target.Schedule = "*/5 * * * *" },
+			wantUpdate: true,
+		},
+		{
+			name: "suspended",
+			change: func(tc *templateConfig) {
+				suspend := true
+				tc.target.Suspend = &suspend
+			},
+			wantUpdate: true,
+		},
+		{
+			name:       "source username",
+			change:     func(tc *templateConfig) { tc.source.Spec.Username = "reader" },
+			wantUpdate: true,
+		},
+		{
+			// The password is read from the secret by every run.
+			name:   "source password",
+			change: func(tc *templateConfig) { tc.source.Spec.Password = "rotated" },
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			changed := testTemplateConfig(spec)
+			tt.change(changed)
+			if got := isCronJobUpdateRequired(cronJob, changed.getCronJobTemplate()); got != tt.wantUpdate {
+				t.Errorf("isCronJobUpdateRequired() = %t, want %t", got, tt.wantUpdate)
+			}
+		})
+	}
+}
+
+func testRun(name string, owner types.UID, started time.Time, condition batchv1.JobConditionType, finished time.Time) *batchv1.Job {
+	isController := true
+	job := &batchv1.Job{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: "app",
+			Labels:    testTemplateConfig(RsyncTargetSpec{}).labels(),
+			OwnerReferences: []metav1.OwnerReference{{
+				APIVersion: "batch/v1", Kind: "CronJob", Name: "backup", UID: owner, Controller: &isController,
+			}},
+		},
+		Spec: batchv1.JobSpec{
+			Selector: &metav1.LabelSelector{MatchLabels: map[string]string{"job-name": name}},
+		},
+	}
+	startTime := metav1.NewTime(started)
+	job.Status.StartTime = &startTime
+	if condition != "" {
+		job.Status.Conditions = []batchv1.JobCondition{{
+			Type: condition, Status: corev1.ConditionTrue, LastTransitionTime: metav1.NewTime(finished),
+		}}
+	}
+	return job
+}
+
+func TestSyncSchedule(t *testing.T) {
+	rsyncClientImage = "rsync-client"
+	base := time.Date(2026, 10, 18, 0, 0, 0, 0, time.UTC)
+	at := func(hours int) time.Time { return base.Add(time.Duration(hours) * time.Hour) }
+	tests := []struct {
+		name                 string
+		jobs                 []*batchv1.Job
+		wantPhase            RsyncTargetPhase
+		wantLastSuccess      *time.Time
+		wantLag              *time.Duration
+		wantCompletionTime   *time.Time
+		wantLastRunSucceeded bool
+	}{
+		{
+			name:      "no runs yet",
+			wantPhase: RsyncTargetScheduled,
+		},
+		{
+			name:      "first run active",
+			jobs:      []*batchv1.Job{testRun("backup-1", "cron-uid", at(3), "", time.Time{})},
+			wantPhase: RsyncTargetRunning,
+		},
+		{
+			name: "last run succeeded",
+			jobs: []*batchv1.Job{
+				testRun("backup-1", "cron-uid", at(1), batchv1.JobComplete, at(1).Add(time.Minute)),
+				testRun("backup-2", "cron-uid", at(3), batchv1.JobComplete, at(3).Add(time.Minute)),
+			},
+			wantPhase:            RsyncTargetScheduled,
+			wantLastSuccess:      timePtr(at(3)),
+			wantLag:              durationPtr(0),
+			wantCompletionTime:   timePtr(at(3).Add(time.Minute)),
+			wantLastRunSucceeded: true,
+		},
+		{
+			name: "last run failed",
+			jobs: []*batchv1.Job{
+				testRun("backup-1", "cron-uid", at(1), batchv1.JobComplete, at(1).Add(time.Minute)),
+				testRun("backup-2", "cron-uid", at(2), batchv1.JobFailed, at(2).Add(time.Minute)),
+			},
+			wantPhase:          RsyncTargetScheduled,
+			wantLastSuccess:    timePtr(at(1)),
+			wantLag:            durationPtr(2 * time.Hour),
+			wantCompletionTime: timePtr(at(2).Add(time.Minute)),
+		},
+		{
+			name: "runs of another CronJob ignored",
+			jobs: []*batchv1.Job{
+				testRun("backup-1", "cron-uid", at(1), batchv1.JobComplete, at(1).Add(time.Minute)),
+				testRun("other-1", "other-uid", at(3), batchv1.JobComplete, at(3).Add(time.Minute)),
+			},
+			wantPhase:            RsyncTargetScheduled,
+			wantLastSuccess:      timePtr(at(1)),
+			wantLag:              durationPtr(2 * time.Hour),
+			wantCompletionTime:   timePtr(at(1).Add(time.Minute)),
+			wantLastRunSucceeded: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tc := testTemplateConfig(RsyncTargetSpec{DestinationPVC: "restore", Schedule: "0 * * * *"})
+			cronJob := tc.getCronJobTemplate()
+			cronJob.Namespace = "app"
+			cronJob.UID = "cron-uid"
+			lastSchedule := metav1.NewTime(at(3))
+			cronJob.Status.LastScheduleTime = &lastSchedule
+			indexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+			for _, job := range tt.jobs {
+				if err := indexer.Add(job); err != nil {
+					t.Fatalf("adding job error = %v", err)
+				}
+			}
+			c := &controller{
+				kubeClient: fake.NewSimpleClientset(cronJob),
+				jobLister:  batchlisters.NewJobLister(indexer),
+			}
+			status := &RsyncTargetStatus{}
+			if err := c.syncSchedule(context.TODO(), tc, status); err != nil {
+				t.Fatalf("syncSchedule() error = %v", err)
+			}
+			if status.Phase != tt.wantPhase {
+				t.Errorf("syncSchedule() phase = %s, want %s", status.Phase, tt.wantPhase)
+			}
+			if !status.LastScheduleTime.Equal(&lastSchedule) {
+				t.Errorf("syncSchedule() last schedule = %v, want %v", status.LastScheduleTime, lastSchedule)
+			}
+			if !equalTime(status.LastSuccessfulSyncTime, tt.wantLastSuccess) {
+				t.Errorf("syncSchedule() last success = %v, want %v", status.LastSuccessfulSyncTime, tt.wantLastSuccess)
+			}
+			if !equalTime(status.CompletionTime, tt.wantCompletionTime) {
+				t.Errorf("syncSchedule() completion time = %v, want %v", status.CompletionTime, tt.wantCompletionTime)
+			}
+			if (status.Lag == nil) != (tt.wantLag == nil) || (status.Lag != nil && status.Lag.Duration != *tt.wantLag) {
+				t.Errorf("syncSchedule() lag = %v, want %v", status.Lag, tt.wantLag)
+			}
+			succeeded := len(status.Conditions) == 1 && status.Conditions[0].Reason == "JobComplete"
+			if tt.wantCompletionTime != nil && succeeded != tt.wantLastRunSucceeded {
+				t.Errorf("syncSchedule() conditions = %v, want last run succeeded %t", status.Conditions, tt.wantLastRunSucceeded)
+			}
+		})
+	}
+}
+
+func timePtr(t time.Time) *time.Time { return &t }
+
+func durationPtr(d time.Duration) *time.Duration { return &d }
+
+func equalTime(got *metav1.Time, want *time.Time) bool {
+	if got == nil || want == nil {
+		return got == nil && want == nil
+	}
+	return got.Time.Equal(*want)
+}
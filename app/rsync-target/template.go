@@ -0,0 +1,225 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"path"
+
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	internalv1 "github.com/k8s-volume-copy/types/apis/demo.io/v1"
+	"github.com/k8s-volume-copy/types/constant"
+)
+
+const (
+	// componentNameRsyncTargetController is added as created-by label to
+	// the resources created by the rsync target controller.
+	componentNameRsyncTargetController = "rsync-target-controller"
+
+	defaultModule = "data"
+
+	// specHashAnnotation is added to the CronJob of a scheduled target. It
+	// changes whenever the target spec changes so the CronJob is updated.
+	specHashAnnotation = "demo.io/spec-hash"
+)
+
+// clientScript runs the rsync client with the flags passed as arguments.
+// The transfer statistics are written to the termination log so that the
+// controller can read them from the pod status.
+var clientScript = `
+rsync "$@" --stats "rsync://${RSYNC_USER}@${RSYNC_HOST}/${RSYNC_MODULE}/${RSYNC_PATH}" /dest/ > /tmp/rsync.log 2>&1
+rc=$?
+cat /tmp/rsync.log
+grep -E '^(Number of (regular )?files transferred|Total bytes received):' /tmp/rsync.log > /dev/termination-log
+exit $rc
+`
+
+type templateConfig struct {
+	name      string
+	namespace string
+	owner     metav1.OwnerReference
+	target    RsyncTargetSpec
+	source    internalv1.RsyncSource
+}
+
+func templateConfigFromRsyncTarget(cr RsyncTarget, source internalv1.RsyncSource) *templateConfig {
+	isController := true
+	tc := &templateConfig{
+		name:      cr.GetName(),
+		namespace: cr.GetNamespace(),
+		owner: metav1.OwnerReference{
+			APIVersion: cr.APIVersion,
+			Kind:       cr.Kind,
+			Name:       cr.GetName(),
+			UID:        cr.GetUID(),
+			Controller: &isController,
+		},
+		target: cr.Spec,
+		source: source,
+	}
+	if tc.target.Module == "" {
+		tc.target.Module = defaultModule
+	}
+	if len(tc.target.RsyncFlags) == 0 {
+		tc.target.RsyncFlags = []string{"--archive"}
+	}
+	if tc.target.Image == "" {
+		tc.target.Image = rsyncClientImage
+	}
+	if tc.target.ConcurrencyPolicy == "" {
+		tc.target.ConcurrencyPolicy = batchv1.ForbidConcurrent
+	}
+	return tc
+}
+
+// specHash returns a hash of the defaulted target spec and the source.
+func (tc *templateConfig) specHash() string {
+	raw, _ := json.Marshal(struct {
+		Target    RsyncTargetSpec
+		Source    string
+		Namespace string
+		Username  string
+	}{tc.target, tc.source.GetName(), tc.source.GetNamespace(), tc.source.Spec.Username})
+	sum := sha256.Sum256(raw)
+	return hex.EncodeToString(sum[:])[:16]
+}
+
+func (tc *templateConfig) labels() map[string]string {
+	return map[string]string{
+		constant.CreatedByLabel: componentNameRsyncTargetController,
+		constant.NameLabel:      tc.name,
+	}
+}
+
+func (tc *templateConfig) getSecretTemplate() *corev1.Secret {
+	secret := corev1.Secret{
+		TypeMeta: metav1.TypeMeta{
+			Kind:       "Secret",
+			APIVersion: "v1",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:            tc.name,
+			Labels:          tc.labels(),
+			OwnerReferences: []metav1.OwnerReference{tc.owner},
+		},
+		Data: map[string][]byte{
+			"RSYNC_PASSWORD": []byte(tc.source.Spec.Password),
+		},
+	}
+	return &secret
+}
+
+func (tc *templateConfig) getJobTemplate() *batchv1.Job {
+	job := batchv1.Job{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: "batch/v1",
+			Kind:       "Job",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:            tc.name,
+			Labels:          tc.labels(),
+			OwnerReferences: []metav1.OwnerReference{tc.owner},
+		},
+		Spec: batchv1.JobSpec{
+			BackoffLimit: tc.target.BackoffLimit,
+			Template: corev1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{
+					Labels: tc.labels(),
+				},
+				Spec: corev1.PodSpec{
+					RestartPolicy: corev1.RestartPolicyNever,
+					Containers: []corev1.Container{
+						{
+							Name:    "rsync-client",
+							Image:   tc.target.Image,
+							Command: append([]string{"sh", "-c", clientScript, "rsync-client"}, tc.target.RsyncFlags...),
+							Env: []corev1.EnvVar{
+								{
+									Name:  "RSYNC_USER",
+									Value: tc.source.Spec.Username,
+								},
+								{
+									Name: "RSYNC_HOST",
+									Value: fmt.Sprintf("%s.%s.svc",
+										tc.source.GetName(), tc.source.GetNamespace()),
+								},
+								{
+									Name:  "RSYNC_MODULE",
+									Value: tc.target.Module,
+								},
+								{
+									Name:  "RSYNC_PATH",
+									Value: tc.target.Path,
+								},
+							},
+							EnvFrom: []corev1.EnvFromSource{
+								{
+									SecretRef: &corev1.SecretEnvSource{
+										LocalObjectReference: corev1.LocalObjectReference{
+											Name: tc.name,
+										},
+									},
+								},
+							},
+							TerminationMessagePolicy: corev1.TerminationMessageReadFile,
+							VolumeMounts: []corev1.VolumeMount{
+								{
+									Name:      "dest",
+									MountPath: "/dest",
+									SubPath:   path.Clean("/" + tc.target.SubPath)[1:],
+								},
+							},
+						},
+					},
+					Volumes: []corev1.Volume{
+						{
+							Name: "dest",
+							VolumeSource: corev1.VolumeSource{
+								PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{
+									ClaimName: tc.target.DestinationPVC,
+								},
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+	return &job
+}
+
+// getCronJobTemplate returns the CronJob of a scheduled target. Every run
+// is a client Job like the one of a target without schedule.
+func (tc *templateConfig) getCronJobTemplate() *batchv1.CronJob {
+	job := tc.getJobTemplate()
+	objectMeta := job.ObjectMeta
+	objectMeta.Annotations = map[string]string{
+		specHashAnnotation: tc.specHash(),
+	}
+	cronJob := batchv1.CronJob{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: "batch/v1",
+			Kind:       "CronJob",
+		},
+		ObjectMeta: objectMeta,
+		Spec: batchv1.CronJobSpec{
+			Schedule:                   tc.target.Schedule,
+			Suspend:                    tc.target.Suspend,
+			ConcurrencyPolicy:          tc.target.ConcurrencyPolicy,
+			StartingDeadlineSeconds:    tc.target.StartingDeadlineSeconds,
+			SuccessfulJobsHistoryLimit: tc.target.SuccessfulRunsHistoryLimit,
+			FailedJobsHistoryLimit:     tc.target.FailedRunsHistoryLimit,
+			JobTemplate: batchv1.JobTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{
+					Labels: tc.labels(),
+				},
+				Spec: job.Spec,
+			},
+		},
+	}
+	return &cronJob
+}
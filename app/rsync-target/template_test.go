@@ -0,0 +1,132 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	internalv1 "github.com/k8s-volume-copy/types/apis/demo.io/v1"
+)
+
+func testTemplateConfig(spec RsyncTargetSpec) *templateConfig {
+	target := RsyncTarget{
+		TypeMeta:   metav1.TypeMeta{APIVersion: "demo.io/v1", Kind: rsyncTargetKind},
+		ObjectMeta: metav1.ObjectMeta{Name: "backup", Namespace: "app", UID: "target-uid"},
+		Spec:       spec,
+	}
+	source := internalv1.RsyncSource{
+		ObjectMeta: metav1.ObjectMeta{Name: "rsync-source", Namespace: "app"},
+		Spec:       internalv1.RsyncSourceSpec{Username: "user", Password: "pass"},
+	}
+	return templateConfigFromRsyncTarget(target, source)
+}
+
+func TestGetJobTemplate(t *testing.T) {
+	rsyncClientImage = "rsync-client"
+	tests := []struct {
+		name        string
+		spec        RsyncTargetSpec
+		wantImage   string
+		wantCommand []string
+		wantSubPath string
+		wantEnv     map[string]string
+		wantClaim   string
+	}{
+		{
+			name:        "defaults",
+			spec:        RsyncTargetSpec{DestinationPVC: "restore"},
+			wantImage:   "rsync-client",
+			wantCommand: []string{"sh", "-c", clientScript, "rsync-client", "--archive"},
+			wantEnv: map[string]string{
+				"RSYNC_USER":   "user",
+				"RSYNC_HOST":   "rsync-source.app.svc",
+				"RSYNC_MODULE": "data",
+				"RSYNC_PATH":   "",
+			},
+			wantClaim: "restore",
+		},
+		{
+			name: "options",
+			spec: RsyncTargetSpec{
+				DestinationPVC: "restore",
+				Module:         "logs",
+				Path:           "2026/10",
+				SubPath:        "imports/logs",
+				RsyncFlags:     []string{"-rt", "--delete"},
+				Image:          "custom-rsync",
+			},
+			wantImage:   "custom-rsync",
+			wantCommand: []string{"sh", "-c", clientScript, "rsync-client", "-rt", "--delete"},
+			wantSubPath: "imports/logs",
+			wantEnv: map[string]string{
+				"RSYNC_USER":   "user",
+				"RSYNC_HOST":   "rsync-source.app.svc",
+				"RSYNC_MODULE": "logs",
+				"RSYNC_PATH":   "2026/10",
+			},
+			wantClaim: "restore",
+		},
+		{
+			name:        "sub path escaping the claim",
+			spec:        RsyncTargetSpec{DestinationPVC: "restore", SubPath: "../../etc"},
+			wantImage:   "rsync-client",
+			wantCommand: []string{"sh", "-c", clientScript, "rsync-client", "--archive"},
+			wantSubPath: "etc",
+			wantEnv: map[string]string{
+				"RSYNC_USER":   "user",
+				"RSYNC_HOST":   "rsync-source.app.svc",
+				"RSYNC_MODULE": "data",
+				"RSYNC_PATH":   "",
+			},
+			wantClaim: "restore",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			job := testTemplateConfig(tt.spec).getJobTemplate()
+			if owner := metav1.GetControllerOf(job); owner == nil || owner.UID != "target-uid" {
+				t.Errorf("getJobTemplate() owner = %v, want the target", owner)
+			}
+			pod := job.Spec.Template.Spec
+			if pod.RestartPolicy != corev1.RestartPolicyNever {
+				t.Errorf("getJobTemplate() restart policy = %s, want Never", pod.RestartPolicy)
+			}
+			container := pod.Containers[0]
+			if container.Image != tt.wantImage {
+				t.Errorf("getJobTemplate() image = %s, want %s", container.Image, tt.wantImage)
+			}
+			if !reflect.DeepEqual(container.Command, tt.wantCommand) {
+				t.Errorf("getJobTemplate() command = %v, want %v", container.Command, tt.wantCommand)
+			}
+			env := map[string]string{}
+			for _, e := range container.Env {
+				env[e.Name] = e.Value
+			}
+			if !reflect.DeepEqual(env, tt.wantEnv) {
+				t.Errorf("getJobTemplate() env = %v, want %v", env, tt.wantEnv)
+			}
+			if got := container.EnvFrom[0].SecretRef.Name; got != "backup" {
+				t.Errorf("getJobTemplate() password secret = %s, want backup", got)
+			}
+			if got := container.VolumeMounts[0].SubPath; got != tt.wantSubPath {
+				t.Errorf("getJobTemplate() sub path = %s, want %s", got, tt.wantSubPath)
+			}
+			if got := pod.Volumes[0].PersistentVolumeClaim.ClaimName; got != tt.wantClaim {
+				t.Errorf("getJobTemplate() claim = %s, want %s", got, tt.wantClaim)
+			}
+		})
+	}
+}
+
+func TestGetSecretTemplate(t *testing.T) {
+	secret := testTemplateConfig(RsyncTargetSpec{}).getSecretTemplate()
+	want := map[string][]byte{"RSYNC_PASSWORD": []byte("pass")}
+	if !reflect.DeepEqual(secret.Data, want) {
+		t.Errorf("getSecretTemplate() data = %v, want %v", secret.Data, want)
+	}
+	if owner := metav1.GetControllerOf(secret); owner == nil || owner.UID != "target-uid" {
+		t.Errorf("getSecretTemplate() owner = %v, want the target", owner)
+	}
+}
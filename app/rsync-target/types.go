@@ -0,0 +1,150 @@
+// +kubebuilder:object:generate=true
+// +groupName=demo.io
+// +versionName=v1
+package main
+
+import (
+	batchv1 "k8s.io/api/batch/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// +kubebuilder:object:root=true
+// +kubebuilder:subresource:status
+// +kubebuilder:printcolumn:name="Source",type=string,JSONPath=`.spec.sourceRef.name`
+// +kubebuilder:printcolumn:name="Destination",type=string,JSONPath=`.spec.destinationPVC`
+// +kubebuilder:printcolumn:name="Phase",type=string,JSONPath=`.status.phase`
+// +kubebuilder:printcolumn:name="Bytes",type=integer,JSONPath=`.status.bytesTransferred`
+// +kubebuilder:printcolumn:name="Last Sync",type=date,JSONPath=`.status.lastSuccessfulSyncTime`
+// RsyncTarget pulls the content of a RsyncSource into a PersistentVolumeClaim
+// with a rsync client Job.
+type RsyncTarget struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+	// Spec contains the source, destination and rsync options of the copy.
+	Spec RsyncTargetSpec `json:"spec"`
+	// +optional
+	Status RsyncTargetStatus `json:"status,omitempty"`
+}
+
+// RsyncTargetSpec contains the information of rsync target
+type RsyncTargetSpec struct {
+	// SourceRef is the RsyncSource the data is pulled from.
+	SourceRef SourceReference `json:"sourceRef"`
+	// +optional
+	// Module of the rsync source that is pulled. Defaults to `data`.
+	Module string `json:"module,omitempty"`
+	// +optional
+	// Path inside the module that is pulled. Defaults to the module root.
+	Path string `json:"path,omitempty"`
+	// DestinationPVC is the name of the claim in the namespace of the target
+	// the data is written to.
+	DestinationPVC string `json:"destinationPVC"`
+	// +optional
+	// SubPath inside the destination claim the data is written to.
+	SubPath string `json:"subPath,omitempty"`
+	// +optional
+	// RsyncFlags are passed to the rsync client. Defaults to `--archive`.
+	RsyncFlags []string `json:"rsyncFlags,omitempty"`
+	// +optional
+	// Image of the rsync client. Defaults to the --rsync-client-image flag
+	// of the controller.
+	Image string `json:"image,omitempty"`
+	// +optional
+	// BackoffLimit is the number of retries of the client Job.
+	BackoffLimit *int32 `json:"backoffLimit,omitempty"`
+	// +optional
+	// Schedule in cron syntax. If set, the copy is repeated on the schedule
+	// by a CronJob instead of running once.
+	Schedule string `json:"schedule,omitempty"`
+	// +optional
+	// Suspend stops scheduling new runs.
+	Suspend *bool `json:"suspend,omitempty"`
+	// +optional
+	// +kubebuilder:validation:Enum=Allow;Forbid;Replace
+	// ConcurrencyPolicy of scheduled runs. Defaults to Forbid, a run is
+	// skipped while the previous one is still running.
+	ConcurrencyPolicy batchv1.ConcurrencyPolicy `json:"concurrencyPolicy,omitempty"`
+	// +optional
+	// StartingDeadlineSeconds is the deadline for starting a scheduled run
+	// that was missed, e.g. while the controller was down. Missed runs
+	// older than the deadline are skipped.
+	StartingDeadlineSeconds *int64 `json:"startingDeadlineSeconds,omitempty"`
+	// +optional
+	// SuccessfulRunsHistoryLimit is the number of finished successful runs
+	// to keep. Defaults to 3.
+	SuccessfulRunsHistoryLimit *int32 `json:"successfulRunsHistoryLimit,omitempty"`
+	// +optional
+	// FailedRunsHistoryLimit is the number of finished failed runs to keep.
+	// Defaults to 1.
+	FailedRunsHistoryLimit *int32 `json:"failedRunsHistoryLimit,omitempty"`
+}
+
+// SourceReference points to a RsyncSource.
+type SourceReference struct {
+	// Name of the RsyncSource.
+	Name string `json:"name"`
+	// +optional
+	// Namespace of the RsyncSource. It must be the namespace of the target,
+	// the password of the source is copied into it.
+	Namespace string `json:"namespace,omitempty"`
+}
+
+// RsyncTargetPhase is the phase of a rsync target.
+type RsyncTargetPhase string
+
+const (
+	// RsyncTargetPending means the client Job is not running yet.
+	RsyncTargetPending RsyncTargetPhase = "Pending"
+	// RsyncTargetScheduled means a scheduled target waits for its next run.
+	RsyncTargetScheduled RsyncTargetPhase = "Scheduled"
+	// RsyncTargetRunning means the client Job is running.
+	RsyncTargetRunning RsyncTargetPhase = "Running"
+	// RsyncTargetSucceeded means the copy finished successfully.
+	RsyncTargetSucceeded RsyncTargetPhase = "Succeeded"
+	// RsyncTargetFailed means the copy failed and won't be retried.
+	RsyncTargetFailed RsyncTargetPhase = "Failed"
+)
+
+// RsyncTargetStatus contains the information of rsync target
+type RsyncTargetStatus struct {
+	// +optional
+	Phase RsyncTargetPhase `json:"phase,omitempty"`
+	// +optional
+	// LastScheduleTime is the time the last run of a scheduled target was
+	// scheduled.
+	LastScheduleTime *metav1.Time `json:"lastScheduleTime,omitempty"`
+	// +optional
+	// LastSuccessfulSyncTime is the start time of the last successful run.
+	LastSuccessfulSyncTime *metav1.Time `json:"lastSuccessfulSyncTime,omitempty"`
+	// +optional
+	// Lag is how long the last successful run of a scheduled target started
+	// before its last scheduled run. The current age of the last successful
+	// run is exported as metric.
+	Lag *metav1.Duration `json:"lag,omitempty"`
+	// +optional
+	// StartTime is the time the last client Job started.
+	StartTime *metav1.Time `json:"startTime,omitempty"`
+	// +optional
+	// CompletionTime is the time the last client Job finished.
+	CompletionTime *metav1.Time `json:"completionTime,omitempty"`
+	// +optional
+	// BytesTransferred is the number of bytes received by the client.
+	BytesTransferred int64 `json:"bytesTransferred,omitempty"`
+	// +optional
+	// FilesTransferred is the number of regular files transferred.
+	FilesTransferred int64 `json:"filesTransferred,omitempty"`
+	// +optional
+	// ExitCode of the rsync client.
+	ExitCode *int32 `json:"exitCode,omitempty"`
+	// +optional
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+// RsyncTargetList is a list of RsyncTarget objects
+type RsyncTargetList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	// List of RsyncTargets
+	Items []RsyncTarget `json:"items"`
+}
@@ -0,0 +1,519 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"reflect"
+	"strings"
+	"syscall"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/dynamic/dynamicinformer"
+	"k8s.io/client-go/dynamic/dynamiclister"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/scheme"
+	typedcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/client-go/util/workqueue"
+	"k8s.io/klog/v2"
+
+	internalv1 "github.com/k8s-volume-copy/types/apis/demo.io/v1"
+	"github.com/k8s-volume-copy/types/constant"
+)
+
+const (
+	volumeMigrationKind     = "VolumeMigration"
+	volumeMigrationResource = "volumemigrations"
+	rsyncTargetResource     = "rsynctargets"
+
+	// pollInterval is used while waiting for resources that aren't watched.
+	pollInterval = 5 * time.Second
+)
+
+var (
+	volumeMigrationGVR = schema.GroupVersionResource{
+		Group:    constant.GroupDemoIO,
+		Version:  constant.VersionV1,
+		Resource: volumeMigrationResource,
+	}
+
+	volumeMigrationGK = schema.GroupKind{
+		Group: constant.GroupDemoIO,
+		Kind:  volumeMigrationKind,
+	}
+
+	rsyncSourceGVR = schema.GroupVersionResource{
+		Group:    constant.GroupDemoIO,
+		Version:  constant.VersionV1,
+		Resource: constant.RsyncSourceResource,
+	}
+
+	rsyncTargetGVR = schema.GroupVersionResource{
+		Group:    constant.GroupDemoIO,
+		Version:  constant.VersionV1,
+		Resource: rsyncTargetResource,
+	}
+)
+
+type controller struct {
+	kubeClient            kubernetes.Interface
+	dynamicClient         dynamic.Interface
+	volumeMigrationLister dynamiclister.Lister
+	volumeMigrationSynced cache.InformerSynced
+	rsyncTargetLister     dynamiclister.Lister
+	rsyncTargetSynced     cache.InformerSynced
+	workqueue             workqueue.RateLimitingInterface
+	recorder              record.EventRecorder
+}
+
+func runController(cfg *rest.Config) {
+	klog.Infof("Starting controller for %s", strings.ToLower(volumeMigrationGK.String()))
+	stopCh := make(chan struct{})
+	sigCh := make(chan os.Signal, 2)
+	signal.Notify(sigCh, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-sigCh
+		close(stopCh)
+		<-sigCh
+		os.Exit(1) // second signal. Exit directly.
+	}()
+
+	kubeClient, err := kubernetes.NewForConfig(cfg)
+	if nil != err {
+		klog.Fatalf("Failed to create kube client: %v", err)
+	}
+
+	dynamicClient, err := dynamic.NewForConfig(cfg)
+	if nil != err {
+		klog.Fatalf("Failed to create dynamic client: %v", err)
+	}
+
+	eventBroadcaster := record.NewBroadcaster()
+	eventBroadcaster.StartStructuredLogging(0)
+	eventBroadcaster.StartRecordingToSink(&typedcorev1.EventSinkImpl{Interface: kubeClient.CoreV1().Events("")})
+	recorder := eventBroadcaster.NewRecorder(scheme.Scheme,
+		corev1.EventSource{Component: componentNameVolumeMigrationController})
+
+	dynamicInformerFactory := dynamicinformer.NewDynamicSharedInformerFactory(dynamicClient, 30*time.Second)
+	volumeMigrationInformer := dynamicInformerFactory.ForResource(volumeMigrationGVR).Informer()
+
+	// Only rsync targets created by this controller are cached.
+	rsyncTargetInformerFactory := dynamicinformer.NewFilteredDynamicSharedInformerFactory(dynamicClient,
+		30*time.Second, metav1.NamespaceAll, func(options *metav1.ListOptions) {
+			options.LabelSelector = constant.CreatedByLabel + "=" + componentNameVolumeMigrationController
+		})
+	rsyncTargetInformer := rsyncTargetInformerFactory.ForResource(rsyncTargetGVR).Informer()
+
+	c := &controller{
+		kubeClient:            kubeClient,
+		dynamicClient:         dynamicClient,
+		volumeMigrationLister: dynamiclister.New(volumeMigrationInformer.GetIndexer(), volumeMigrationGVR),
+		volumeMigrationSynced: volumeMigrationInformer.HasSynced,
+		rsyncTargetLister:     dynamiclister.New(rsyncTargetInformer.GetIndexer(), rsyncTargetGVR),
+		rsyncTargetSynced:     rsyncTargetInformer.HasSynced,
+		workqueue:             workqueue.NewRateLimitingQueue(workqueue.DefaultControllerRateLimiter()),
+		recorder:              recorder,
+	}
+
+	volumeMigrationInformer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: c.handle,
+		UpdateFunc: func(oldObj, newObj interface{}) {
+			c.handle(newObj)
+		},
+		DeleteFunc: c.handle,
+	})
+
+	rsyncTargetInformer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: c.handleRsyncTarget,
+		UpdateFunc: func(oldObj, newObj interface{}) {
+			c.handleRsyncTarget(newObj)
+		},
+		DeleteFunc: c.handleRsyncTarget,
+	})
+
+	dynamicInformerFactory.Start(stopCh)
+	rsyncTargetInformerFactory.Start(stopCh)
+	if err := c.run(stopCh); nil != err {
+		klog.Fatalf("Failed to run controller: %v", err)
+	}
+}
+
+func (c *controller) handle(obj interface{}) {
+	key, err := cache.DeletionHandlingMetaNamespaceKeyFunc(obj)
+	if err != nil {
+		utilruntime.HandleError(err)
+		return
+	}
+	c.workqueue.Add(key)
+}
+
+// handleRsyncTarget queues the volume migration of a rsync target.
+func (c *controller) handleRsyncTarget(obj interface{}) {
+	if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+		obj = tombstone.Obj
+	}
+	rsyncTarget, ok := obj.(*unstructured.Unstructured)
+	if !ok {
+		return
+	}
+	name, ok := rsyncTarget.GetLabels()[constant.NameLabel]
+	if !ok {
+		return
+	}
+	c.workqueue.Add(rsyncTarget.GetNamespace() + "/" + name)
+}
+
+func (c *controller) run(stopCh <-chan struct{}) error {
+	defer utilruntime.HandleCrash()
+	defer c.workqueue.ShutDown()
+
+	if ok := cache.WaitForCacheSync(stopCh, c.volumeMigrationSynced, c.rsyncTargetSynced); !ok {
+		return fmt.Errorf("failed to wait for caches to sync")
+	}
+
+	go wait.Until(c.runWorker, time.Second, stopCh)
+	<-stopCh
+	return nil
+}
+
+func (c *controller) runWorker() {
+	processNext := func(obj interface{}) error {
+		defer c.workqueue.Done(obj)
+		var key string
+		var ok bool
+		if key, ok = obj.(string); !ok {
+			c.workqueue.Forget(obj)
+			utilruntime.HandleError(fmt.Errorf("expected string in workqueue but got %#v", obj))
+			return nil
+		}
+		parts := strings.Split(key, "/")
+		if len(parts) != 2 {
+			utilruntime.HandleError(fmt.Errorf("invalid resource key: %s", key))
+			return nil
+		}
+		if err := c.syncVolumeMigration(context.TODO(), key, parts[0], parts[1]); err != nil {
+			c.workqueue.AddRateLimited(key)
+			return fmt.Errorf("error syncing '%s': %s, requeuing", key, err.Error())
+		}
+		c.workqueue.Forget(obj)
+		return nil
+	}
+
+	for {
+		obj, shutdown := c.workqueue.Get()
+		if shutdown {
+			return
+		}
+		if err := processNext(obj); err != nil {
+			utilruntime.HandleError(err)
+		}
+	}
+}
+
+// syncVolumeMigration runs the step of the current phase of a migration.
+// Every step is idempotent and only relies on the status and the cluster
+// state, so a migration continues where it stopped after a restart.
+func (c *controller) syncVolumeMigration(ctx context.Context, key, namespace, name string) error {
+	unstruct, err := c.volumeMigrationLister.Namespace(namespace).Get(name)
+	if err != nil {
+		if errors.IsNotFound(err) {
+			utilruntime.HandleError(fmt.Errorf("volume migration '%s' in work queue no longer exists", key))
+			return nil
+		}
+		return fmt.Errorf("error getting volume migration, error: %s", err)
+	}
+	migration := VolumeMigration{}
+	if err := runtime.DefaultUnstructuredConverter.FromUnstructured(unstruct.UnstructuredContent(),
+		&migration); err != nil {
+		return fmt.Errorf("error converting volume migration `%s` in `%s` namespace error: %s",
+			unstruct.GetName(), unstruct.GetNamespace(), err)
+	}
+	if migration.DeletionTimestamp != nil {
+		// The rsync source and targets are garbage collected with the
+		// migration.
+		return nil
+	}
+	if migration.Status.Phase == VolumeMigrationSucceeded || migration.Status.Phase == VolumeMigrationFailed {
+		return nil
+	}
+	status := migration.Status.DeepCopy()
+	tc := templateConfigFromVolumeMigration(migration)
+
+	var requeueAfter time.Duration
+	switch status.Phase {
+	case "", VolumeMigrationPending:
+		requeueAfter, err = c.start(ctx, tc, status)
+	case VolumeMigrationWarmSync, VolumeMigrationFinalSync:
+		err = c.syncData(ctx, tc, status)
+	case VolumeMigrationScalingDown:
+		requeueAfter, err = c.scaleDown(ctx, tc, status)
+	case VolumeMigrationSwappingClaims:
+		requeueAfter, err = c.swapClaims(ctx, tc, status)
+	case VolumeMigrationScalingUp:
+		err = c.scaleUp(ctx, tc, status)
+	default:
+		c.fail(ctx, tc, status, fmt.Sprintf("unknown phase `%s`", status.Phase))
+	}
+	if err != nil {
+		return fmt.Errorf("error syncing phase `%s` of volume migration `%s` in `%s` namespace error: %s",
+			status.Phase, name, namespace, err)
+	}
+	if status.Phase != migration.Status.Phase {
+		klog.Infof("Volume migration `%s` in `%s` namespace moved to phase `%s`", name, namespace, status.Phase)
+		eventType := corev1.EventTypeNormal
+		if status.Phase == VolumeMigrationFailed {
+			eventType = corev1.EventTypeWarning
+		}
+		c.recorder.Event(&migration, eventType, string(status.Phase), status.Message)
+	}
+	if requeueAfter > 0 {
+		c.workqueue.AddAfter(key, requeueAfter)
+	}
+	return c.updateVolumeMigrationStatus(ctx, &migration, status)
+}
+
+// start checks the claim, provisions the new volume and starts serving the
+// old one.
+func (c *controller) start(ctx context.Context, tc *templateConfig, status *VolumeMigrationStatus) (time.Duration, error) {
+	status.Phase = VolumeMigrationPending
+	claim, err := c.kubeClient.CoreV1().PersistentVolumeClaims(tc.namespace).
+		Get(ctx, tc.migration.SourcePVC, metav1.GetOptions{})
+	if err != nil {
+		if errors.IsNotFound(err) {
+			c.fail(ctx, tc, status, fmt.Sprintf("claim `%s` not found", tc.migration.SourcePVC))
+			return 0, nil
+		}
+		return 0, err
+	}
+	if claim.Status.Phase != corev1.ClaimBound {
+		status.Message = fmt.Sprintf("waiting for claim `%s` to be bound", claim.GetName())
+		return pollInterval, nil
+	}
+	if claim.Spec.StorageClassName != nil && *claim.Spec.StorageClassName == tc.migration.StorageClassName {
+		c.fail(ctx, tc, status, fmt.Sprintf("claim `%s` already uses storage class `%s`",
+			claim.GetName(), tc.migration.StorageClassName))
+		return 0, nil
+	}
+
+	pods, err := c.podsUsingClaim(ctx, tc.namespace, claim.GetName())
+	if err != nil {
+		return 0, err
+	}
+	workload := tc.migration.Workload
+	hostName := ""
+	for i := range pods {
+		if workload == nil {
+			if workload, err = c.workloadOfPod(ctx, &pods[i]); err != nil {
+				return 0, err
+			}
+		}
+		if hostName == "" && pods[i].Spec.NodeName != "" {
+			node, err := c.kubeClient.CoreV1().Nodes().Get(ctx, pods[i].Spec.NodeName, metav1.GetOptions{})
+			if err != nil {
+				return 0, err
+			}
+			hostName = node.GetLabels()[constant.K8SIOHostName]
+		}
+	}
+
+	if err := c.ensureTargetClaim(ctx, tc.getTargetClaimTemplate(claim)); err != nil {
+		return 0, err
+	}
+	rsyncSource, err := tc.getRsyncSourceTemplate(hostName)
+	if err != nil {
+		return 0, err
+	}
+	if err := c.ensureRsyncSource(ctx, rsyncSource); err != nil {
+		return 0, err
+	}
+
+	now := metav1.Now()
+	status.StartTime = &now
+	status.TargetPVC = tc.targetClaimName()
+	status.Workload = workload
+	status.SourceVolumeName = claim.Spec.VolumeName
+	status.ClaimLabels = claim.GetLabels()
+	status.Phase = VolumeMigrationWarmSync
+	status.Message = "copying data while the workload is running"
+	return 0, nil
+}
+
+// syncData runs the rsync target of the warm or the final sync and moves to
+// the next phase once it succeeded.
+func (c *controller) syncData(ctx context.Context, tc *templateConfig, status *VolumeMigrationStatus) error {
+	template := tc.getRsyncTargetTemplate(status.Phase)
+	rsyncTarget, err := c.rsyncTargetLister.Namespace(tc.namespace).Get(template.GetName())
+	if err != nil {
+		if !errors.IsNotFound(err) {
+			return err
+		}
+		_, err = c.dynamicClient.Resource(rsyncTargetGVR).Namespace(tc.namespace).
+			Create(ctx, template, metav1.CreateOptions{})
+		if err != nil && !errors.IsAlreadyExists(err) {
+			return err
+		}
+		return nil
+	}
+	phase, _, _ := unstructured.NestedString(rsyncTarget.Object, "status", "phase")
+	switch phase {
+	case "Succeeded":
+		if status.Phase == VolumeMigrationWarmSync {
+			status.Phase = VolumeMigrationScalingDown
+			status.Message = "scaling down the workload"
+		} else {
+			status.Phase = VolumeMigrationSwappingClaims
+			status.Message = "swapping claims"
+		}
+	case "Failed":
+		c.fail(ctx, tc, status, fmt.Sprintf("rsync target `%s` failed", rsyncTarget.GetName()))
+	}
+	return nil
+}
+
+// scaleDown records the replicas of the workload and scales it to zero.
+// The final sync starts when all of its pods are gone.
+func (c *controller) scaleDown(ctx context.Context, tc *templateConfig, status *VolumeMigrationStatus) (time.Duration, error) {
+	if status.Workload == nil {
+		status.Phase = VolumeMigrationFinalSync
+		status.Message = "copying changes since the warm sync"
+		return 0, nil
+	}
+	if status.OriginalReplicas == nil {
+		// The replicas are stored before scaling, so they survive a
+		// restart of the controller.
+		scale, err := c.getScale(ctx, tc.namespace, status.Workload)
+		if err != nil {
+			return 0, err
+		}
+		replicas := scale.Spec.Replicas
+		status.OriginalReplicas = &replicas
+		return 0, nil
+	}
+	if err := c.scale(ctx, tc.namespace, status.Workload, 0); err != nil {
+		return 0, err
+	}
+	count, err := c.workloadPodCount(ctx, tc.namespace, status.Workload)
+	if err != nil {
+		return 0, err
+	}
+	if count != 0 {
+		status.Message = fmt.Sprintf("waiting for %d pods of %s `%s` to terminate",
+			count, status.Workload.Kind, status.Workload.Name)
+		return pollInterval, nil
+	}
+	status.Phase = VolumeMigrationFinalSync
+	status.Message = "copying changes since the warm sync"
+	return 0, nil
+}
+
+// scaleUp restores the replicas of the workload.
+func (c *controller) scaleUp(ctx context.Context, tc *templateConfig, status *VolumeMigrationStatus) error {
+	if status.Workload != nil && status.OriginalReplicas != nil {
+		if err := c.scale(ctx, tc.namespace, status.Workload, *status.OriginalReplicas); err != nil {
+			return err
+		}
+	}
+	now := metav1.Now()
+	status.CompletionTime = &now
+	status.Phase = VolumeMigrationSucceeded
+	// The old volume is kept as backup, the controller never deletes it.
+	status.Message = fmt.Sprintf("claim `%s` is bound to volume `%s`, the old volume `%s` is retained "+
+		"and has to be deleted once the data is verified", tc.migration.SourcePVC, status.TargetVolumeName,
+		status.SourceVolumeName)
+	return nil
+}
+
+// fail marks a migration as failed. A workload that was scaled down keeps
+// using the old claim and is scaled back.
+func (c *controller) fail(ctx context.Context, tc *templateConfig, status *VolumeMigrationStatus, message string) {
+	if status.Workload != nil && status.OriginalReplicas != nil {
+		if err := c.scale(ctx, tc.namespace, status.Workload, *status.OriginalReplicas); err != nil {
+			message = fmt.Sprintf("%s, error scaling %s `%s` back to %d replicas: %s", message,
+				status.Workload.Kind, status.Workload.Name, *status.OriginalReplicas, err)
+		}
+	}
+	now := metav1.Now()
+	status.CompletionTime = &now
+	status.Phase = VolumeMigrationFailed
+	status.Message = message
+}
+
+/*
+if found and not created by the controller then return error
+if found return nil
+if !found -> create return nil
+*/
+func (c *controller) ensureTargetClaim(ctx context.Context, pvc *corev1.PersistentVolumeClaim) error {
+	obj, err := c.kubeClient.CoreV1().PersistentVolumeClaims(pvc.GetNamespace()).
+		Get(ctx, pvc.GetName(), metav1.GetOptions{})
+	if err != nil {
+		if !errors.IsNotFound(err) {
+			return err
+		}
+		_, err = c.kubeClient.CoreV1().PersistentVolumeClaims(pvc.GetNamespace()).
+			Create(ctx, pvc, metav1.CreateOptions{})
+		return err
+	}
+	if obj.GetLabels()[constant.CreatedByLabel] != componentNameVolumeMigrationController ||
+		obj.GetLabels()[constant.NameLabel] != pvc.GetLabels()[constant.NameLabel] {
+		return fmt.Errorf("resource found but not created by this operator")
+	}
+	return nil
+}
+
+/*
+if found and not created by the controller then return error
+if found return nil
+if !found -> create return nil
+*/
+func (c *controller) ensureRsyncSource(ctx context.Context, rsyncSource *internalv1.RsyncSource) error {
+	rsMap, err := runtime.DefaultUnstructuredConverter.ToUnstructured(rsyncSource)
+	if err != nil {
+		return err
+	}
+	template := &unstructured.Unstructured{Object: rsMap}
+	obj, err := c.dynamicClient.Resource(rsyncSourceGVR).Namespace(template.GetNamespace()).
+		Get(ctx, template.GetName(), metav1.GetOptions{})
+	if err != nil {
+		if !errors.IsNotFound(err) {
+			return err
+		}
+		_, err = c.dynamicClient.Resource(rsyncSourceGVR).Namespace(template.GetNamespace()).
+			Create(ctx, template, metav1.CreateOptions{})
+		return err
+	}
+	if owner := metav1.GetControllerOf(obj); owner == nil || owner.UID != template.GetOwnerReferences()[0].UID {
+		return fmt.Errorf("resource found but not created by this operator")
+	}
+	return nil
+}
+
+// updateVolumeMigrationStatus updates the status of a volume migration if it
+// changed.
+func (c *controller) updateVolumeMigrationStatus(ctx context.Context, cr *VolumeMigration, status *VolumeMigrationStatus) error {
+	if reflect.DeepEqual(cr.Status, *status) {
+		return nil
+	}
+	clone := cr.DeepCopy()
+	clone.Status = *status
+	vmMap, err := runtime.DefaultUnstructuredConverter.ToUnstructured(clone)
+	if err != nil {
+		return err
+	}
+	_, err = c.dynamicClient.Resource(volumeMigrationGVR).Namespace(clone.GetNamespace()).
+		UpdateStatus(ctx, &unstructured.Unstructured{Object: vmMap}, metav1.UpdateOptions{})
+	return err
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// swapClaims rebinds the claim to the new volume. Both volumes are retained
+// while the old claim and the target claim are deleted, then the claim is
+// recreated with the name of the old claim and bound to the new volume.
+func (c *controller) swapClaims(ctx context.Context, tc *templateConfig, status *VolumeMigrationStatus) (time.Duration, error) {
+	// The rsync source has to release the old claim before it is deleted.
+	err := c.dynamicClient.Resource(rsyncSourceGVR).Namespace(tc.namespace).
+		Delete(ctx, tc.sourceName(), metav1.DeleteOptions{})
+	if err != nil && !errors.IsNotFound(err) {
+		return 0, err
+	}
+
+	if status.TargetVolumeName == "" {
+		target, err := c.kubeClient.CoreV1().PersistentVolumeClaims(tc.namespace).
+			Get(ctx, tc.targetClaimName(), metav1.GetOptions{})
+		if err != nil {
+			return 0, err
+		}
+		if target.Spec.VolumeName == "" {
+			return 0, fmt.Errorf("claim `%s` isn't bound", target.GetName())
+		}
+		pv, err := c.kubeClient.CoreV1().PersistentVolumes().Get(ctx, target.Spec.VolumeName, metav1.GetOptions{})
+		if err != nil {
+			return 0, err
+		}
+		// The volume is stored before the claims are deleted, so the swap
+		// continues after a restart of the controller.
+		status.TargetVolumeName = pv.GetName()
+		status.TargetReclaimPolicy = pv.Spec.PersistentVolumeReclaimPolicy
+		return 0, nil
+	}
+	for _, name := range []string{status.SourceVolumeName, status.TargetVolumeName} {
+		if err := c.setReclaimPolicy(ctx, name, corev1.PersistentVolumeReclaimRetain); err != nil {
+			return 0, err
+		}
+	}
+
+	claim, err := c.kubeClient.CoreV1().PersistentVolumeClaims(tc.namespace).
+		Get(ctx, tc.migration.SourcePVC, metav1.GetOptions{})
+	if err != nil && !errors.IsNotFound(err) {
+		return 0, err
+	}
+	if err == nil && claim.Spec.VolumeName == status.TargetVolumeName {
+		return c.waitForSwappedClaim(ctx, claim, status)
+	}
+	deleting := false
+	for _, name := range []string{tc.migration.SourcePVC, tc.targetClaimName()} {
+		err := c.kubeClient.CoreV1().PersistentVolumeClaims(tc.namespace).
+			Delete(ctx, name, metav1.DeleteOptions{})
+		if err == nil {
+			deleting = true
+		} else if !errors.IsNotFound(err) {
+			return 0, err
+		}
+	}
+	if deleting {
+		status.Message = "waiting for the old claim and the target claim to be deleted"
+		return pollInterval, nil
+	}
+
+	pv, err := c.kubeClient.CoreV1().PersistentVolumes().Get(ctx, status.TargetVolumeName, metav1.GetOptions{})
+	if err != nil {
+		return 0, err
+	}
+	if ref := pv.Spec.ClaimRef; ref == nil || ref.Namespace != tc.namespace || ref.Name != tc.migration.SourcePVC {
+		// The volume is reserved for the claim that is created next.
+		pv.Spec.ClaimRef = &corev1.ObjectReference{
+			Namespace: tc.namespace,
+			Name:      tc.migration.SourcePVC,
+		}
+		if pv, err = c.kubeClient.CoreV1().PersistentVolumes().Update(ctx, pv, metav1.UpdateOptions{}); err != nil {
+			return 0, err
+		}
+	}
+	_, err = c.kubeClient.CoreV1().PersistentVolumeClaims(tc.namespace).
+		Create(ctx, tc.getSwappedClaimTemplate(pv, status.ClaimLabels), metav1.CreateOptions{})
+	if err != nil && !errors.IsAlreadyExists(err) {
+		return 0, err
+	}
+	status.Message = fmt.Sprintf("waiting for claim `%s` to be bound to volume `%s`",
+		tc.migration.SourcePVC, status.TargetVolumeName)
+	return pollInterval, nil
+}
+
+// waitForSwappedClaim restores the reclaim policy of the new volume once the
+// swapped claim is bound.
+func (c *controller) waitForSwappedClaim(ctx context.Context, claim *corev1.PersistentVolumeClaim, status *VolumeMigrationStatus) (time.Duration, error) {
+	if claim.Status.Phase != corev1.ClaimBound {
+		status.Message = fmt.Sprintf("waiting for claim `%s` to be bound to volume `%s`",
+			claim.GetName(), status.TargetVolumeName)
+		return pollInterval, nil
+	}
+	if status.TargetReclaimPolicy != "" {
+		if err := c.setReclaimPolicy(ctx, status.TargetVolumeName, status.TargetReclaimPolicy); err != nil {
+			return 0, err
+		}
+	}
+	status.Phase = VolumeMigrationScalingUp
+	status.Message = "scaling up the workload"
+	return 0, nil
+}
+
+func (c *controller) setReclaimPolicy(ctx context.Context, name string, policy corev1.PersistentVolumeReclaimPolicy) error {
+	pv, err := c.kubeClient.CoreV1().PersistentVolumes().Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		return err
+	}
+	if pv.Spec.PersistentVolumeReclaimPolicy == policy {
+		return nil
+	}
+	pv.Spec.PersistentVolumeReclaimPolicy = policy
+	_, err = c.kubeClient.CoreV1().PersistentVolumes().Update(ctx, pv, metav1.UpdateOptions{})
+	return err
+}
@@ -0,0 +1,336 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func testMigrationConfig() *templateConfig {
+	return templateConfigFromVolumeMigration(VolumeMigration{
+		ObjectMeta: metav1.ObjectMeta{Name: "move", Namespace: "app", UID: "migration-uid"},
+		Spec:       VolumeMigrationSpec{SourcePVC: "data", StorageClassName: "fast"},
+	})
+}
+
+func testVolume(name string, policy corev1.PersistentVolumeReclaimPolicy, claimRef *corev1.ObjectReference) *corev1.PersistentVolume {
+	return &corev1.PersistentVolume{
+		ObjectMeta: metav1.ObjectMeta{Name: name},
+		Spec: corev1.PersistentVolumeSpec{
+			PersistentVolumeReclaimPolicy: policy,
+			ClaimRef:                      claimRef,
+			StorageClassName:              "fast",
+		},
+	}
+}
+
+func testMigrationClaim(name, volumeName string, phase corev1.PersistentVolumeClaimPhase) *corev1.PersistentVolumeClaim {
+	return &corev1.PersistentVolumeClaim{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "app"},
+		Spec:       corev1.PersistentVolumeClaimSpec{VolumeName: volumeName},
+		Status:     corev1.PersistentVolumeClaimStatus{Phase: phase},
+	}
+}
+
+func testSwapController(objects ...runtime.Object) *controller {
+	return &controller{
+		kubeClient:    fake.NewSimpleClientset(objects...),
+		dynamicClient: dynamicfake.NewSimpleDynamicClient(runtime.NewScheme()),
+	}
+}
+
+func TestSwapClaims(t *testing.T) {
+	oldRef := &corev1.ObjectReference{Namespace: "app", Name: "data", UID: "old-claim-uid"}
+	targetRef := &corev1.ObjectReference{Namespace: "app", Name: "move-target", UID: "target-claim-uid"}
+	reservedRef := &corev1.ObjectReference{Namespace: "app", Name: "data"}
+	recorded := VolumeMigrationStatus{
+		Phase:               VolumeMigrationSwappingClaims,
+		SourceVolumeName:    "pv-old",
+		TargetVolumeName:    "pv-new",
+		TargetReclaimPolicy: corev1.PersistentVolumeReclaimDelete,
+		ClaimLabels:         map[string]string{"app": "db"},
+	}
+	tests := []struct {
+		name    string
+		objects []runtime.Object
+		status  VolumeMigrationStatus
+		// wantStatus only checks the fields the swap sets.
+		wantStatus       VolumeMigrationStatus
+		wantRequeue      time.Duration
+		wantErr          bool
+		wantClaims       map[string]string
+		wantPolicies     map[string]corev1.PersistentVolumeReclaimPolicy
+		wantNewClaimRef  *corev1.ObjectReference
+		wantClaimsAbsent []string
+	}{
+		{
+			name: "target volume recorded before deleting claims",
+			objects: []runtime.Object{
+				testMigrationClaim("data", "pv-old", corev1.ClaimBound),
+				testMigrationClaim("move-target", "pv-new", corev1.ClaimBound),
+				testVolume("pv-old", corev1.PersistentVolumeReclaimDelete, oldRef),
+				testVolume("pv-new", corev1.PersistentVolumeReclaimDelete, targetRef),
+			},
+			status: VolumeMigrationStatus{Phase: VolumeMigrationSwappingClaims, SourceVolumeName: "pv-old"},
+			wantStatus: VolumeMigrationStatus{
+				Phase:               VolumeMigrationSwappingClaims,
+				TargetVolumeName:    "pv-new",
+				TargetReclaimPolicy: corev1.PersistentVolumeReclaimDelete,
+			},
+			wantClaims: map[string]string{"data": "pv-old", "move-target": "pv-new"},
+			wantPolicies: map[string]corev1.PersistentVolumeReclaimPolicy{
+				"pv-old": corev1.PersistentVolumeReclaimDelete,
+				"pv-new": corev1.PersistentVolumeReclaimDelete,
+			},
+		},
+		{
+			name: "target claim not bound",
+			objects: []runtime.Object{
+				testMigrationClaim("data", "pv-old", corev1.ClaimBound),
+				testMigrationClaim("move-target", "", corev1.ClaimPending),
+			},
+			status:  VolumeMigrationStatus{Phase: VolumeMigrationSwappingClaims, SourceVolumeName: "pv-old"},
+			wantErr: true,
+		},
+		{
+			name: "volumes retained and claims deleted",
+			objects: []runtime.Object{
+				testMigrationClaim("data", "pv-old", corev1.ClaimBound),
+				testMigrationClaim("move-target", "pv-new", corev1.ClaimBound),
+				testVolume("pv-old", corev1.PersistentVolumeReclaimDelete, oldRef),
+				testVolume("pv-new", corev1.PersistentVolumeReclaimDelete, targetRef),
+			},
+			status: recorded,
+			wantStatus: VolumeMigrationStatus{
+				Phase:   VolumeMigrationSwappingClaims,
+				Message: "waiting for the old claim and the target claim to be deleted",
+			},
+			wantRequeue:      pollInterval,
+			wantClaimsAbsent: []string{"data", "move-target"},
+			wantPolicies: map[string]corev1.PersistentVolumeReclaimPolicy{
+				"pv-old": corev1.PersistentVolumeReclaimRetain,
+				"pv-new": corev1.PersistentVolumeReclaimRetain,
+			},
+		},
+		{
+			name: "restart while the target claim is deleting",
+			objects: []runtime.Object{
+				testMigrationClaim("move-target", "pv-new", corev1.ClaimBound),
+				testVolume("pv-old", corev1.PersistentVolumeReclaimRetain, oldRef),
+				testVolume("pv-new", corev1.PersistentVolumeReclaimRetain, targetRef),
+			},
+			status: recorded,
+			wantStatus: VolumeMigrationStatus{
+				Phase:   VolumeMigrationSwappingClaims,
+				Message: "waiting for the old claim and the target claim to be deleted",
+			},
+			wantRequeue:      pollInterval,
+			wantClaimsAbsent: []string{"data", "move-target"},
+		},
+		{
+			name: "claims deleted, volume reserved and claim recreated",
+			objects: []runtime.Object{
+				testVolume("pv-old", corev1.PersistentVolumeReclaimRetain, oldRef),
+				testVolume("pv-new", corev1.PersistentVolumeReclaimRetain, targetRef),
+			},
+			status: recorded,
+			wantStatus: VolumeMigrationStatus{
+				Phase:   VolumeMigrationSwappingClaims,
+				Message: "waiting for claim `data` to be bound to volume `pv-new`",
+			},
+			wantRequeue:     pollInterval,
+			wantClaims:      map[string]string{"data": "pv-new"},
+			wantNewClaimRef: reservedRef,
+		},
+		{
+			name: "restart after the claim ref was rewritten",
+			objects: []runtime.Object{
+				testVolume("pv-old", corev1.PersistentVolumeReclaimRetain, oldRef),
+				testVolume("pv-new", corev1.PersistentVolumeReclaimRetain, reservedRef),
+			},
+			status: recorded,
+			wantStatus: VolumeMigrationStatus{
+				Phase:   VolumeMigrationSwappingClaims,
+				Message: "waiting for claim `data` to be bound to volume `pv-new`",
+			},
+			wantRequeue:     pollInterval,
+			wantClaims:      map[string]string{"data": "pv-new"},
+			wantNewClaimRef: reservedRef,
+		},
+		{
+			name: "swapped claim not bound yet",
+			objects: []runtime.Object{
+				testMigrationClaim("data", "pv-new", corev1.ClaimPending),
+				testVolume("pv-old", corev1.PersistentVolumeReclaimRetain, oldRef),
+				testVolume("pv-new", corev1.PersistentVolumeReclaimRetain, reservedRef),
+			},
+			status: recorded,
+			wantStatus: VolumeMigrationStatus{
+				Phase:   VolumeMigrationSwappingClaims,
+				Message: "waiting for claim `data` to be bound to volume `pv-new`",
+			},
+			wantRequeue: pollInterval,
+			wantClaims:  map[string]string{"data": "pv-new"},
+		},
+		{
+			name: "swapped claim bound",
+			objects: []runtime.Object{
+				testMigrationClaim("data", "pv-new", corev1.ClaimBound),
+				testVolume("pv-old", corev1.PersistentVolumeReclaimRetain, oldRef),
+				testVolume("pv-new", corev1.PersistentVolumeReclaimRetain, reservedRef),
+			},
+			status: recorded,
+			wantStatus: VolumeMigrationStatus{
+				Phase:   VolumeMigrationScalingUp,
+				Message: "scaling up the workload",
+			},
+			wantClaims: map[string]string{"data": "pv-new"},
+			wantPolicies: map[string]corev1.PersistentVolumeReclaimPolicy{
+				"pv-old": corev1.PersistentVolumeReclaimRetain,
+				"pv-new": corev1.PersistentVolumeReclaimDelete,
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.TODO()
+			c := testSwapController(tt.objects...)
+			status := tt.status.DeepCopy()
+			requeue, err := c.swapClaims(ctx, testMigrationConfig(), status)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("swapClaims() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if requeue != tt.wantRequeue {
+				t.Errorf("swapClaims() requeue = %s, want %s", requeue, tt.wantRequeue)
+			}
+			if status.Phase != tt.wantStatus.Phase || status.Message != tt.wantStatus.Message {
+				t.Errorf("swapClaims() status = %s %q, want %s %q",
+					status.Phase, status.Message, tt.wantStatus.Phase, tt.wantStatus.Message)
+			}
+			if tt.wantStatus.TargetVolumeName != "" && (status.TargetVolumeName != tt.wantStatus.TargetVolumeName ||
+				status.TargetReclaimPolicy != tt.wantStatus.TargetReclaimPolicy) {
+				t.Errorf("swapClaims() target volume = %s %s, want %s %s", status.TargetVolumeName,
+					status.TargetReclaimPolicy, tt.wantStatus.TargetVolumeName, tt.wantStatus.TargetReclaimPolicy)
+			}
+			for name, volumeName := range tt.wantClaims {
+				claim, err := c.kubeClient.CoreV1().PersistentVolumeClaims("app").Get(ctx, name, metav1.GetOptions{})
+				if err != nil {
+					t.Errorf("getting claim `%s` error = %v", name, err)
+					continue
+				}
+				if claim.Spec.VolumeName != volumeName {
+					t.Errorf("claim `%s` volume = %s, want %s", name, claim.Spec.VolumeName, volumeName)
+				}
+			}
+			for _, name := range tt.wantClaimsAbsent {
+				_, err := c.kubeClient.CoreV1().PersistentVolumeClaims("app").Get(ctx, name, metav1.GetOptions{})
+				if !errors.IsNotFound(err) {
+					t.Errorf("claim `%s` still exists, error = %v", name, err)
+				}
+			}
+			for name, policy := range tt.wantPolicies {
+				pv, err := c.kubeClient.CoreV1().PersistentVolumes().Get(ctx, name, metav1.GetOptions{})
+				if err != nil {
+					t.Fatalf("getting volume `%s` error = %v", name, err)
+				}
+				if pv.Spec.PersistentVolumeReclaimPolicy != policy {
+					t.Errorf("volume `%s` reclaim policy = %s, want %s", name, pv.Spec.PersistentVolumeReclaimPolicy, policy)
+				}
+			}
+			if tt.wantNewClaimRef != nil {
+				pv, err := c.kubeClient.CoreV1().PersistentVolumes().Get(ctx, "pv-new", metav1.GetOptions{})
+				if err != nil {
+					t.Fatalf("getting volume `pv-new` error = %v", err)
+				}
+				if pv.Spec.ClaimRef == nil || *pv.Spec.ClaimRef != *tt.wantNewClaimRef {
+					t.Errorf("volume `pv-new` claim ref = %+v, want %+v", pv.Spec.ClaimRef, tt.wantNewClaimRef)
+				}
+			}
+		})
+	}
+}
+
+func TestWaitForSwappedClaim(t *testing.T) {
+	tests := []struct {
+		name        string
+		phase       corev1.PersistentVolumeClaimPhase
+		policy      corev1.PersistentVolumeReclaimPolicy
+		wantPhase   VolumeMigrationPhase
+		wantRequeue time.Duration
+		wantPolicy  corev1.PersistentVolumeReclaimPolicy
+	}{
+		{
+			name:        "pending",
+			phase:       corev1.ClaimPending,
+			policy:      corev1.PersistentVolumeReclaimDelete,
+			wantPhase:   VolumeMigrationSwappingClaims,
+			wantRequeue: pollInterval,
+			wantPolicy:  corev1.PersistentVolumeReclaimRetain,
+		},
+		{
+			name:       "bound restores the reclaim policy",
+			phase:      corev1.ClaimBound,
+			policy:     corev1.PersistentVolumeReclaimDelete,
+			wantPhase:  VolumeMigrationScalingUp,
+			wantPolicy: corev1.PersistentVolumeReclaimDelete,
+		},
+		{
+			name:       "bound without recorded reclaim policy",
+			phase:      corev1.ClaimBound,
+			wantPhase:  VolumeMigrationScalingUp,
+			wantPolicy: corev1.PersistentVolumeReclaimRetain,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.TODO()
+			c := testSwapController(testVolume("pv-new", corev1.PersistentVolumeReclaimRetain, nil))
+			status := &VolumeMigrationStatus{
+				Phase:               VolumeMigrationSwappingClaims,
+				TargetVolumeName:    "pv-new",
+				TargetReclaimPolicy: tt.policy,
+			}
+			requeue, err := c.waitForSwappedClaim(ctx, testMigrationClaim("data", "pv-new", tt.phase), status)
+			if err != nil {
+				t.Fatalf("waitForSwappedClaim() error = %v", err)
+			}
+			if requeue != tt.wantRequeue || status.Phase != tt.wantPhase {
+				t.Errorf("waitForSwappedClaim() = %s %s, want %s %s", requeue, status.Phase, tt.wantRequeue, tt.wantPhase)
+			}
+			pv, err := c.kubeClient.CoreV1().PersistentVolumes().Get(ctx, "pv-new", metav1.GetOptions{})
+			if err != nil {
+				t.Fatalf("getting volume error = %v", err)
+			}
+			if pv.Spec.PersistentVolumeReclaimPolicy != tt.wantPolicy {
+				t.Errorf("waitForSwappedClaim() reclaim policy = %s, want %s", pv.Spec.PersistentVolumeReclaimPolicy, tt.wantPolicy)
+			}
+		})
+	}
+}
+
+func TestScaleUpReportsRetainedVolume(t *testing.T) {
+	c := testSwapController()
+	status := &VolumeMigrationStatus{
+		Phase:            VolumeMigrationScalingUp,
+		SourceVolumeName: "pv-old",
+		TargetVolumeName: "pv-new",
+	}
+	if err := c.scaleUp(context.TODO(), testMigrationConfig(), status); err != nil {
+		t.Fatalf("scaleUp() error = %v", err)
+	}
+	want := "claim `data` is bound to volume `pv-new`, the old volume `pv-old` is retained " +
+		"and has to be deleted once the data is verified"
+	if status.Phase != VolumeMigrationSucceeded || status.Message != want {
+		t.Errorf("scaleUp() = %s %q, want %s %q", status.Phase, status.Message, VolumeMigrationSucceeded, want)
+	}
+}
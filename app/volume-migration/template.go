@@ -0,0 +1,220 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+
+	internalv1 "github.com/k8s-volume-copy/types/apis/demo.io/v1"
+	"github.com/k8s-volume-copy/types/constant"
+)
+
+const (
+	// componentNameVolumeMigrationController is added as created-by label
+	// to the resources created by the volume migration controller.
+	componentNameVolumeMigrationController = "volume-migration-controller"
+
+	rsyncTargetKind = "RsyncTarget"
+
+	rsyncUsername = "migration"
+	// dataVolumeName is the name of the volume of the rsync source.
+	dataVolumeName = "data"
+)
+
+type templateConfig struct {
+	name      string
+	namespace string
+	owner     metav1.OwnerReference
+	migration VolumeMigrationSpec
+}
+
+func templateConfigFromVolumeMigration(cr VolumeMigration) *templateConfig {
+	isController := true
+	tc := &templateConfig{
+		name:      cr.GetName(),
+		namespace: cr.GetNamespace(),
+		owner: metav1.OwnerReference{
+			APIVersion: cr.APIVersion,
+			Kind:       cr.Kind,
+			Name:       cr.GetName(),
+			UID:        cr.GetUID(),
+			Controller: &isController,
+		},
+		migration: cr.Spec,
+	}
+	if len(tc.migration.RsyncFlags) == 0 {
+		tc.migration.RsyncFlags = []string{"--archive", "--delete"}
+	}
+	return tc
+}
+
+func (tc *templateConfig) labels() map[string]string {
+	return map[string]string{
+		constant.CreatedByLabel: componentNameVolumeMigrationController,
+		constant.NameLabel:      tc.name,
+	}
+}
+
+func (tc *templateConfig) sourceName() string {
+	return tc.name + "-source"
+}
+
+func (tc *templateConfig) targetClaimName() string {
+	return tc.name + "-target"
+}
+
+func (tc *templateConfig) rsyncTargetName(phase VolumeMigrationPhase) string {
+	if phase == VolumeMigrationWarmSync {
+		return tc.name + "-warm"
+	}
+	return tc.name + "-final"
+}
+
+// getRsyncSourceTemplate returns the rsync source serving the old claim. It
+// runs on the node of the workload so that ReadWriteOnce claims can be
+// mounted while the workload is running.
+func (tc *templateConfig) getRsyncSourceTemplate(hostName string) (*internalv1.RsyncSource, error) {
+	password := make([]byte, 16)
+	if _, err := rand.Read(password); err != nil {
+		return nil, err
+	}
+	replicas := int32(1)
+	rsyncSource := internalv1.RsyncSource{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: constant.GroupDemoIO + "/" + constant.VersionV1,
+			Kind:       constant.RsyncSourceKind,
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:            tc.sourceName(),
+			Namespace:       tc.namespace,
+			Labels:          tc.labels(),
+			OwnerReferences: []metav1.OwnerReference{tc.owner},
+		},
+		Spec: internalv1.RsyncSourceSpec{
+			Image:    rsyncDaemonImage,
+			Replicas: &replicas,
+			HostName: hostName,
+			Username: rsyncUsername,
+			Password: hex.EncodeToString(password),
+			Volume: corev1.Volume{
+				Name: dataVolumeName,
+				VolumeSource: corev1.VolumeSource{
+					PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{
+						ClaimName: tc.migration.SourcePVC,
+					},
+				},
+			},
+		},
+	}
+	return &rsyncSource, nil
+}
+
+// getRsyncTargetTemplate returns the rsync target of a sync phase. The
+// RsyncTarget type belongs to the rsync target controller, so it is built
+// as unstructured object.
+func (tc *templateConfig) getRsyncTargetTemplate(phase VolumeMigrationPhase) *unstructured.Unstructured {
+	flags := []interface{}{}
+	for _, f := range tc.migration.RsyncFlags {
+		flags = append(flags, f)
+	}
+	labels := map[string]interface{}{}
+	for k, v := range tc.labels() {
+		labels[k] = v
+	}
+	return &unstructured.Unstructured{
+		Object: map[string]interface{}{
+			"apiVersion": constant.GroupDemoIO + "/" + constant.VersionV1,
+			"kind":       rsyncTargetKind,
+			"metadata": map[string]interface{}{
+				"name":      tc.rsyncTargetName(phase),
+				"namespace": tc.namespace,
+				"labels":    labels,
+				"ownerReferences": []interface{}{
+					map[string]interface{}{
+						"apiVersion": tc.owner.APIVersion,
+						"kind":       tc.owner.Kind,
+						"name":       tc.owner.Name,
+						"uid":        string(tc.owner.UID),
+						"controller": true,
+					},
+				},
+			},
+			"spec": map[string]interface{}{
+				"sourceRef": map[string]interface{}{
+					"name": tc.sourceName(),
+				},
+				"destinationPVC": tc.targetClaimName(),
+				"rsyncFlags":     flags,
+			},
+		},
+	}
+}
+
+// getTargetClaimTemplate returns the claim the new volume is provisioned
+// with. It isn't owned by the migration, so the copied data survives the
+// deletion of an unfinished migration.
+func (tc *templateConfig) getTargetClaimTemplate(source *corev1.PersistentVolumeClaim) *corev1.PersistentVolumeClaim {
+	capacity := source.Spec.Resources.Requests[corev1.ResourceStorage]
+	if size, ok := source.Status.Capacity[corev1.ResourceStorage]; ok && size.Cmp(capacity) > 0 {
+		capacity = size
+	}
+	if tc.migration.Capacity != nil {
+		capacity = *tc.migration.Capacity
+	}
+	storageClassName := tc.migration.StorageClassName
+	pvc := corev1.PersistentVolumeClaim{
+		TypeMeta: metav1.TypeMeta{
+			Kind:       "PersistentVolumeClaim",
+			APIVersion: "v1",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      tc.targetClaimName(),
+			Namespace: tc.namespace,
+			Labels:    tc.labels(),
+		},
+		Spec: corev1.PersistentVolumeClaimSpec{
+			AccessModes: source.Spec.AccessModes,
+			Resources: corev1.ResourceRequirements{
+				Requests: corev1.ResourceList{
+					corev1.ResourceStorage: capacity,
+				},
+			},
+			StorageClassName: &storageClassName,
+			VolumeMode:       source.Spec.VolumeMode,
+		},
+	}
+	return &pvc
+}
+
+// getSwappedClaimTemplate returns the claim that replaces the old claim. It
+// has the name and the labels of the old claim and is bound to the new
+// volume.
+func (tc *templateConfig) getSwappedClaimTemplate(pv *corev1.PersistentVolume, claimLabels map[string]string) *corev1.PersistentVolumeClaim {
+	storageClassName := pv.Spec.StorageClassName
+	pvc := corev1.PersistentVolumeClaim{
+		TypeMeta: metav1.TypeMeta{
+			Kind:       "PersistentVolumeClaim",
+			APIVersion: "v1",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      tc.migration.SourcePVC,
+			Namespace: tc.namespace,
+			Labels:    claimLabels,
+		},
+		Spec: corev1.PersistentVolumeClaimSpec{
+			AccessModes: pv.Spec.AccessModes,
+			Resources: corev1.ResourceRequirements{
+				Requests: corev1.ResourceList{
+					corev1.ResourceStorage: pv.Spec.Capacity[corev1.ResourceStorage],
+				},
+			},
+			StorageClassName: &storageClassName,
+			VolumeMode:       pv.Spec.VolumeMode,
+			VolumeName:       pv.GetName(),
+		},
+	}
+	return &pvc
+}
@@ -0,0 +1,134 @@
+// +kubebuilder:object:generate=true
+// +groupName=demo.io
+// +versionName=v1
+package main
+
+import (
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// +kubebuilder:object:root=true
+// +kubebuilder:subresource:status
+// +kubebuilder:printcolumn:name="Claim",type=string,JSONPath=`.spec.sourcePVC`
+// +kubebuilder:printcolumn:name="StorageClass",type=string,JSONPath=`.spec.storageClassName`
+// +kubebuilder:printcolumn:name="Phase",type=string,JSONPath=`.status.phase`
+// +kubebuilder:printcolumn:name="Old Volume",type=string,JSONPath=`.status.sourceVolumeName`,priority=1
+// +kubebuilder:printcolumn:name="Age",type=date,JSONPath=`.metadata.creationTimestamp`
+// VolumeMigration moves the data of a PersistentVolumeClaim to a new volume
+// of another StorageClass and rebinds the claim to the new volume.
+type VolumeMigration struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+	// Spec contains the claim, the new storage class and the workload.
+	Spec VolumeMigrationSpec `json:"spec"`
+	// +optional
+	Status VolumeMigrationStatus `json:"status,omitempty"`
+}
+
+// VolumeMigrationSpec contains the information of volume migration
+type VolumeMigrationSpec struct {
+	// SourcePVC is the name of the claim in the namespace of the migration
+	// that is migrated. The claim keeps its name.
+	SourcePVC string `json:"sourcePVC"`
+	// StorageClassName of the new volume.
+	StorageClassName string `json:"storageClassName"`
+	// +optional
+	// Capacity of the new volume. Defaults to the capacity of the old one.
+	Capacity *resource.Quantity `json:"capacity,omitempty"`
+	// +optional
+	// Workload that uses the claim. It is scaled down for the final sync.
+	// Defaults to the Deployment or StatefulSet of the pods using the
+	// claim when the migration starts.
+	Workload *WorkloadReference `json:"workload,omitempty"`
+	// +optional
+	// RsyncFlags are passed to the rsync client of both syncs. Defaults to
+	// `--archive --delete`.
+	RsyncFlags []string `json:"rsyncFlags,omitempty"`
+}
+
+// WorkloadReference points to a Deployment or StatefulSet.
+type WorkloadReference struct {
+	// +kubebuilder:validation:Enum=Deployment;StatefulSet
+	// Kind of the workload.
+	Kind string `json:"kind"`
+	// Name of the workload.
+	Name string `json:"name"`
+}
+
+// VolumeMigrationPhase is the phase of a volume migration.
+type VolumeMigrationPhase string
+
+const (
+	// VolumeMigrationPending means the migration didn't start yet.
+	VolumeMigrationPending VolumeMigrationPhase = "Pending"
+	// VolumeMigrationWarmSync means the data is copied while the workload
+	// is running.
+	VolumeMigrationWarmSync VolumeMigrationPhase = "WarmSync"
+	// VolumeMigrationScalingDown means the workload is scaled to zero.
+	VolumeMigrationScalingDown VolumeMigrationPhase = "ScalingDown"
+	// VolumeMigrationFinalSync means the changes since the warm sync are
+	// copied.
+	VolumeMigrationFinalSync VolumeMigrationPhase = "FinalSync"
+	// VolumeMigrationSwappingClaims means the claim is rebound to the new
+	// volume.
+	VolumeMigrationSwappingClaims VolumeMigrationPhase = "SwappingClaims"
+	// VolumeMigrationScalingUp means the workload is scaled back.
+	VolumeMigrationScalingUp VolumeMigrationPhase = "ScalingUp"
+	// VolumeMigrationSucceeded means the claim is bound to the new volume.
+	VolumeMigrationSucceeded VolumeMigrationPhase = "Succeeded"
+	// VolumeMigrationFailed means the migration stopped before the claims
+	// were swapped.
+	VolumeMigrationFailed VolumeMigrationPhase = "Failed"
+)
+
+// VolumeMigrationStatus contains the information of volume migration. It
+// holds everything needed to resume the migration after a restart of the
+// controller.
+type VolumeMigrationStatus struct {
+	// +optional
+	Phase VolumeMigrationPhase `json:"phase,omitempty"`
+	// +optional
+	// Message explains the phase.
+	Message string `json:"message,omitempty"`
+	// +optional
+	// StartTime is the time the migration started.
+	StartTime *metav1.Time `json:"startTime,omitempty"`
+	// +optional
+	// CompletionTime is the time the migration finished.
+	CompletionTime *metav1.Time `json:"completionTime,omitempty"`
+	// +optional
+	// TargetPVC is the claim the new volume is provisioned with.
+	TargetPVC string `json:"targetPVC,omitempty"`
+	// +optional
+	// Workload that is scaled down for the final sync.
+	Workload *WorkloadReference `json:"workload,omitempty"`
+	// +optional
+	// OriginalReplicas of the workload before it was scaled down.
+	OriginalReplicas *int32 `json:"originalReplicas,omitempty"`
+	// +optional
+	// SourceVolumeName is the old volume. It is retained after the claims
+	// are swapped and has to be deleted by the user.
+	SourceVolumeName string `json:"sourceVolumeName,omitempty"`
+	// +optional
+	// TargetVolumeName is the new volume.
+	TargetVolumeName string `json:"targetVolumeName,omitempty"`
+	// +optional
+	// TargetReclaimPolicy is the reclaim policy of the new volume, restored
+	// after the claims are swapped.
+	TargetReclaimPolicy corev1.PersistentVolumeReclaimPolicy `json:"targetReclaimPolicy,omitempty"`
+	// +optional
+	// ClaimLabels are the labels of the old claim, copied to the claim that
+	// is bound to the new volume.
+	ClaimLabels map[string]string `json:"claimLabels,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+// VolumeMigrationList is a list of VolumeMigration objects
+type VolumeMigrationList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	// List of VolumeMigrations
+	Items []VolumeMigration `json:"items"`
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	autoscalingv1 "k8s.io/api/autoscaling/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	deploymentKind  = "Deployment"
+	statefulSetKind = "StatefulSet"
+	replicaSetKind  = "ReplicaSet"
+)
+
+// podsUsingClaim returns the pods that aren't finished and mount a claim.
+func (c *controller) podsUsingClaim(ctx context.Context, namespace, claimName string) ([]corev1.Pod, error) {
+	pods, err := c.kubeClient.CoreV1().Pods(namespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+	using := []corev1.Pod{}
+	for _, pod := range pods.Items {
+		if pod.Status.Phase == corev1.PodSucceeded || pod.Status.Phase == corev1.PodFailed {
+			continue
+		}
+		for _, v := range pod.Spec.Volumes {
+			if v.PersistentVolumeClaim != nil && v.PersistentVolumeClaim.ClaimName == claimName {
+				using = append(using, pod)
+				break
+			}
+		}
+	}
+	return using, nil
+}
+
+// workloadOfPod returns the Deployment or StatefulSet of a pod or nil.
+func (c *controller) workloadOfPod(ctx context.Context, pod *corev1.Pod) (*WorkloadReference, error) {
+	owner := metav1.GetControllerOf(pod)
+	if owner == nil {
+		return nil, nil
+	}
+	switch owner.Kind {
+	case statefulSetKind:
+		return &WorkloadReference{Kind: statefulSetKind, Name: owner.Name}, nil
+	case replicaSetKind:
+		rs, err := c.kubeClient.AppsV1().ReplicaSets(pod.GetNamespace()).Get(ctx, owner.Name, metav1.GetOptions{})
+		if err != nil {
+			if errors.IsNotFound(err) {
+				return nil, nil
+			}
+			return nil, err
+		}
+		if owner := metav1.GetControllerOf(rs); owner != nil && owner.Kind == deploymentKind {
+			return &WorkloadReference{Kind: deploymentKind, Name: owner.Name}, nil
+		}
+	}
+	return nil, nil
+}
+
+// getScale returns the scale subresource of a workload.
+func (c *controller) getScale(ctx context.Context, namespace string, workload *WorkloadReference) (*autoscalingv1.Scale, error) {
+	switch workload.Kind {
+	case deploymentKind:
+		return c.kubeClient.AppsV1().Deployments(namespace).GetScale(ctx, workload.Name, metav1.GetOptions{})
+	case statefulSetKind:
+		return c.kubeClient.AppsV1().StatefulSets(namespace).GetScale(ctx, workload.Name, metav1.GetOptions{})
+	}
+	return nil, fmt.Errorf("unsupported workload kind `%s`", workload.Kind)
+}
+
+// scale sets the replicas of a workload.
+func (c *controller) scale(ctx context.Context, namespace string, workload *WorkloadReference, replicas int32) error {
+	scale, err := c.getScale(ctx, namespace, workload)
+	if err != nil {
+		return err
+	}
+	if scale.Spec.Replicas == replicas {
+		return nil
+	}
+	scale.Spec.Replicas = replicas
+	switch workload.Kind {
+	case deploymentKind:
+		_, err = c.kubeClient.AppsV1().Deployments(namespace).UpdateScale(ctx, workload.Name, scale, metav1.UpdateOptions{})
+	case statefulSetKind:
+		_, err = c.kubeClient.AppsV1().StatefulSets(namespace).UpdateScale(ctx, workload.Name, scale, metav1.UpdateOptions{})
+	}
+	return err
+}
+
+// workloadPodCount returns the number of pods of a workload, including the
+// terminating ones that may still have the claim mounted.
+func (c *controller) workloadPodCount(ctx context.Context, namespace string, workload *WorkloadReference) (int, error) {
+	scale, err := c.getScale(ctx, namespace, workload)
+	if err != nil {
+		return 0, err
+	}
+	pods, err := c.kubeClient.CoreV1().Pods(namespace).List(ctx, metav1.ListOptions{
+		LabelSelector: scale.Status.Selector,
+	})
+	if err != nil {
+		return 0, err
+	}
+	return len(pods.Items), nil
+}
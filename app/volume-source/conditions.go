@@ -0,0 +1,51 @@
+package main
+
+import (
+	"context"
+
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/k8s-volume-copy/volume-source/pkg/conditions"
+)
+
+const (
+	// hostNameConditionType reports whether the node of a rsync source has
+	// a kubernetes.io/hostname label the source can be scheduled with.
+	hostNameConditionType = "HostNameResolved"
+
+	reasonHostNameFound        = "HostNameFound"
+	reasonMissingHostNameLabel = "MissingHostNameLabel"
+)
+
+// setRsyncSourceCondition sets a condition on the rsync source with the
+// given name. The cached source is checked first, so a condition that is
+// already set costs no API call. It is a no-op if the source doesn't exist.
+func (c *controller) setRsyncSourceCondition(ctx context.Context, name string, condition metav1.Condition) error {
+	cached, err := c.rsyncSourceLister.Namespace(namespace).Get(name)
+	if err != nil {
+		if errors.IsNotFound(err) {
+			return nil
+		}
+		return err
+	}
+	if _, changed, err := conditions.Set(cached.GetAnnotations(), condition); err != nil || !changed {
+		return err
+	}
+	obj, err := c.dynamicClient.Resource(rsyncSourceGVR).Namespace(namespace).
+		Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		if errors.IsNotFound(err) {
+			return nil
+		}
+		return err
+	}
+	annotations, changed, err := conditions.Set(obj.GetAnnotations(), condition)
+	if err != nil || !changed {
+		return err
+	}
+	obj.SetAnnotations(annotations)
+	_, err = c.dynamicClient.Resource(rsyncSourceGVR).Namespace(namespace).
+		Update(ctx, obj, metav1.UpdateOptions{})
+	return err
+}
@@ -5,10 +5,12 @@ import (
 	"fmt"
 	"os"
 	"os/signal"
+	"reflect"
 	"strings"
 	"syscall"
 	"time"
 
+	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
@@ -21,9 +23,12 @@ import (
 	"k8s.io/client-go/dynamic/dynamiclister"
 	"k8s.io/client-go/informers"
 	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/scheme"
+	typedcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
 	corelisters "k8s.io/client-go/listers/core/v1"
 	"k8s.io/client-go/rest"
 	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/record"
 	"k8s.io/client-go/util/workqueue"
 	"k8s.io/klog/v2"
 
@@ -50,8 +55,13 @@ type controller struct {
 	rsyncSourceLister dynamiclister.Lister
 	rsyncSourceSynced cache.InformerSynced
 	nodeLister        corelisters.NodeLister
+	nodeIndexer       cache.Indexer
 	nodeSynced        cache.InformerSynced
+	podIndexer        cache.Indexer
+	pvcLister         corelisters.PersistentVolumeClaimLister
+	onDemandSynced    []cache.InformerSynced
 	workqueue         workqueue.RateLimitingInterface
+	recorder          record.EventRecorder
 }
 
 func runController(cfg *rest.Config) {
@@ -76,19 +86,39 @@ func runController(cfg *rest.Config) {
 		klog.Fatalf("Failed to create dynamic client: %v", err)
 	}
 
+	eventBroadcaster := record.NewBroadcaster()
+	eventBroadcaster.StartStructuredLogging(0)
+	eventBroadcaster.StartRecordingToSink(&typedcorev1.EventSinkImpl{Interface: kubeClient.CoreV1().Events("")})
+	recorder := eventBroadcaster.NewRecorder(scheme.Scheme, corev1.EventSource{Component: "volume-source-controller"})
+
 	informerFactory := informers.NewSharedInformerFactory(kubeClient, 30*time.Second)
 	nodeInformer := informerFactory.Core().V1().Nodes().Informer()
+	if err := nodeInformer.AddIndexers(cache.Indexers{hostNameIndex: hostNameIndexFunc}); err != nil {
+		klog.Fatalf("Failed to add node indexer: %v", err)
+	}
+	if err := nodeInformer.SetTransform(transformNode); err != nil {
+		klog.Fatalf("Failed to set node transform: %v", err)
+	}
 
-	dynamicInformerFactory := dynamicinformer.NewDynamicSharedInformerFactory(dynamicClient, 30*time.Second)
+	// Only rsync sources created by this controller are cached.
+	dynamicInformerFactory := dynamicinformer.NewFilteredDynamicSharedInformerFactory(dynamicClient,
+		30*time.Second, namespace, func(options *metav1.ListOptions) {
+			options.LabelSelector = constant.CreatedByLabel + "=volume-source-controller"
+		})
 	rsyncSourceInformer := dynamicInformerFactory.ForResource(rsyncSourceGVR).Informer()
+	if err := rsyncSourceInformer.SetTransform(transformRsyncSource); err != nil {
+		klog.Fatalf("Failed to set rsync source transform: %v", err)
+	}
 	c := &controller{
 		kubeClient:        kubeClient,
 		dynamicClient:     dynamicClient,
 		rsyncSourceLister: dynamiclister.New(rsyncSourceInformer.GetIndexer(), rsyncSourceGVR),
 		rsyncSourceSynced: rsyncSourceInformer.HasSynced,
 		nodeLister:        informerFactory.Core().V1().Nodes().Lister(),
+		nodeIndexer:       nodeInformer.GetIndexer(),
 		nodeSynced:        nodeInformer.HasSynced,
 		workqueue:         workqueue.NewRateLimitingQueue(workqueue.DefaultControllerRateLimiter()),
+		recorder:          recorder,
 	}
 
 	rsyncSourceInformer.AddEventHandler(cache.ResourceEventHandlerFuncs{
@@ -107,6 +137,42 @@ func runController(cfg *rest.Config) {
 		DeleteFunc: c.handleNode,
 	})
 
+	if exportMode == exportModeOnDemand {
+		podInformer := informerFactory.Core().V1().Pods().Informer()
+		if err := podInformer.AddIndexers(cache.Indexers{
+			podNodeNameIndex: podNodeNameIndexFunc,
+			podClaimIndex:    podClaimIndexFunc,
+		}); err != nil {
+			klog.Fatalf("Failed to add pod indexers: %v", err)
+		}
+		if err := podInformer.SetTransform(transformPod); err != nil {
+			klog.Fatalf("Failed to set pod transform: %v", err)
+		}
+		pvcInformer := informerFactory.Core().V1().PersistentVolumeClaims().Informer()
+		if err := pvcInformer.SetTransform(transformPersistentVolumeClaim); err != nil {
+			klog.Fatalf("Failed to set persistent volume claim transform: %v", err)
+		}
+		c.podIndexer = podInformer.GetIndexer()
+		c.pvcLister = informerFactory.Core().V1().PersistentVolumeClaims().Lister()
+		c.onDemandSynced = []cache.InformerSynced{podInformer.HasSynced, pvcInformer.HasSynced}
+
+		podInformer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+			AddFunc: c.handlePod,
+			UpdateFunc: func(oldObj, newObj interface{}) {
+				c.handlePod(newObj)
+			},
+			DeleteFunc: c.handlePod,
+		})
+
+		pvcInformer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+			AddFunc: c.handlePersistentVolumeClaim,
+			UpdateFunc: func(oldObj, newObj interface{}) {
+				c.handlePersistentVolumeClaim(newObj)
+			},
+			DeleteFunc: c.handlePersistentVolumeClaim,
+		})
+	}
+
 	dynamicInformerFactory.Start(stopCh)
 	informerFactory.Start(stopCh)
 	if err := c.run(stopCh); nil != err {
@@ -134,7 +200,8 @@ func (c *controller) run(stopCh <-chan struct{}) error {
 	defer utilruntime.HandleCrash()
 	defer c.workqueue.ShutDown()
 
-	if ok := cache.WaitForCacheSync(stopCh, c.rsyncSourceSynced, c.nodeSynced); !ok {
+	synced := append([]cache.InformerSynced{c.rsyncSourceSynced, c.nodeSynced}, c.onDemandSynced...)
+	if ok := cache.WaitForCacheSync(stopCh, synced...); !ok {
 		return fmt.Errorf("failed to wait for caches to sync")
 	}
 
@@ -209,17 +276,36 @@ func (c *controller) syncRsyncSource(ctx context.Context, namespace, name string
 	if rsyncSource.GetLabels() == nil || rsyncSource.GetLabels()[constant.CreatedByLabel] != "volume-source-controller" {
 		return nil
 	}
+	if _, ok := rsyncSource.GetAnnotations()[nodeNameAnnotation]; ok {
+		nodeName := nodeNameOfRsyncSource(name, rsyncSource.GetAnnotations())
+		node, err := c.nodeLister.Get(nodeName)
+		if err != nil {
+			if errors.IsNotFound(err) {
+				return c.ensureRsyncSource(false, namespace, getRsyncSourceStub(name))
+			}
+			return err
+		}
+		// The node sync reconciles the source against the live hostname label.
+		if node.GetLabels()[constant.K8SIOHostName] != rsyncSource.Spec.HostName {
+			c.workqueue.Add("node/" + nodeName)
+		}
+		return nil
+	}
+	// The source uses the legacy naming scheme. If its node still exists the
+	// node sync creates the replacement and deletes this source.
+	if _, err := c.nodeLister.Get(name); err == nil {
+		c.workqueue.Add("node/" + name)
+		return nil
+	}
 	if rsyncSource.Spec.HostName == "" {
 		return nil
 	}
-	nodeList, err := c.kubeClient.CoreV1().Nodes().List(ctx, metav1.ListOptions{
-		LabelSelector: constant.K8SIOHostName + "=" + rsyncSource.Spec.HostName,
-	})
+	nodes, err := c.nodeIndexer.ByIndex(hostNameIndex, rsyncSource.Spec.HostName)
 	if err != nil {
 		return err
 	}
-	if len(nodeList.Items) == 0 {
-		return c.ensureRsyncSource(false, namespace, getRsyncSourceTemplate(name, rsyncSource.Spec.HostName))
+	if len(nodes) == 0 {
+		return c.ensureRsyncSource(false, namespace, getRsyncSourceStub(name))
 	}
 	return nil
 }
@@ -233,19 +319,72 @@ func (c *controller) syncNode(ctx context.Context, name string) error {
 		}
 		return fmt.Errorf("error getting node error: %s", err)
 	}
-	if node.GetLabels() == nil {
-		return fmt.Errorf("error processing node `%s` sync missing node label", node.GetName())
-	}
 	hostName := node.GetLabels()[constant.K8SIOHostName]
-	if node.GetLabels() == nil {
-		return fmt.Errorf("error processing node sync missing `%s` kubernetes.io/hostname label", node.GetName())
+	if hostName == "" {
+		// Without the label the daemon can't be pinned to the node. An
+		// existing source is kept, the label may come back. The node is
+		// reported even without a source, repeated events are aggregated by
+		// the recorder.
+		message := fmt.Sprintf("node has no `%s` label, rsync source can't be scheduled", constant.K8SIOHostName)
+		c.recorder.Event(node, corev1.EventTypeWarning, reasonMissingHostNameLabel, message)
+		return c.setRsyncSourceCondition(ctx, rsyncSourceNameForNode(node.GetName()), metav1.Condition{
+			Type:    hostNameConditionType,
+			Status:  metav1.ConditionFalse,
+			Reason:  reasonMissingHostNameLabel,
+			Message: message,
+		})
+	}
+	template, err := getRsyncSourceTemplate(node.GetName(), hostName, node.GetAnnotations())
+	if err != nil {
+		return fmt.Errorf("error creating rsync source template for node `%s`, error: %s", node.GetName(), err)
+	}
+	want := true
+	if exportMode == exportModeOnDemand {
+		var requeueAfter time.Duration
+		want, requeueAfter, err = c.syncOnDemand(ctx, node.GetName(), template)
+		if err != nil {
+			return err
+		}
+		if requeueAfter > 0 {
+			c.workqueue.AddAfter("node/"+node.GetName(), requeueAfter)
+		}
 	}
-	return c.ensureRsyncSource(true, namespace, getRsyncSourceTemplate(node.GetName(), hostName))
+	if err := c.ensureRsyncSource(want, namespace, template); err != nil {
+		return err
+	}
+	if !want {
+		return c.migrateLegacyRsyncSource(node.GetName(), template.GetName())
+	}
+	if err := c.setRsyncSourceCondition(ctx, template.GetName(), metav1.Condition{
+		Type:    hostNameConditionType,
+		Status:  metav1.ConditionTrue,
+		Reason:  reasonHostNameFound,
+		Message: fmt.Sprintf("rsync source is scheduled with `%s=%s`", constant.K8SIOHostName, hostName),
+	}); err != nil {
+		return err
+	}
+	return c.migrateLegacyRsyncSource(node.GetName(), template.GetName())
+}
+
+// migrateLegacyRsyncSource deletes the rsync source that was named after the
+// node before the DNS safe naming scheme, once its replacement exists.
+func (c *controller) migrateLegacyRsyncSource(nodeName, name string) error {
+	if nodeName == name {
+		return nil
+	}
+	if _, err := c.rsyncSourceLister.Namespace(namespace).Get(nodeName); err != nil {
+		if errors.IsNotFound(err) {
+			return nil
+		}
+		return err
+	}
+	klog.Infof("Replacing rsync source `%s` of node `%s` with `%s`", nodeName, nodeName, name)
+	return c.ensureRsyncSource(false, namespace, getRsyncSourceStub(nodeName))
 }
 
 /*
 if found and not created by the populator then return error
-if want and found return nil
+if want and found -> update spec and modules if changed return error/nil
 if !want and !found return nil
 if want and !found -> create return error/nil
 if !want and found -> delete return error/nil
@@ -253,7 +392,7 @@ if !want and found -> delete return error/nil
 func (c *controller) ensureRsyncSource(want bool, namespace string, rsyncSource *internalv1.RsyncSource) error {
 	found := true
 	rsyncSourceClone := rsyncSource.DeepCopy()
-	populatorMap, err := runtime.DefaultUnstructuredConverter.ToUnstructured(&rsyncSourceClone)
+	populatorMap, err := runtime.DefaultUnstructuredConverter.ToUnstructured(rsyncSourceClone)
 	if err != nil {
 		return err
 	}
@@ -273,7 +412,7 @@ func (c *controller) ensureRsyncSource(want bool, namespace string, rsyncSource
 		return fmt.Errorf("resource found but not created by this operator")
 	}
 	if want && found {
-		return nil
+		return c.updateRsyncSource(obj, rsyncSourceClone)
 	}
 	if !want && !found {
 		return nil
@@ -290,3 +429,35 @@ func (c *controller) ensureRsyncSource(want bool, namespace string, rsyncSource
 	}
 	return nil
 }
+
+// updateRsyncSource updates the spec and the modules of an existing rsync
+// source if they differ from the template.
+func (c *controller) updateRsyncSource(obj *unstructured.Unstructured, template *internalv1.RsyncSource) error {
+	existing := internalv1.RsyncSource{}
+	if err := runtime.DefaultUnstructuredConverter.FromUnstructured(obj.UnstructuredContent(),
+		&existing); err != nil {
+		return err
+	}
+	if reflect.DeepEqual(existing.Spec, template.Spec) &&
+		existing.GetAnnotations()[modulesAnnotation] == template.GetAnnotations()[modulesAnnotation] {
+		return nil
+	}
+	existing.Spec = template.Spec
+	annotations := existing.GetAnnotations()
+	if annotations == nil {
+		annotations = map[string]string{}
+	}
+	if modules, ok := template.GetAnnotations()[modulesAnnotation]; ok {
+		annotations[modulesAnnotation] = modules
+	} else {
+		delete(annotations, modulesAnnotation)
+	}
+	existing.SetAnnotations(annotations)
+	existingMap, err := runtime.DefaultUnstructuredConverter.ToUnstructured(&existing)
+	if err != nil {
+		return err
+	}
+	_, err = c.dynamicClient.Resource(rsyncSourceGVR).Namespace(existing.GetNamespace()).
+		Update(context.TODO(), &unstructured.Unstructured{Object: existingMap}, metav1.UpdateOptions{})
+	return err
+}
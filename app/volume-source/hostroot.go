@@ -0,0 +1,111 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+const (
+	// dataModuleName is the rsync module that serves the kubelet pod dir.
+	dataModuleName = "data"
+	// modulesAnnotation holds the additional rsync modules of a source.
+	// It must match the annotation read by rsync-source.
+	modulesAnnotation = "demo.io/modules"
+	// hostRootAnnotationPrefix is the default prefix of the node annotation
+	// that overrides the path of a host root on that node.
+	hostRootAnnotationPrefix = "demo.io/host-root-"
+)
+
+// hostRoot is a directory on every node that is exported as a rsync module.
+type hostRoot struct {
+	// module is the rsync module name.
+	module string
+	// path is the default directory on the node.
+	path string
+	// readOnly exports the directory as a read only module.
+	readOnly bool
+	// nodeAnnotation is the node annotation that overrides path.
+	nodeAnnotation string
+}
+
+// pathFor returns the directory of the host root on the given node.
+func (h hostRoot) pathFor(nodeAnnotations map[string]string) string {
+	if p := nodeAnnotations[h.nodeAnnotation]; p != "" {
+		return p
+	}
+	return h.path
+}
+
+func (h hostRoot) volumeFor(nodeAnnotations map[string]string) corev1.Volume {
+	return corev1.Volume{
+		Name: h.module,
+		VolumeSource: corev1.VolumeSource{
+			HostPath: &corev1.HostPathVolumeSource{
+				Path: h.pathFor(nodeAnnotations),
+			},
+		},
+	}
+}
+
+// hostRootList is a flag.Value holding the additional host roots. Each
+// occurrence of the flag adds one root in the form
+// module=<name>,path=<dir>[,read-only=<bool>][,node-annotation=<key>]
+type hostRootList []hostRoot
+
+func (l *hostRootList) String() string {
+	roots := []string{}
+	for _, h := range *l {
+		roots = append(roots, fmt.Sprintf("module=%s,path=%s,read-only=%t,node-annotation=%s",
+			h.module, h.path, h.readOnly, h.nodeAnnotation))
+	}
+	return strings.Join(roots, " ")
+}
+
+func (l *hostRootList) Set(value string) error {
+	h := hostRoot{readOnly: true}
+	for _, kv := range strings.Split(value, ",") {
+		parts := strings.SplitN(kv, "=", 2)
+		if len(parts) != 2 {
+			return fmt.Errorf("invalid host root option `%s`", kv)
+		}
+		switch parts[0] {
+		case "module":
+			h.module = parts[1]
+		case "path":
+			h.path = parts[1]
+		case "read-only":
+			readOnly, err := strconv.ParseBool(parts[1])
+			if err != nil {
+				return fmt.Errorf("invalid read-only value `%s`: %s", parts[1], err)
+			}
+			h.readOnly = readOnly
+		case "node-annotation":
+			h.nodeAnnotation = parts[1]
+		default:
+			return fmt.Errorf("unknown host root option `%s`", parts[0])
+		}
+	}
+	if errs := validation.IsDNS1123Label(h.module); len(errs) != 0 {
+		return fmt.Errorf("invalid module name `%s`: %v", h.module, errs)
+	}
+	if h.module == dataModuleName {
+		return fmt.Errorf("module name `%s` is reserved for the kubelet pod dir", dataModuleName)
+	}
+	if !strings.HasPrefix(h.path, "/") {
+		return fmt.Errorf("host root path `%s` must be absolute", h.path)
+	}
+	for _, existing := range *l {
+		if existing.module == h.module {
+			return fmt.Errorf("duplicate host root module `%s`", h.module)
+		}
+	}
+	if h.nodeAnnotation == "" {
+		h.nodeAnnotation = hostRootAnnotationPrefix + h.module
+	}
+	*l = append(*l, h)
+	return nil
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestHostRootListSet(t *testing.T) {
+	tests := []struct {
+		name     string
+		existing hostRootList
+		value    string
+		want     hostRoot
+		wantErr  bool
+	}{
+		{
+			name:  "defaults",
+			value: "module=local,path=/opt/local-path-provisioner",
+			want: hostRoot{
+				module:         "local",
+				path:           "/opt/local-path-provisioner",
+				readOnly:       true,
+				nodeAnnotation: "demo.io/host-root-local",
+			},
+		},
+		{
+			name:  "all options",
+			value: "module=plugins,path=/var/lib/kubelet/plugins,read-only=false,node-annotation=example.com/plugins",
+			want: hostRoot{
+				module:         "plugins",
+				path:           "/var/lib/kubelet/plugins",
+				readOnly:       false,
+				nodeAnnotation: "example.com/plugins",
+			},
+		},
+		{
+			name:    "option without value",
+			value:   "module=local,path",
+			wantErr: true,
+		},
+		{
+			name:    "unknown option",
+			value:   "module=local,path=/opt,mode=rw",
+			wantErr: true,
+		},
+		{
+			name:    "invalid read-only",
+			value:   "module=local,path=/opt,read-only=maybe",
+			wantErr: true,
+		},
+		{
+			name:    "missing module",
+			value:   "path=/opt",
+			wantErr: true,
+		},
+		{
+			name:    "invalid module name",
+			value:   "module=Local_Path,path=/opt",
+			wantErr: true,
+		},
+		{
+			name:    "reserved module name",
+			value:   "module=data,path=/opt",
+			wantErr: true,
+		},
+		{
+			name:    "relative path",
+			value:   "module=local,path=opt/local",
+			wantErr: true,
+		},
+		{
+			name:     "duplicate module",
+			existing: hostRootList{{module: "local", path: "/opt"}},
+			value:    "module=local,path=/srv",
+			wantErr:  true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := append(hostRootList{}, tt.existing...)
+			err := l.Set(tt.value)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Set() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				if len(l) != len(tt.existing) {
+					t.Errorf("Set() added %d roots on error", len(l)-len(tt.existing))
+				}
+				return
+			}
+			if got := l[len(l)-1]; !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Set() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHostRootPathFor(t *testing.T) {
+	h := hostRoot{module: "local", path: "/opt/local", nodeAnnotation: "demo.io/host-root-local"}
+	tests := []struct {
+		name            string
+		nodeAnnotations map[string]string
+		want            string
+	}{
+		{
+			name: "default path",
+			want: "/opt/local",
+		},
+		{
+			name:            "node override",
+			nodeAnnotations: map[string]string{"demo.io/host-root-local": "/mnt/local"},
+			want:            "/mnt/local",
+		},
+		{
+			name:            "empty override",
+			nodeAnnotations: map[string]string{"demo.io/host-root-local": ""},
+			want:            "/opt/local",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := h.pathFor(tt.nodeAnnotations); got != tt.want {
+				t.Errorf("pathFor() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
@@ -3,6 +3,7 @@ package main
 import (
 	"flag"
 	"path/filepath"
+	"time"
 
 	"k8s.io/client-go/rest"
 	"k8s.io/client-go/tools/clientcmd"
@@ -14,6 +15,11 @@ var (
 	rsyncDaemonImage  string
 	kubeletPodDirPath string
 	namespace         string
+	hostRoots         hostRootList
+	exportMode        string
+	exportAnnotation  string
+	idleTTL           time.Duration
+	idleAction        string
 )
 
 func main() {
@@ -26,10 +32,29 @@ func main() {
 		kubeconfig = flag.String("kubeconfig", "", "absolute path to the kubeconfig file")
 	}
 	flag.StringVar(&rsyncDaemonImage, "rsync-daemon-image", "ghcr.io/k8svol/rsync-daemon:ci", "Rsync daemon image")
-	flag.StringVar(&kubeletPodDirPath, "kubelet-pod-dir-path", "/var/lib/kubelet/pods", "Path of pods folder inside kubelet dir")
+	flag.StringVar(&kubeletPodDirPath, "kubelet-pod-dir-path", "/var/lib/kubelet/pods", "Path of pods folder inside kubelet dir, "+
+		"can be overridden per node with the "+hostRootAnnotationPrefix+dataModuleName+" annotation")
+	flag.Var(&hostRoots, "host-root", "Additional node directory exported as a rsync module, in the form "+
+		"module=<name>,path=<dir>[,read-only=<bool>][,node-annotation=<key>]. Can be repeated, e.g. for "+
+		"/opt/local-path-provisioner, local PV paths or /var/lib/kubelet/plugins")
 	flag.StringVar(&namespace, "namespace", "k8svol", "Namespace of rsync source deployment")
+	flag.StringVar(&exportMode, "mode", exportModeAlways, "Either 'always' to run a rsync source on every node or "+
+		"'on-demand' to run it only on nodes where a claim with the export annotation is mounted")
+	flag.StringVar(&exportAnnotation, "export-annotation", "k8svol.io/export",
+		"Claim annotation that exports the node of the claim in on-demand mode, its value has to be 'true'")
+	flag.DurationVar(&idleTTL, "idle-ttl", 10*time.Minute,
+		"Time a rsync source is kept in on-demand mode after the last exported claim left its node")
+	flag.StringVar(&idleAction, "idle-action", idleActionScale,
+		"Either 'scale' to scale an idle rsync source to zero or 'delete' to delete it")
 	flag.Parse()
 
+	if exportMode != exportModeAlways && exportMode != exportModeOnDemand {
+		klog.Fatalf("invalid mode `%s`, must be `%s` or `%s`", exportMode, exportModeAlways, exportModeOnDemand)
+	}
+	if idleAction != idleActionScale && idleAction != idleActionDelete {
+		klog.Fatalf("invalid idle action `%s`, must be `%s` or `%s`", idleAction, idleActionScale, idleActionDelete)
+	}
+
 	cfg, err := clientcmd.BuildConfigFromFlags("", *kubeconfig)
 	if err != nil {
 		cfg, err = rest.InClusterConfig()
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+const (
+	// nodeNameLabel is added to rsync sources created for a node. It is only
+	// set if the node name is a valid label value, i.e. at most 63 chars.
+	nodeNameLabel = "demo.io/node-name"
+	// nodeNameAnnotation holds the full name of the node of a rsync source.
+	nodeNameAnnotation = "demo.io/node-name"
+	// nameHashLength is the number of hex chars of the node name hash that
+	// are appended to the rsync source name.
+	nameHashLength = 10
+)
+
+// rsyncSourceNameForNode returns the name of the rsync source of a node.
+// rsync-source reuses the name for the deployment, service and configmap,
+// so it has to be a DNS-1035 label. Node names can be FQDNs longer than 63
+// chars, hence the name is a sanitized and truncated prefix of the node
+// name followed by a hash of the full node name.
+func rsyncSourceNameForNode(nodeName string) string {
+	sum := sha256.Sum256([]byte(nodeName))
+	suffix := hex.EncodeToString(sum[:])[:nameHashLength]
+
+	prefix := strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= '0' && r <= '9':
+			return r
+		case r >= 'A' && r <= 'Z':
+			return r - 'A' + 'a'
+		default:
+			return '-'
+		}
+	}, nodeName)
+	if prefix == "" || prefix[0] < 'a' || prefix[0] > 'z' {
+		prefix = "node-" + prefix
+	}
+	maxPrefixLength := validation.DNS1035LabelMaxLength - nameHashLength - 1
+	if len(prefix) > maxPrefixLength {
+		prefix = prefix[:maxPrefixLength]
+	}
+	prefix = strings.TrimRight(prefix, "-")
+	return prefix + "-" + suffix
+}
+
+// nodeNameOfRsyncSource returns the node name of a rsync source created by
+// this controller. Sources created before the node name annotation was
+// introduced are named after their node.
+func nodeNameOfRsyncSource(name string, annotations map[string]string) string {
+	if nodeName, ok := annotations[nodeNameAnnotation]; ok {
+		return nodeName
+	}
+	return name
+}
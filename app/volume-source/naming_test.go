@@ -0,0 +1,96 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+func TestRsyncSourceNameForNode(t *testing.T) {
+	tests := []struct {
+		name       string
+		nodeName   string
+		wantPrefix string
+	}{
+		{
+			name:       "short name",
+			nodeName:   "worker-1",
+			wantPrefix: "worker-1-",
+		},
+		{
+			name:       "FQDN",
+			nodeName:   "Worker-1.Example.COM",
+			wantPrefix: "worker-1-example-com-",
+		},
+		{
+			name:       "leading digit",
+			nodeName:   "10.0.0.1",
+			wantPrefix: "node-10-0-0-1-",
+		},
+		{
+			name:       "long FQDN",
+			nodeName:   "ip-10-0-0-1.eu-central-1.compute.internal.very-long-cluster-domain.example.com",
+			wantPrefix: "ip-10-0-0-1-eu-central-1-compute-internal-very-long-",
+		},
+		{
+			name:       "truncated at separator",
+			nodeName:   strings.Repeat("a", 51) + ".b",
+			wantPrefix: strings.Repeat("a", 51) + "-",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := rsyncSourceNameForNode(tt.nodeName)
+			if errs := validation.IsDNS1035Label(got); len(errs) != 0 {
+				t.Errorf("rsyncSourceNameForNode() = %s is no DNS-1035 label: %v", got, errs)
+			}
+			if !strings.HasPrefix(got, tt.wantPrefix) {
+				t.Errorf("rsyncSourceNameForNode() = %s, want prefix %s", got, tt.wantPrefix)
+			}
+			if strings.Contains(got, "--") {
+				t.Errorf("rsyncSourceNameForNode() = %s has an empty segment before the hash", got)
+			}
+			if again := rsyncSourceNameForNode(tt.nodeName); again != got {
+				t.Errorf("rsyncSourceNameForNode() is not stable: %s != %s", again, got)
+			}
+		})
+	}
+}
+
+func TestRsyncSourceNameForNodeUnique(t *testing.T) {
+	// The sanitized names collide, the hash tells them apart.
+	a := rsyncSourceNameForNode("node.a")
+	b := rsyncSourceNameForNode("node-a")
+	if a == b {
+		t.Errorf("rsyncSourceNameForNode() = %s for `node.a` and `node-a`", a)
+	}
+}
+
+func TestNodeNameOfRsyncSource(t *testing.T) {
+	tests := []struct {
+		name        string
+		source      string
+		annotations map[string]string
+		want        string
+	}{
+		{
+			name:        "annotated",
+			source:      "worker-1-0123456789",
+			annotations: map[string]string{nodeNameAnnotation: "worker-1"},
+			want:        "worker-1",
+		},
+		{
+			name:   "legacy source named after its node",
+			source: "worker-1",
+			want:   "worker-1",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := nodeNameOfRsyncSource(tt.source, tt.annotations); got != tt.want {
+				t.Errorf("nodeNameOfRsyncSource() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
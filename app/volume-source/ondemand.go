@@ -0,0 +1,262 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/klog/v2"
+
+	internalv1 "github.com/k8s-volume-copy/types/apis/demo.io/v1"
+)
+
+const (
+	// exportModeAlways keeps a rsync source on every node.
+	exportModeAlways = "always"
+	// exportModeOnDemand only runs a rsync source on nodes where a claim
+	// annotated with the export annotation is mounted.
+	exportModeOnDemand = "on-demand"
+
+	// idleActionScale scales an idle rsync source to zero replicas.
+	idleActionScale = "scale"
+	// idleActionDelete deletes an idle rsync source.
+	idleActionDelete = "delete"
+
+	// idleSinceAnnotation records when the last exported claim went away
+	// from the node of a rsync source.
+	idleSinceAnnotation = "demo.io/idle-since"
+
+	// podNodeNameIndex indexes cached pods by the node they run on.
+	podNodeNameIndex = "nodeName"
+	// podClaimIndex indexes cached pods by the claims they mount.
+	podClaimIndex = "claim"
+)
+
+// podNodeNameIndexFunc returns the node of a scheduled pod.
+func podNodeNameIndexFunc(obj interface{}) ([]string, error) {
+	pod, ok := obj.(*corev1.Pod)
+	if !ok || pod.Spec.NodeName == "" {
+		return nil, nil
+	}
+	return []string{pod.Spec.NodeName}, nil
+}
+
+// podClaimIndexFunc returns the namespace/name keys of the claims of a pod.
+func podClaimIndexFunc(obj interface{}) ([]string, error) {
+	pod, ok := obj.(*corev1.Pod)
+	if !ok {
+		return nil, nil
+	}
+	claims := []string{}
+	for _, v := range pod.Spec.Volumes {
+		if v.PersistentVolumeClaim != nil {
+			claims = append(claims, pod.GetNamespace()+"/"+v.PersistentVolumeClaim.ClaimName)
+		}
+	}
+	return claims, nil
+}
+
+// transformPod keeps the fields required to find the claims mounted on a
+// node. Only the claim volumes of a pod are kept.
+func transformPod(obj interface{}) (interface{}, error) {
+	pod, ok := obj.(*corev1.Pod)
+	if !ok {
+		return obj, nil
+	}
+	volumes := []corev1.Volume{}
+	for _, v := range pod.Spec.Volumes {
+		if v.PersistentVolumeClaim != nil {
+			volumes = append(volumes, v)
+		}
+	}
+	return &corev1.Pod{
+		TypeMeta: pod.TypeMeta,
+		ObjectMeta: metav1.ObjectMeta{
+			Name:              pod.Name,
+			Namespace:         pod.Namespace,
+			UID:               pod.UID,
+			ResourceVersion:   pod.ResourceVersion,
+			DeletionTimestamp: pod.DeletionTimestamp,
+		},
+		Spec: corev1.PodSpec{
+			NodeName: pod.Spec.NodeName,
+			Volumes:  volumes,
+		},
+		Status: corev1.PodStatus{
+			Phase: pod.Status.Phase,
+		},
+	}, nil
+}
+
+// transformPersistentVolumeClaim keeps the metadata of a claim.
+func transformPersistentVolumeClaim(obj interface{}) (interface{}, error) {
+	pvc, ok := obj.(*corev1.PersistentVolumeClaim)
+	if !ok {
+		return obj, nil
+	}
+	return &corev1.PersistentVolumeClaim{
+		TypeMeta: pvc.TypeMeta,
+		ObjectMeta: metav1.ObjectMeta{
+			Name:            pvc.Name,
+			Namespace:       pvc.Namespace,
+			UID:             pvc.UID,
+			ResourceVersion: pvc.ResourceVersion,
+			Annotations:     pvc.Annotations,
+		},
+	}, nil
+}
+
+func (c *controller) handlePod(obj interface{}) {
+	if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+		obj = tombstone.Obj
+	}
+	pod, ok := obj.(*corev1.Pod)
+	if !ok || pod.Spec.NodeName == "" {
+		return
+	}
+	for _, v := range pod.Spec.Volumes {
+		if v.PersistentVolumeClaim != nil {
+			c.workqueue.Add("node/" + pod.Spec.NodeName)
+			return
+		}
+	}
+}
+
+func (c *controller) handlePersistentVolumeClaim(obj interface{}) {
+	key, err := cache.DeletionHandlingMetaNamespaceKeyFunc(obj)
+	if err != nil {
+		return
+	}
+	pods, err := c.podIndexer.ByIndex(podClaimIndex, key)
+	if err != nil {
+		return
+	}
+	for _, obj := range pods {
+		c.handlePod(obj)
+	}
+}
+
+// exportedClaimsOnNode returns the exported claims mounted by running pods
+// on a node.
+func (c *controller) exportedClaimsOnNode(nodeName string) ([]string, error) {
+	pods, err := c.podIndexer.ByIndex(podNodeNameIndex, nodeName)
+	if err != nil {
+		return nil, err
+	}
+	claims := []string{}
+	for _, obj := range pods {
+		pod := obj.(*corev1.Pod)
+		if pod.Status.Phase == corev1.PodSucceeded || pod.Status.Phase == corev1.PodFailed {
+			continue
+		}
+		for _, v := range pod.Spec.Volumes {
+			if v.PersistentVolumeClaim == nil {
+				continue
+			}
+			pvc, err := c.pvcLister.PersistentVolumeClaims(pod.GetNamespace()).Get(v.PersistentVolumeClaim.ClaimName)
+			if err != nil {
+				if errors.IsNotFound(err) {
+					continue
+				}
+				return nil, err
+			}
+			if pvc.GetAnnotations()[exportAnnotation] == "true" {
+				claims = append(claims, pod.GetNamespace()+"/"+pvc.GetName())
+			}
+		}
+	}
+	return claims, nil
+}
+
+// syncOnDemand decides whether the rsync source of a node is wanted in
+// on-demand mode and sets the replicas of the template accordingly. A
+// source is wanted while an exported claim is mounted on the node and for
+// idleTTL after the last one went away. After that it is scaled to zero or
+// deleted depending on idleAction. It returns when the node has to be
+// synced again.
+func (c *controller) syncOnDemand(ctx context.Context, nodeName string, template *internalv1.RsyncSource) (bool, time.Duration, error) {
+	claims, err := c.exportedClaimsOnNode(nodeName)
+	if err != nil {
+		return false, 0, err
+	}
+	existing, err := c.rsyncSourceLister.Namespace(namespace).Get(template.GetName())
+	if err != nil {
+		if !errors.IsNotFound(err) {
+			return false, 0, err
+		}
+		existing = nil
+	}
+	if len(claims) != 0 {
+		klog.V(4).Infof("Node `%s` has exported claims %v", nodeName, claims)
+		if existing != nil {
+			if _, ok := existing.GetAnnotations()[idleSinceAnnotation]; ok {
+				if err := c.patchRsyncSourceAnnotation(ctx, template.GetName(), idleSinceAnnotation, nil); err != nil {
+					return false, 0, err
+				}
+			}
+		}
+		return true, 0, nil
+	}
+	if existing == nil {
+		return false, 0, nil
+	}
+
+	idleSince := time.Now()
+	if raw, ok := existing.GetAnnotations()[idleSinceAnnotation]; ok {
+		if idleSince, err = time.Parse(time.RFC3339, raw); err != nil {
+			return false, 0, fmt.Errorf("invalid `%s` annotation on rsync source `%s`, error: %s",
+				idleSinceAnnotation, template.GetName(), err)
+		}
+	} else {
+		value := idleSince.UTC().Format(time.RFC3339)
+		if err := c.patchRsyncSourceAnnotation(ctx, template.GetName(), idleSinceAnnotation, &value); err != nil {
+			return false, 0, err
+		}
+	}
+	want, scaleToZero, requeueAfter := idleDecision(idleSince, time.Now())
+	if !want {
+		klog.Infof("Deleting idle rsync source `%s` of node `%s`", template.GetName(), nodeName)
+	}
+	if scaleToZero {
+		var replicas int32
+		template.Spec.Replicas = &replicas
+	}
+	return want, requeueAfter, nil
+}
+
+// idleDecision decides on the rsync source of a node that has been without
+// exported claims since idleSince. The source is kept until idleTTL passed,
+// then it is scaled to zero or deleted depending on idleAction.
+func idleDecision(idleSince, now time.Time) (want, scaleToZero bool, requeueAfter time.Duration) {
+	if remaining := idleTTL - now.Sub(idleSince); remaining > 0 {
+		return true, false, remaining
+	}
+	if idleAction == idleActionDelete {
+		return false, false, 0
+	}
+	return true, true, 0
+}
+
+// patchRsyncSourceAnnotation sets an annotation on a rsync source or
+// removes it if value is nil.
+func (c *controller) patchRsyncSourceAnnotation(ctx context.Context, name, key string, value *string) error {
+	patch, err := json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"annotations": map[string]*string{
+				key: value,
+			},
+		},
+	})
+	if err != nil {
+		return err
+	}
+	_, err = c.dynamicClient.Resource(rsyncSourceGVR).Namespace(namespace).
+		Patch(ctx, name, types.MergePatchType, patch, metav1.PatchOptions{})
+	return err
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestIdleDecision(t *testing.T) {
+	defer func(ttl time.Duration, action string) {
+		idleTTL, idleAction = ttl, action
+	}(idleTTL, idleAction)
+	now := time.Date(2026, 10, 18, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name             string
+		idleAction       string
+		idleSince        time.Time
+		wantWant         bool
+		wantScaleToZero  bool
+		wantRequeueAfter time.Duration
+	}{
+		{
+			name:             "just idle",
+			idleAction:       idleActionScale,
+			idleSince:        now,
+			wantWant:         true,
+			wantRequeueAfter: 10 * time.Minute,
+		},
+		{
+			name:             "within TTL",
+			idleAction:       idleActionDelete,
+			idleSince:        now.Add(-4 * time.Minute),
+			wantWant:         true,
+			wantRequeueAfter: 6 * time.Minute,
+		},
+		{
+			name:            "TTL passed scales to zero",
+			idleAction:      idleActionScale,
+			idleSince:       now.Add(-10 * time.Minute),
+			wantWant:        true,
+			wantScaleToZero: true,
+		},
+		{
+			name:       "TTL passed deletes",
+			idleAction: idleActionDelete,
+			idleSince:  now.Add(-time.Hour),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			idleTTL, idleAction = 10*time.Minute, tt.idleAction
+			want, scaleToZero, requeueAfter := idleDecision(tt.idleSince, now)
+			if want != tt.wantWant || scaleToZero != tt.wantScaleToZero || requeueAfter != tt.wantRequeueAfter {
+				t.Errorf("idleDecision() = %t, %t, %s, want %t, %t, %s", want, scaleToZero, requeueAfter,
+					tt.wantWant, tt.wantScaleToZero, tt.wantRequeueAfter)
+			}
+		})
+	}
+}
+
+func TestPodClaimIndexFunc(t *testing.T) {
+	tests := []struct {
+		name string
+		obj  interface{}
+		want []string
+	}{
+		{
+			name: "claims of the pod namespace",
+			obj: &corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{Namespace: "app"},
+				Spec: corev1.PodSpec{Volumes: []corev1.Volume{
+					{Name: "data", VolumeSource: corev1.VolumeSource{
+						PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{ClaimName: "data"},
+					}},
+					{Name: "tmp", VolumeSource: corev1.VolumeSource{EmptyDir: &corev1.EmptyDirVolumeSource{}}},
+				}},
+			},
+			want: []string{"app/data"},
+		},
+		{
+			name: "no claims",
+			obj:  &corev1.Pod{},
+			want: []string{},
+		},
+		{
+			name: "other object",
+			obj:  &corev1.Node{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := podClaimIndexFunc(tt.obj)
+			if err != nil {
+				t.Fatalf("podClaimIndexFunc() error = %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("podClaimIndexFunc() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
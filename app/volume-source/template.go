@@ -1,26 +1,87 @@
 package main
 
 import (
+	"encoding/json"
+
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
 
 	internalv1 "github.com/k8s-volume-copy/types/apis/demo.io/v1"
 	"github.com/k8s-volume-copy/types/constant"
 )
 
-func getRsyncSourceTemplate(name, hostName string) *internalv1.RsyncSource {
-	cr := &internalv1.RsyncSource{
+// rsyncModule is an additional module of a rsync source. It must match the
+// module definition read by rsync-source.
+type rsyncModule struct {
+	Name     string        `json:"name"`
+	ReadOnly bool          `json:"readOnly"`
+	Volume   corev1.Volume `json:"volume"`
+}
+
+// kubeletPodDirRoot returns the host root served as the data module.
+func kubeletPodDirRoot() hostRoot {
+	return hostRoot{
+		module:         dataModuleName,
+		path:           kubeletPodDirPath,
+		readOnly:       true,
+		nodeAnnotation: hostRootAnnotationPrefix + dataModuleName,
+	}
+}
+
+// getRsyncSourceStub returns a rsync source with just enough metadata to
+// look it up or delete it.
+func getRsyncSourceStub(name string) *internalv1.RsyncSource {
+	return &internalv1.RsyncSource{
 		TypeMeta: metav1.TypeMeta{
 			APIVersion: constant.GroupDemoIO + "/" + constant.VersionV1,
 			Kind:       constant.RsyncSourceKind,
 		},
 		ObjectMeta: metav1.ObjectMeta{
 			Name: name,
-			Labels: map[string]string{
-				constant.CreatedByLabel: "volume-source-controller",
-				constant.NameLabel:      name,
-				constant.AppLabel:       name,
-			},
+		},
+	}
+}
+
+func getRsyncSourceTemplate(nodeName, hostName string, nodeAnnotations map[string]string) (*internalv1.RsyncSource, error) {
+	name := rsyncSourceNameForNode(nodeName)
+	labels := map[string]string{
+		constant.CreatedByLabel: "volume-source-controller",
+		constant.NameLabel:      name,
+		constant.AppLabel:       name,
+	}
+	if len(validation.IsValidLabelValue(nodeName)) == 0 {
+		labels[nodeNameLabel] = nodeName
+	}
+	modules := []rsyncModule{}
+	for _, h := range hostRoots {
+		modules = append(modules, rsyncModule{
+			Name:     h.module,
+			ReadOnly: h.readOnly,
+			Volume:   h.volumeFor(nodeAnnotations),
+		})
+	}
+	annotations := map[string]string{
+		nodeNameAnnotation: nodeName,
+	}
+	if len(modules) != 0 {
+		raw, err := json.Marshal(modules)
+		if err != nil {
+			return nil, err
+		}
+		annotations[modulesAnnotation] = string(raw)
+	}
+	dataVolume := kubeletPodDirRoot().volumeFor(nodeAnnotations)
+	dataVolume.Name = "kubelet-pod-dir"
+	cr := &internalv1.RsyncSource{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: constant.GroupDemoIO + "/" + constant.VersionV1,
+			Kind:       constant.RsyncSourceKind,
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        name,
+			Labels:      labels,
+			Annotations: annotations,
 		},
 		Spec: internalv1.RsyncSourceSpec{
 			Image: rsyncDaemonImage,
@@ -28,18 +89,11 @@ func getRsyncSourceTemplate(name, hostName string) *internalv1.RsyncSource {
 				var i int32 = 1
 				return &i
 			}(),
-			Volume: corev1.Volume{
-				Name: "kubelet-pod-dir",
-				VolumeSource: corev1.VolumeSource{
-					HostPath: &corev1.HostPathVolumeSource{
-						Path: kubeletPodDirPath,
-					},
-				},
-			},
+			Volume:   dataVolume,
 			Username: "user",
 			Password: "pass",
 			HostName: hostName,
 		},
 	}
-	return cr
+	return cr, nil
 }
@@ -0,0 +1,62 @@
+package main
+
+import (
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+
+	"github.com/k8s-volume-copy/types/constant"
+)
+
+const (
+	// hostNameIndex indexes cached nodes by their kubernetes.io/hostname label.
+	hostNameIndex = "hostname"
+)
+
+// hostNameIndexFunc returns the kubernetes.io/hostname label of a node.
+func hostNameIndexFunc(obj interface{}) ([]string, error) {
+	node, ok := obj.(*corev1.Node)
+	if !ok {
+		return nil, nil
+	}
+	hostName, ok := node.GetLabels()[constant.K8SIOHostName]
+	if !ok {
+		return nil, nil
+	}
+	return []string{hostName}, nil
+}
+
+// transformNode keeps only the node metadata the controller works with.
+// Status, spec and managed fields of nodes are large and are never read
+// by this controller, so they are not kept in the informer cache.
+func transformNode(obj interface{}) (interface{}, error) {
+	node, ok := obj.(*corev1.Node)
+	if !ok {
+		return obj, nil
+	}
+	return &corev1.Node{
+		TypeMeta: node.TypeMeta,
+		ObjectMeta: metav1.ObjectMeta{
+			Name:              node.Name,
+			UID:               node.UID,
+			ResourceVersion:   node.ResourceVersion,
+			CreationTimestamp: node.CreationTimestamp,
+			DeletionTimestamp: node.DeletionTimestamp,
+			Labels:            node.Labels,
+			Annotations:       node.Annotations,
+		},
+	}, nil
+}
+
+// transformRsyncSource drops the managed fields and the status of rsync
+// sources before they are stored in the informer cache.
+func transformRsyncSource(obj interface{}) (interface{}, error) {
+	u, ok := obj.(*unstructured.Unstructured)
+	if !ok {
+		return obj, nil
+	}
+	trimmed := u.DeepCopy()
+	trimmed.SetManagedFields(nil)
+	unstructured.RemoveNestedField(trimmed.Object, "status")
+	return trimmed, nil
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+
+	"github.com/k8s-volume-copy/types/constant"
+)
+
+func TestTransformNode(t *testing.T) {
+	deleted := metav1.Now()
+	tests := []struct {
+		name string
+		obj  interface{}
+		want interface{}
+	}{
+		{
+			name: "keeps metadata",
+			obj: &corev1.Node{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:              "node-1",
+					UID:               "uid",
+					ResourceVersion:   "7",
+					DeletionTimestamp: &deleted,
+					Labels:            map[string]string{constant.K8SIOHostName: "node-1"},
+					Annotations:       map[string]string{"demo.io/host-root-local": "/mnt"},
+					ManagedFields:     []metav1.ManagedFieldsEntry{{Manager: "kubelet"}},
+				},
+				Spec: corev1.NodeSpec{PodCIDR: "10.0.0.0/24"},
+				Status: corev1.NodeStatus{
+					Images: []corev1.ContainerImage{{Names: []string{"busybox"}}},
+				},
+			},
+			want: &corev1.Node{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:              "node-1",
+					UID:               "uid",
+					ResourceVersion:   "7",
+					DeletionTimestamp: &deleted,
+					Labels:            map[string]string{constant.K8SIOHostName: "node-1"},
+					Annotations:       map[string]string{"demo.io/host-root-local": "/mnt"},
+				},
+			},
+		},
+		{
+			name: "tombstone passed through",
+			obj:  "node-1",
+			want: "node-1",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := transformNode(tt.obj)
+			if err != nil {
+				t.Fatalf("transformNode() error = %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("transformNode() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHostNameIndexFunc(t *testing.T) {
+	tests := []struct {
+		name string
+		obj  interface{}
+		want []string
+	}{
+		{
+			name: "labeled node",
+			obj: &corev1.Node{ObjectMeta: metav1.ObjectMeta{
+				Labels: map[string]string{constant.K8SIOHostName: "host-1"},
+			}},
+			want: []string{"host-1"},
+		},
+		{
+			name: "unlabeled node",
+			obj:  &corev1.Node{},
+		},
+		{
+			name: "other object",
+			obj:  &corev1.Pod{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := hostNameIndexFunc(tt.obj)
+			if err != nil {
+				t.Fatalf("hostNameIndexFunc() error = %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("hostNameIndexFunc() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTransformRsyncSource(t *testing.T) {
+	u := &unstructured.Unstructured{Object: map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"name":          "node-1-0123456789",
+			"managedFields": []interface{}{map[string]interface{}{"manager": "volume-source"}},
+		},
+		"spec":   map[string]interface{}{"hostName": "node-1"},
+		"status": map[string]interface{}{"phase": "Ready"},
+	}}
+	got, err := transformRsyncSource(u)
+	if err != nil {
+		t.Fatalf("transformRsyncSource() error = %v", err)
+	}
+	want := map[string]interface{}{
+		"metadata": map[string]interface{}{"name": "node-1-0123456789"},
+		"spec":     map[string]interface{}{"hostName": "node-1"},
+	}
+	if !reflect.DeepEqual(got.(*unstructured.Unstructured).Object, want) {
+		t.Errorf("transformRsyncSource() = %v, want %v", got.(*unstructured.Unstructured).Object, want)
+	}
+	if _, ok := u.Object["status"]; !ok {
+		t.Errorf("transformRsyncSource() modified the informer object")
+	}
+}
@@ -1,11 +1,14 @@
 module github.com/k8s-volume-copy/volume-source
 
-go 1.14
+go 1.16
 
 require (
 	github.com/k8s-volume-copy/types v0.0.1
-	k8s.io/api v0.21.2
-	k8s.io/apimachinery v0.21.2
-	k8s.io/client-go v0.21.2
-	k8s.io/klog/v2 v2.8.0
+	github.com/klauspost/compress v1.15.9
+	github.com/prometheus/client_golang v1.12.2
+	github.com/robfig/cron/v3 v3.0.1
+	k8s.io/api v0.24.17
+	k8s.io/apimachinery v0.24.17
+	k8s.io/client-go v0.24.17
+	k8s.io/klog/v2 v2.60.1
 )
@@ -0,0 +1,57 @@
+// Package conditions keeps metav1.Conditions in an annotation. The status
+// of the external RsyncSource type has no conditions field, so the
+// controllers report their conditions on this annotation instead.
+package conditions
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// Annotation holds the conditions of an object as a JSON list of
+// metav1.Condition.
+const Annotation = "demo.io/conditions"
+
+// FromAnnotations returns the conditions stored on an object.
+func FromAnnotations(annotations map[string]string) ([]metav1.Condition, error) {
+	conditions := []metav1.Condition{}
+	raw, ok := annotations[Annotation]
+	if !ok || raw == "" {
+		return conditions, nil
+	}
+	if err := json.Unmarshal([]byte(raw), &conditions); err != nil {
+		return nil, fmt.Errorf("invalid `%s` annotation, error: %s", Annotation, err)
+	}
+	return conditions, nil
+}
+
+// Set returns a copy of the annotations with the conditions set and
+// whether any of them changed. The last transition time of a condition
+// only changes with its status.
+func Set(annotations map[string]string, conditions ...metav1.Condition) (map[string]string, bool, error) {
+	current, err := FromAnnotations(annotations)
+	if err != nil {
+		return nil, false, err
+	}
+	updated := append([]metav1.Condition{}, current...)
+	for _, condition := range conditions {
+		meta.SetStatusCondition(&updated, condition)
+	}
+	result := map[string]string{}
+	for k, v := range annotations {
+		result[k] = v
+	}
+	if reflect.DeepEqual(current, updated) {
+		return result, false, nil
+	}
+	raw, err := json.Marshal(updated)
+	if err != nil {
+		return nil, false, err
+	}
+	result[Annotation] = string(raw)
+	return result, true, nil
+}
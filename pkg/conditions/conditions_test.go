@@ -0,0 +1,77 @@
+package conditions
+
+import (
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestSet(t *testing.T) {
+	ready := metav1.Condition{Type: "Ready", Status: metav1.ConditionTrue, Reason: "Found", Message: "found"}
+	stored, _, err := Set(nil, ready)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name        string
+		annotations map[string]string
+		condition   metav1.Condition
+		changed     bool
+		wantErr     bool
+	}{
+		{
+			name:      "no annotations",
+			condition: ready,
+			changed:   true,
+		},
+		{
+			name:        "same condition",
+			annotations: stored,
+			condition:   ready,
+		},
+		{
+			name:        "new message",
+			annotations: stored,
+			condition:   metav1.Condition{Type: "Ready", Status: metav1.ConditionTrue, Reason: "Found", Message: "other"},
+			changed:     true,
+		},
+		{
+			name:        "new status",
+			annotations: stored,
+			condition:   metav1.Condition{Type: "Ready", Status: metav1.ConditionFalse, Reason: "Missing"},
+			changed:     true,
+		},
+		{
+			name:        "other type",
+			annotations: stored,
+			condition:   metav1.Condition{Type: "Synced", Status: metav1.ConditionTrue, Reason: "Synced"},
+			changed:     true,
+		},
+		{
+			name:        "invalid annotation",
+			annotations: map[string]string{Annotation: "{"},
+			condition:   ready,
+			wantErr:     true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			updated, changed, err := Set(tt.annotations, tt.condition)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Set() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if changed != tt.changed {
+				t.Errorf("Set() changed = %v, want %v", changed, tt.changed)
+			}
+			if err != nil {
+				return
+			}
+			if _, changed, _ := Set(updated, tt.condition); changed {
+				t.Errorf("Set() on its result changed again")
+			}
+			if tt.annotations != nil && tt.annotations[Annotation] != stored[Annotation] {
+				t.Errorf("Set() modified the given annotations")
+			}
+		})
+	}
+}